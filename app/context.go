@@ -0,0 +1,32 @@
+package app
+
+import (
+	"math/rand"
+	"time"
+)
+
+// An ExecCtx holds the time and randomness a command is executed with. It
+// is the same at every replica, so an application can use it instead of
+// the wall clock and its own random numbers without the replicas diverging.
+//
+// Time is the clock of the replica that proposed the command, and never
+// goes backwards, even after a leader change. Seed is random and differs
+// between the commands of a slot. With BatchPaxos, values are not proposed
+// by a single replica: Time does not advance, and Seed is derived from the
+// command.
+type ExecCtx struct {
+	Time time.Time
+	Seed int64
+}
+
+// Rand returns a source of random numbers seeded with Seed.
+func (c ExecCtx) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(c.Seed))
+}
+
+// A ContextHandler is a Handler whose commands depend on time or randomness,
+// e.g. to expire leases. ExecuteWithContext is called instead of Execute.
+// ExecuteBatch is not used for a ContextHandler.
+type ContextHandler interface {
+	ExecuteWithContext(ctx ExecCtx, req []byte) (resp []byte)
+}
@@ -1,5 +1,7 @@
 package app
 
+import "io"
+
 // The Handler interface must be implemented by an implementer of a Goxos replicated
 // service. It must be possible to pass a command to the application through the Execute()
 // method, as well as get and set the state of the application using GetState() and SetState().
@@ -8,3 +10,54 @@ type Handler interface {
 	GetState(slotMarker uint) (sm uint, state []byte)
 	SetState(state []byte) error
 }
+
+// A Reader is a Handler that can answer read-only requests from its current
+// state without the requests being agreed on. Replicas serve bounded-staleness
+// reads only if the application implements Reader. Read is called from the
+// same goroutine as Execute, and must not change the state.
+type Reader interface {
+	Read(req []byte) (resp []byte)
+}
+
+// A PartialReader is a Reader that can answer only some read-only requests,
+// e.g. because it wraps applications of which only some are Readers. Reads
+// for which CanRead returns false are sent to the leader, and ordered like
+// other requests.
+type PartialReader interface {
+	Reader
+	CanRead(req []byte) bool
+}
+
+// A BatchHandler is a Handler that can execute the commands decided together
+// in one call, e.g. to take a lock or commit a storage transaction once per
+// batch. ExecuteBatch must return one response per command, in order, and
+// leave the state as if Execute had been called for each command in turn.
+type BatchHandler interface {
+	ExecuteBatch(reqs [][]byte) (resps [][]byte)
+}
+
+// A KeyedHandler is a Handler that can tell which keys of its state a
+// command reads and writes. Commands that do not conflict, that is, where
+// neither writes a key the other reads or writes, may be executed
+// concurrently, so Execute must be safe for concurrent use by such commands.
+// Keys is called from a single goroutine, in decided order.
+type KeyedHandler interface {
+	Keys(req []byte) (reads, writes []string)
+}
+
+// A Hasher is a Handler that can compute a digest of its state, e.g. a hash
+// of its entries in a fixed order. Replicas compare the digests taken at the
+// same slot to detect that their states have diverged. StateHash is called
+// from the same goroutine as Execute.
+type Hasher interface {
+	StateHash() []byte
+}
+
+// A Streamer is a Handler whose state is too large to be held in memory as a
+// single slice. Its state is written to a stream when it is transferred to
+// a new replica, and restored from one. WriteState is called from the same
+// goroutine as Execute, like GetState.
+type Streamer interface {
+	WriteState(slotMarker uint, w io.Writer) (sm uint, err error)
+	ReadState(r io.Reader) error
+}
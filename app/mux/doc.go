@@ -0,0 +1,26 @@
+/*
+Package mux runs several independent applications on one replica group.
+
+A Mux is an app.Handler holding named applications. It is passed to the
+replica as its application, and every request names the application it is
+for:
+
+	m := mux.New()
+	m.Add("locks", locks)
+	m.Add("config", configStore)
+	r := goxos.NewReplica(id, "services", conf, m)
+
+Clients wrap their requests with Request, or wrap the function sending them
+with Bind:
+
+	do := mux.Bind("locks", c.Do) // c is a *client.Client
+	resp, err := do(ctx, req)
+
+Commands of different applications never conflict, so with parallel
+execution they run concurrently; see app.KeyedHandler. A Mux is an
+app.PartialReader: replicas serve reads for the applications that are
+app.Readers, and send other reads to the leader to be ordered. The state of a Mux,
+which is transferred to new replicas, holds the states of all its
+applications.
+*/
+package mux
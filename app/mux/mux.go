@@ -0,0 +1,234 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/relab/goxos/app"
+)
+
+var (
+	ErrUnknownApp = errors.New("mux: unknown application")
+	ErrBadRequest = errors.New("mux: malformed request")
+	ErrNoReads    = errors.New("mux: application does not serve reads")
+)
+
+// Status of a response, followed by the response of the application for
+// statusOK and by a message otherwise.
+const (
+	statusOK         byte = 0
+	statusUnknown    byte = 1
+	statusBadRequest byte = 2
+	statusNoReads    byte = 3
+)
+
+// A Mux is an app.Handler dispatching requests to the application they
+// name. Applications must be added before the replica is started.
+type Mux struct {
+	apps  map[string]app.Handler
+	names []string // Sorted
+}
+
+// New returns a Mux without applications.
+func New() *Mux {
+	return &Mux{apps: make(map[string]app.Handler)}
+}
+
+// Add adds h as the application called name. Add panics if name is already
+// taken.
+func (m *Mux) Add(name string, h app.Handler) *Mux {
+	if _, found := m.apps[name]; found {
+		panic(fmt.Sprintf("mux: application %q added twice", name))
+	}
+	m.apps[name] = h
+	m.names = append(m.names, name)
+	sort.Strings(m.names)
+	return m
+}
+
+// Request returns req wrapped for the application called name.
+func Request(name string, req []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	b := make([]byte, 0, len(n)+len(name)+len(req))
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(name)))]...)
+	b = append(b, name...)
+	return append(b, req...)
+}
+
+// Response returns the response of the application from resp, the
+// response of a Mux.
+func Response(resp []byte) ([]byte, error) {
+	if len(resp) == 0 {
+		return nil, ErrBadRequest
+	}
+	switch resp[0] {
+	case statusOK:
+		return resp[1:], nil
+	case statusUnknown:
+		return nil, fmt.Errorf("%v %s", ErrUnknownApp, resp[1:])
+	case statusNoReads:
+		return nil, fmt.Errorf("%v %s", ErrNoReads, resp[1:])
+	default:
+		return nil, ErrBadRequest
+	}
+}
+
+// Bind returns a function sending requests for the application called name
+// with do, usually Do of a client.Client, and unwrapping their responses.
+func Bind(name string, do func(ctx context.Context, req []byte) ([]byte, error)) func(ctx context.Context, req []byte) ([]byte, error) {
+	return func(ctx context.Context, req []byte) ([]byte, error) {
+		resp, err := do(ctx, Request(name, req))
+		if err != nil {
+			return nil, err
+		}
+		return Response(resp)
+	}
+}
+
+// route returns the application req is for, and the request for it. A
+// malformed request or unknown application is reported with a response.
+func (m *Mux) route(req []byte) (string, app.Handler, []byte, []byte) {
+	n, size := binary.Uvarint(req)
+	if size <= 0 || uint64(len(req)-size) < n {
+		return "", nil, nil, []byte{statusBadRequest}
+	}
+	name := string(req[size : size+int(n)])
+	h, found := m.apps[name]
+	if !found {
+		return name, nil, nil, append([]byte{statusUnknown}, fmt.Sprintf("%q", name)...)
+	}
+	return name, h, req[size+int(n):], nil
+}
+
+func ok(resp []byte) []byte {
+	return append([]byte{statusOK}, resp...)
+}
+
+// Execute executes req with the application it names.
+func (m *Mux) Execute(req []byte) []byte {
+	_, h, req, fail := m.route(req)
+	if h == nil {
+		return fail
+	}
+	return ok(h.Execute(req))
+}
+
+// ExecuteWithContext executes req with the application it names, passing
+// ctx if it is an app.ContextHandler.
+func (m *Mux) ExecuteWithContext(ctx app.ExecCtx, req []byte) []byte {
+	_, h, req, fail := m.route(req)
+	if h == nil {
+		return fail
+	}
+	if ch, isCtx := h.(app.ContextHandler); isCtx {
+		return ok(ch.ExecuteWithContext(ctx, req))
+	}
+	return ok(h.Execute(req))
+}
+
+// CanRead returns true if the application req names is an app.Reader, so
+// that replicas send other reads to the leader instead of calling Read.
+// Malformed requests and unknown applications are answered by Read.
+func (m *Mux) CanRead(req []byte) bool {
+	_, h, _, _ := m.route(req)
+	if h == nil {
+		return true
+	}
+	_, isReader := h.(app.Reader)
+	return isReader
+}
+
+// Read reads with the application req names, if it is an app.Reader.
+func (m *Mux) Read(req []byte) []byte {
+	name, h, req, fail := m.route(req)
+	if h == nil {
+		return fail
+	}
+	r, isReader := h.(app.Reader)
+	if !isReader {
+		return append([]byte{statusNoReads}, fmt.Sprintf("%q", name)...)
+	}
+	return ok(r.Read(req))
+}
+
+// Keys returns the keys of applications that are app.KeyedHandlers, in a
+// name space of their own. A command of another application writes a key
+// standing for the whole state of the application, so that its commands
+// are executed in order.
+func (m *Mux) Keys(req []byte) (reads, writes []string) {
+	name, h, req, _ := m.route(req)
+	if h == nil {
+		return nil, nil
+	}
+	kh, isKeyed := h.(app.KeyedHandler)
+	if !isKeyed {
+		return nil, []string{name}
+	}
+	r, w := kh.Keys(req)
+	reads, writes = make([]string, len(r)), make([]string, len(w))
+	for i, key := range r {
+		reads[i] = name + "\x00" + key
+	}
+	for i, key := range w {
+		writes[i] = name + "\x00" + key
+	}
+	return reads, writes
+}
+
+// StateHash hashes the names of the applications together with their
+// digests, or with their states for applications that are not app.Hashers.
+func (m *Mux) StateHash() []byte {
+	h := sha256.New()
+	for _, name := range m.names {
+		var digest []byte
+		if hasher, isHasher := m.apps[name].(app.Hasher); isHasher {
+			digest = hasher.StateHash()
+		} else {
+			_, state := m.apps[name].GetState(0)
+			sum := sha256.Sum256(state)
+			digest = sum[:]
+		}
+		fmt.Fprintf(h, "%d:%s%d:", len(name), name, len(digest))
+		h.Write(digest)
+	}
+	return h.Sum(nil)
+}
+
+// GetState returns the states of all applications. The slot marker is
+// passed to each of them.
+func (m *Mux) GetState(slotMarker uint) (uint, []byte) {
+	states := make(map[string][]byte, len(m.apps))
+	for name, h := range m.apps {
+		_, states[name] = h.GetState(slotMarker)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(states); err != nil {
+		panic(fmt.Sprintf("mux: encoding states: %v", err))
+	}
+	return slotMarker, buf.Bytes()
+}
+
+// SetState sets the states of all applications. It fails if the state is
+// missing an application of the Mux.
+func (m *Mux) SetState(state []byte) error {
+	var states map[string][]byte
+	if err := gob.NewDecoder(bytes.NewReader(state)).Decode(&states); err != nil {
+		return err
+	}
+	for _, name := range m.names {
+		s, found := states[name]
+		if !found {
+			return fmt.Errorf("mux: no state for application %q", name)
+		}
+		if err := m.apps[name].SetState(s); err != nil {
+			return fmt.Errorf("mux: setting state of %q: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package mux
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// logApp appends every command to its state, and responds with the state.
+type logApp struct {
+	state string
+}
+
+func (a *logApp) Execute(req []byte) []byte {
+	a.state += string(req)
+	return []byte(a.state)
+}
+
+func (a *logApp) GetState(sm uint) (uint, []byte) { return sm, []byte(a.state) }
+func (a *logApp) SetState(state []byte) error     { a.state = string(state); return nil }
+
+// keyedApp is a logApp whose commands write the key they name.
+type keyedApp struct {
+	logApp
+}
+
+func (a *keyedApp) Keys(req []byte) (reads, writes []string) {
+	return nil, []string{string(req)}
+}
+
+func TestMux(t *testing.T) {
+	a, b := &logApp{}, &keyedApp{}
+	m := New().Add("a", a).Add("b", b)
+	do := func(name string) func(context.Context, []byte) ([]byte, error) {
+		return Bind(name, func(_ context.Context, req []byte) ([]byte, error) {
+			return m.Execute(req), nil
+		})
+	}
+	ctx := context.Background()
+
+	do("a")(ctx, []byte("x"))
+	do("b")(ctx, []byte("y"))
+	if resp, err := do("a")(ctx, []byte("z")); err != nil || string(resp) != "xz" {
+		t.Errorf("a responded %q, %v; want %q", resp, err, "xz")
+	}
+	if a.state != "xz" || b.state != "y" {
+		t.Errorf("states are %q and %q, want %q and %q", a.state, b.state, "xz", "y")
+	}
+	if _, err := do("c")(ctx, []byte("x")); err == nil || !strings.HasPrefix(err.Error(), ErrUnknownApp.Error()) {
+		t.Errorf("unknown application returned %v", err)
+	}
+	if m.CanRead(Request("a", nil)) {
+		t.Error("application without reads can read")
+	}
+	if _, err := Response(m.Read(Request("a", nil))); err == nil || !strings.HasPrefix(err.Error(), ErrNoReads.Error()) {
+		t.Errorf("read from application without reads returned %v", err)
+	}
+
+	for _, c := range []struct {
+		req    []byte
+		writes []string
+	}{
+		{Request("a", []byte("k")), []string{"a"}},
+		{Request("b", []byte("k")), []string{"b\x00k"}},
+		{[]byte{9}, nil},
+	} {
+		if _, writes := m.Keys(c.req); !reflect.DeepEqual(writes, c.writes) {
+			t.Errorf("request %q writes %q, want %q", c.req, writes, c.writes)
+		}
+	}
+
+	a2, b2 := &logApp{}, &keyedApp{}
+	m2 := New().Add("b", b2).Add("a", a2)
+	if _, state := m.GetState(0); m2.SetState(state) != nil {
+		t.Fatal("setting state failed")
+	}
+	if a2.state != "xz" || b2.state != "y" {
+		t.Errorf("restored states are %q and %q, want %q and %q", a2.state, b2.state, "xz", "y")
+	}
+	if string(m.StateHash()) != string(m2.StateHash()) {
+		t.Error("state digests differ after state transfer")
+	}
+	if _, state := New().Add("a", &logApp{}).GetState(0); m2.SetState(state) == nil {
+		t.Error("state without every application was accepted")
+	}
+}
@@ -0,0 +1,28 @@
+/*
+Package router routes the commands of a replicated service to typed handler
+functions, so that a service needs no hand-written marshalling.
+
+A service registers a handler for each of its commands with a Router, and
+delegates Execute (or ExecuteWithContext) of its app.Handler to the Router.
+A command is identified by a name, or by a number (an opcode) to keep
+requests small. A handler is a function taking the argument of the command
+and returning its result and an error, optionally with an app.ExecCtx as the
+first argument:
+
+	r := router.New(router.GobCodec{})
+	r.Handle(router.Name("put"), func(args PutArgs) (bool, error) { ... })
+	r.Handle(router.Op(1), func(ctx app.ExecCtx, key string) ([]byte, error) { ... })
+
+Arguments and results are encoded with a Codec. Errors returned by a handler
+are sent to the client as a CommandError, and are not replicated state.
+
+Clients call the commands through a Stub made from the same Router, which
+checks the types of the arguments and results against the registered
+handlers. Bind makes a typed function for a command:
+
+	stub := r.Stub(c.Do) // c is a *client.Client
+	var put func(context.Context, PutArgs) (bool, error)
+	stub.Bind(router.Name("put"), &put)
+	ok, err := put(ctx, PutArgs{Key: "k", Value: []byte("v")})
+*/
+package router
@@ -0,0 +1,245 @@
+package router
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/relab/goxos/app"
+)
+
+var (
+	ErrUnknownCommand = errors.New("router: unknown command")
+	ErrBadRequest     = errors.New("router: malformed request")
+)
+
+// A CommandError is the error returned by the handler of a command.
+type CommandError struct {
+	Msg string
+}
+
+func (e *CommandError) Error() string {
+	return e.Msg
+}
+
+// A Cmd identifies a command, either by name or by opcode.
+type Cmd struct {
+	name  string
+	op    uint32
+	named bool
+}
+
+// Name returns the command called name.
+func Name(name string) Cmd {
+	return Cmd{name: name, named: true}
+}
+
+// Op returns the command with opcode op.
+func Op(op uint32) Cmd {
+	return Cmd{op: op}
+}
+
+func (c Cmd) String() string {
+	if c.named {
+		return strconv.Quote(c.name)
+	}
+	return "op " + strconv.FormatUint(uint64(c.op), 10)
+}
+
+// Tags of a request, telling how the command is identified.
+const (
+	tagName byte = 1
+	tagOp   byte = 2
+)
+
+// Status of a response, followed by the encoded result for statusOK and by
+// a message otherwise.
+const (
+	statusOK         byte = 0
+	statusError      byte = 1
+	statusUnknown    byte = 2
+	statusBadRequest byte = 3
+)
+
+// appendCmd appends the encoding of c to b.
+func appendCmd(b []byte, c Cmd) []byte {
+	var n [binary.MaxVarintLen64]byte
+	if c.named {
+		b = append(b, tagName)
+		b = append(b, n[:binary.PutUvarint(n[:], uint64(len(c.name)))]...)
+		return append(b, c.name...)
+	}
+	b = append(b, tagOp)
+	return append(b, n[:binary.PutUvarint(n[:], uint64(c.op))]...)
+}
+
+// parseCmd splits req into its command and the encoded argument.
+func parseCmd(req []byte) (Cmd, []byte, error) {
+	if len(req) == 0 {
+		return Cmd{}, nil, ErrBadRequest
+	}
+	v, n := binary.Uvarint(req[1:])
+	if n <= 0 {
+		return Cmd{}, nil, ErrBadRequest
+	}
+	rest := req[1+n:]
+	switch req[0] {
+	case tagName:
+		if uint64(len(rest)) < v {
+			return Cmd{}, nil, ErrBadRequest
+		}
+		return Name(string(rest[:v])), rest[v:], nil
+	case tagOp:
+		if v > 1<<32-1 {
+			return Cmd{}, nil, ErrBadRequest
+		}
+		return Op(uint32(v)), rest, nil
+	}
+	return Cmd{}, nil, ErrBadRequest
+}
+
+var (
+	ctxType   = reflect.TypeOf(app.ExecCtx{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// A handler is a registered command.
+type handler struct {
+	fn      reflect.Value // Invalid if only declared
+	withCtx bool
+	arg     reflect.Type
+	result  reflect.Type
+}
+
+// newHandler checks that fn is a handler function and returns its
+// signature.
+func newHandler(cmd Cmd, fn interface{}) (*handler, error) {
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("router: handler of %v is a %T, not a function", cmd, fn)
+	}
+	h := &handler{fn: reflect.ValueOf(fn)}
+	in := 0
+	if t.NumIn() == 2 && t.In(0) == ctxType {
+		h.withCtx = true
+		in = 1
+	}
+	if t.NumIn() != in+1 || t.IsVariadic() {
+		return nil, fmt.Errorf("router: handler of %v must take one argument, optionally after an app.ExecCtx", cmd)
+	}
+	if t.NumOut() != 2 || t.Out(1) != errorType {
+		return nil, fmt.Errorf("router: handler of %v must return a result and an error", cmd)
+	}
+	h.arg, h.result = t.In(in), t.Out(0)
+	return h, nil
+}
+
+// A Router executes requests by passing their argument to the handler
+// registered for their command. Handlers must be registered before the
+// Router executes requests or makes stubs; after that it is safe for
+// concurrent use.
+type Router struct {
+	codec Codec
+	names map[string]*handler
+	ops   map[uint32]*handler
+}
+
+// New returns a Router encoding arguments and results with codec.
+func New(codec Codec) *Router {
+	return &Router{
+		codec: codec,
+		names: make(map[string]*handler),
+		ops:   make(map[uint32]*handler),
+	}
+}
+
+// Handle registers fn as the handler of cmd. fn has one of the forms
+//
+//	func(arg A) (B, error)
+//	func(ctx app.ExecCtx, arg A) (B, error)
+//
+// Handle panics if fn has another form, or if cmd already has a handler.
+func (r *Router) Handle(cmd Cmd, fn interface{}) {
+	if v := reflect.ValueOf(fn); v.Kind() == reflect.Func && v.IsNil() {
+		panic(fmt.Sprintf("router: nil handler for %v", cmd))
+	}
+	r.register(cmd, fn)
+}
+
+// Declare registers the signature of the handler of cmd, without a handler,
+// so that a client can make a Stub without the implementation of the
+// service. fn is a function, usually nil, of a form accepted by Handle:
+//
+//	r.Declare(router.Name("put"), (func(PutArgs) (bool, error))(nil))
+//
+// A Router executing requests responds to a declared command as to an
+// unknown one.
+func (r *Router) Declare(cmd Cmd, fn interface{}) {
+	h := r.register(cmd, fn)
+	h.fn = reflect.Value{}
+}
+
+func (r *Router) register(cmd Cmd, fn interface{}) *handler {
+	h, err := newHandler(cmd, fn)
+	if err != nil {
+		panic(err.Error())
+	}
+	if r.lookup(cmd) != nil {
+		panic(fmt.Sprintf("router: %v registered twice", cmd))
+	}
+	if cmd.named {
+		r.names[cmd.name] = h
+	} else {
+		r.ops[cmd.op] = h
+	}
+	return h
+}
+
+func (r *Router) lookup(cmd Cmd) *handler {
+	if cmd.named {
+		return r.names[cmd.name]
+	}
+	return r.ops[cmd.op]
+}
+
+// Execute executes req with the zero ExecCtx. It implements Execute of
+// app.Handler.
+func (r *Router) Execute(req []byte) []byte {
+	return r.ExecuteWithContext(app.ExecCtx{}, req)
+}
+
+// ExecuteWithContext executes req, passing ctx to handlers that take it. It
+// implements app.ContextHandler.
+func (r *Router) ExecuteWithContext(ctx app.ExecCtx, req []byte) []byte {
+	cmd, data, err := parseCmd(req)
+	if err != nil {
+		return failure(statusBadRequest, err.Error())
+	}
+	h := r.lookup(cmd)
+	if h == nil || !h.fn.IsValid() {
+		return failure(statusUnknown, cmd.String())
+	}
+	arg := reflect.New(h.arg)
+	if err := r.codec.Unmarshal(data, arg.Interface()); err != nil {
+		return failure(statusBadRequest, fmt.Sprintf("decoding argument of %v: %v", cmd, err))
+	}
+	args := []reflect.Value{arg.Elem()}
+	if h.withCtx {
+		args = []reflect.Value{reflect.ValueOf(ctx), arg.Elem()}
+	}
+	out := h.fn.Call(args)
+	if err, _ := out[1].Interface().(error); err != nil {
+		return failure(statusError, err.Error())
+	}
+	result, err := r.codec.Marshal(out[0].Interface())
+	if err != nil {
+		return failure(statusError, fmt.Sprintf("encoding result of %v: %v", cmd, err))
+	}
+	return append([]byte{statusOK}, result...)
+}
+
+func failure(status byte, msg string) []byte {
+	return append([]byte{status}, msg...)
+}
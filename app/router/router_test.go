@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/app"
+)
+
+type putArgs struct {
+	Key   string
+	Value string
+}
+
+// newKV returns a router for a small key-value store.
+func newKV(codec Codec) *Router {
+	store := make(map[string]string)
+	r := New(codec)
+	r.Handle(Name("put"), func(args putArgs) (bool, error) {
+		_, found := store[args.Key]
+		store[args.Key] = args.Value
+		return !found, nil
+	})
+	r.Handle(Op(1), func(key string) (string, error) {
+		v, found := store[key]
+		if !found {
+			return "", errors.New("no such key: " + key)
+		}
+		return v, nil
+	})
+	r.Handle(Name("now"), func(ctx app.ExecCtx, _ int) (int64, error) {
+		return ctx.Time.UnixNano(), nil
+	})
+	return r
+}
+
+// local returns a Stub executing requests at r directly.
+func local(r *Router) *Stub {
+	return r.Stub(func(_ context.Context, req []byte) ([]byte, error) {
+		return r.ExecuteWithContext(app.ExecCtx{Time: time.Unix(0, 42)}, req), nil
+	})
+}
+
+func TestRouter(t *testing.T) {
+	for _, codec := range []Codec{GobCodec{}, JSONCodec{}} {
+		stub := local(newKV(codec))
+		ctx := context.Background()
+
+		var put func(context.Context, putArgs) (bool, error)
+		stub.Bind(Name("put"), &put)
+		if added, err := put(ctx, putArgs{"a", "1"}); err != nil || !added {
+			t.Errorf("%T: put = %v, %v; want true, nil", codec, added, err)
+		}
+		if added, err := put(ctx, putArgs{"a", "2"}); err != nil || added {
+			t.Errorf("%T: put = %v, %v; want false, nil", codec, added, err)
+		}
+
+		var v string
+		if err := stub.Call(ctx, Op(1), "a", &v); err != nil || v != "2" {
+			t.Errorf("%T: get = %q, %v; want \"2\", nil", codec, v, err)
+		}
+		err := stub.Call(ctx, Op(1), "b", &v)
+		if ce, ok := err.(*CommandError); !ok || ce.Msg != "no such key: b" {
+			t.Errorf("%T: get of missing key returned %v", codec, err)
+		}
+
+		var now int64
+		if err := stub.Call(ctx, Name("now"), 0, &now); err != nil || now != 42 {
+			t.Errorf("%T: now = %v, %v; want 42, nil", codec, now, err)
+		}
+	}
+}
+
+func TestStubChecks(t *testing.T) {
+	r := newKV(GobCodec{})
+	stub := local(r)
+	ctx := context.Background()
+
+	if err := stub.Call(ctx, Name("get"), "a", nil); err != ErrUnknownCommand {
+		t.Errorf("unregistered command returned %v, want %v", err, ErrUnknownCommand)
+	}
+	if err := stub.Call(ctx, Op(1), 1, nil); err == nil {
+		t.Error("argument of wrong type was accepted")
+	}
+	var n int
+	if err := stub.Call(ctx, Op(1), "a", &n); err == nil {
+		t.Error("result of wrong type was accepted")
+	}
+
+	// A client declaring a command the service does not handle
+	client := New(GobCodec{})
+	client.Declare(Op(2), (func(string) (string, error))(nil))
+	declared := client.Stub(func(_ context.Context, req []byte) ([]byte, error) {
+		return r.Execute(req), nil
+	})
+	if err := declared.Call(ctx, Op(2), "a", nil); err != ErrUnknownCommand {
+		t.Errorf("command unknown to the service returned %v, want %v", err, ErrUnknownCommand)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("binding a function of wrong type did not panic")
+		}
+	}()
+	var get func(context.Context, string) (int, error)
+	stub.Bind(Op(1), &get)
+}
+
+func TestMalformedRequest(t *testing.T) {
+	r := newKV(GobCodec{})
+	for _, req := range [][]byte{nil, {tagName}, {tagName, 5, 'p'}, {9, 1}} {
+		if resp := r.Execute(req); len(resp) == 0 || resp[0] != statusBadRequest {
+			t.Errorf("request %v: response %v, want status %v", req, resp, statusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// A Stub calls the commands of a Router at the replicas. It checks the
+// types of arguments and results against those of the registered handlers
+// before sending a request.
+type Stub struct {
+	r  *Router
+	do func(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// Stub returns a Stub sending requests with do, usually Do of a
+// client.Client. Handlers registered with r after Stub returns are not
+// known to the Stub.
+func (r *Router) Stub(do func(ctx context.Context, req []byte) ([]byte, error)) *Stub {
+	return &Stub{r: r, do: do}
+}
+
+// Call calls cmd with arg, and decodes its result into result, which must
+// be a pointer to the result type of the handler, or nil to discard it. An
+// error returned by the handler is returned as a *CommandError.
+func (s *Stub) Call(ctx context.Context, cmd Cmd, arg, result interface{}) error {
+	h := s.r.lookup(cmd)
+	if h == nil {
+		return ErrUnknownCommand
+	}
+	if t := reflect.TypeOf(arg); t == nil || !t.AssignableTo(h.arg) {
+		return fmt.Errorf("router: argument of %v is a %T, not a %v", cmd, arg, h.arg)
+	}
+	if result != nil && reflect.TypeOf(result) != reflect.PtrTo(h.result) {
+		return fmt.Errorf("router: result of %v is a %v, not a %T", cmd, h.result, result)
+	}
+	return s.call(ctx, cmd, arg, result)
+}
+
+func (s *Stub) call(ctx context.Context, cmd Cmd, arg, result interface{}) error {
+	data, err := s.r.codec.Marshal(arg)
+	if err != nil {
+		return fmt.Errorf("router: encoding argument of %v: %v", cmd, err)
+	}
+	resp, err := s.do(ctx, append(appendCmd(nil, cmd), data...))
+	if err != nil {
+		return err
+	}
+	if len(resp) == 0 {
+		return ErrBadRequest
+	}
+	switch resp[0] {
+	case statusOK:
+		if result == nil {
+			return nil
+		}
+		if err := s.r.codec.Unmarshal(resp[1:], result); err != nil {
+			return fmt.Errorf("router: decoding result of %v: %v", cmd, err)
+		}
+		return nil
+	case statusError:
+		return &CommandError{Msg: string(resp[1:])}
+	case statusUnknown:
+		return ErrUnknownCommand
+	default:
+		return fmt.Errorf("%v: %s", ErrBadRequest, resp[1:])
+	}
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// Bind sets the function fnPtr points to, to one calling cmd. For a handler
+// of the form func(A) (B, error), fnPtr is a *func(context.Context, A) (B,
+// error). Bind panics if cmd is unknown or if the types differ from those of
+// the handler.
+func (s *Stub) Bind(cmd Cmd, fnPtr interface{}) {
+	h := s.r.lookup(cmd)
+	if h == nil {
+		panic(fmt.Sprintf("router: binding unknown command %v", cmd))
+	}
+	want := reflect.FuncOf(
+		[]reflect.Type{contextType, h.arg},
+		[]reflect.Type{h.result, errorType},
+		false,
+	)
+	p := reflect.ValueOf(fnPtr)
+	if p.Kind() != reflect.Ptr || p.Elem().Type() != want {
+		panic(fmt.Sprintf("router: binding %v to a %T, not a *%v", cmd, fnPtr, want))
+	}
+	p.Elem().Set(reflect.MakeFunc(want, func(in []reflect.Value) []reflect.Value {
+		ctx, _ := in[0].Interface().(context.Context)
+		result := reflect.New(h.result)
+		err := s.call(ctx, cmd, in[1].Interface(), result.Interface())
+		errv := reflect.Zero(errorType)
+		if err != nil {
+			errv = reflect.ValueOf(&err).Elem()
+		}
+		return []reflect.Value{result.Elem(), errv}
+	}))
+}
@@ -1,6 +1,9 @@
 package app
 
 import (
+	"os"
+
+	"github.com/relab/goxos/grp"
 	"github.com/relab/goxos/paxos"
 )
 
@@ -16,11 +19,35 @@ func (sr *StateReq) RespChan() chan<- State {
 	return sr.respChan
 }
 
+// State is a snapshot of the application state at SlotMarker. Sessions holds
+// the server's encoded client session table at the same slot, and Clock the
+// time of the last stamped command executed, in Unix nanoseconds. Version is
+// the application version that wrote the state, see Versions. TransferTo and
+// TransferEpoch are the latest leadership transfer the server accepted. If
+// File is not empty, the state was written by a Streamer to that local file
+// instead of being held in State.
 type State struct {
-	SlotMarker paxos.SlotID
-	State      []byte
+	SlotMarker    paxos.SlotID
+	State         []byte
+	Sessions      []byte
+	Clock         int64
+	Version       uint32
+	TransferTo    grp.ID
+	TransferEpoch uint64
+	File          string
 }
 
 func NewState(slotMarker paxos.SlotID, state []byte) State {
-	return State{slotMarker, state}
+	return State{SlotMarker: slotMarker, State: state}
+}
+
+// Remove removes the file holding a streamed state. It is called when the
+// state is no longer needed.
+func (s *State) Remove() error {
+	if s.File == "" {
+		return nil
+	}
+	err := os.Remove(s.File)
+	s.File = ""
+	return err
 }
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Versions holds the versions of the application logic a replica can run.
+// It is passed to the replica as its Handler, and executes commands with
+// the current version. The version is changed by an upgrade decided
+// through the log, so that every replica switches at the same slot; a
+// replica stops if it is asked to switch to a version it does not have,
+// and a new replica does not start if it lacks the version of the state it
+// receives. Every version needed by the group must therefore be rolled out
+// to every replica before the upgrade is proposed.
+//
+// The optional interfaces of this package are those of the current
+// version. If the version is upgraded to is a different Handler, the state
+// is moved from the old one with GetState and SetState.
+type Versions struct {
+	mu       sync.RWMutex
+	handlers map[uint32]Handler
+	version  uint32
+}
+
+// NewVersions returns a Versions with h as version. A new group starts with
+// this version.
+func NewVersions(version uint32, h Handler) *Versions {
+	return &Versions{handlers: map[uint32]Handler{version: h}, version: version}
+}
+
+// Add adds h as version. Add panics if version is already added.
+func (v *Versions) Add(version uint32, h Handler) *Versions {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, found := v.handlers[version]; found {
+		panic(fmt.Sprintf("app: version %d added twice", version))
+	}
+	v.handlers[version] = h
+	return v
+}
+
+// Has returns true if version has been added.
+func (v *Versions) Has(version uint32) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	_, found := v.handlers[version]
+	return found
+}
+
+// List returns the versions that have been added, in increasing order.
+func (v *Versions) List() []uint32 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	list := make([]uint32, 0, len(v.handlers))
+	for version := range v.handlers {
+		list = append(list, version)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// Current returns the current version and its Handler.
+func (v *Versions) Current() (uint32, Handler) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.version, v.handlers[v.version]
+}
+
+// Select makes version current without moving the state, for a replica
+// that is about to set the state of version.
+func (v *Versions) Select(version uint32) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, found := v.handlers[version]; !found {
+		return UnknownVersionError(version)
+	}
+	v.version = version
+	return nil
+}
+
+// Upgrade makes version current, moving the state to it. It must not be
+// called concurrently with the methods of the handlers.
+func (v *Versions) Upgrade(version uint32) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	next, found := v.handlers[version]
+	if !found {
+		return UnknownVersionError(version)
+	}
+	if prev := v.handlers[v.version]; prev != next {
+		_, state := prev.GetState(0)
+		if err := next.SetState(state); err != nil {
+			return fmt.Errorf("app: moving state from version %d to %d: %v", v.version, version, err)
+		}
+	}
+	v.version = version
+	return nil
+}
+
+// Execute executes req with the current version.
+func (v *Versions) Execute(req []byte) []byte {
+	_, h := v.Current()
+	return h.Execute(req)
+}
+
+// GetState returns the state of the current version.
+func (v *Versions) GetState(slotMarker uint) (uint, []byte) {
+	_, h := v.Current()
+	return h.GetState(slotMarker)
+}
+
+// SetState sets the state of the current version.
+func (v *Versions) SetState(state []byte) error {
+	_, h := v.Current()
+	return h.SetState(state)
+}
+
+// An UnknownVersionError is returned for a version that has not been added.
+type UnknownVersionError uint32
+
+func (e UnknownVersionError) Error() string {
+	return fmt.Sprintf("app: version %d is not available", uint32(e))
+}
@@ -181,7 +181,7 @@ func (rh *AReconfHandler) registerAndSubscribe() {
 	rh.dmx.RegisterChannel(acch)
 	rh.activationChan = acch
 
-	rh.fdChan = rh.fd.SubscribeToFdMsgs("ar")
+	rh.fdChan = rh.fd.SubscribeToConfirmedFdMsgs("ar")
 	rh.repLdChan = rh.ld.SubscribeToReplacementLdMsgs("ar")
 }
 
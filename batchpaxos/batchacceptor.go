@@ -35,6 +35,7 @@ type BatchAcceptor struct {
 	ureqChan     <-chan UpdateRequestMsg
 	urepChan     <-chan UpdateReplyMsg
 	dcdChan      chan<- *px.Value
+	rejectChan   chan<- *client.Request
 	dmx          net.Demuxer
 	grpmgr       grp.GroupManager
 	stop         chan bool
@@ -61,6 +62,7 @@ func NewBatchAcceptor(pp px.Pack) *BatchAcceptor {
 		dmx:          pp.Dmx,
 		grpmgr:       pp.Gm,
 		dcdChan:      pp.DcdChan,
+		rejectChan:   pp.RejectChan,
 		propChan:     pp.PropChan,
 		batcher:      NewBatcher(),
 		batchpter:    NewBatchPointer(),
@@ -182,7 +184,16 @@ func (ba *BatchAcceptor) handleRequest(val px.Value) error {
 	ba.recvtime[req[0].GetSeq()] = time.Now()
 
 	batcher := ba.batcher
-	batcher.LogRequest(*req[0])
+	if !batcher.LogRequest(*req[0]) {
+		// The server answers SESSION_EXPIRED, so that the client
+		// starts a new session
+		select {
+		case ba.rejectChan <- req[0]:
+		default:
+			glog.Warningln("dropping unbatchable request from", req[0].GetId())
+		}
+		return nil
+	}
 
 	ba.currNumReq = (ba.currNumReq + 1) % ba.batchMaxReq
 
@@ -343,6 +354,11 @@ func (ba *BatchAcceptor) execute(req client.Request) {
 	if glog.V(3) {
 		glog.Info("sent to server")
 	}
+	if req.GetType() == client.Request_EXPIRE {
+		if ids, err := req.ExpiredIDs(); err == nil {
+			ba.batcher.Forget(ids)
+		}
+	}
 	exectime := time.Now()
 	recvtime := ba.recvtime[req.GetSeq()]
 	ba.exectime[req.GetSeq()] = exectime
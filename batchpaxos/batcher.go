@@ -56,13 +56,30 @@ func (br *Batcher) GetLog(cid string) *ClientLogEntry {
 	return br.CLog[cid]
 }
 
+// Forget removes the logs of the given clients. It is called when the expiry
+// of their sessions is executed.
+func (br *Batcher) Forget(cids []string) {
+	for _, cid := range cids {
+		delete(br.CLog, cid)
+	}
+}
+
 // LogRequest will add the request to the log, indexed by the id of the client
-// sending the request, as well as the sequence number.
-func (br *Batcher) LogRequest(req client.Request) {
-	id := req.GetId()
-	seq := req.GetSeq()
+// sending the request, as well as the sequence number. It returns false if
+// the request cannot be logged: batches hold the requests of a client from
+// seq 0 on, so a later request from a client whose earlier requests we have
+// not seen could never be batched. Such a client must start a new session.
+func (br *Batcher) LogRequest(req client.Request) bool {
+	if _, exists := br.CLog[req.GetId()]; !exists && req.GetSeq() > 0 {
+		return false
+	}
+	br.logRequest(req)
+	return true
+}
 
-	clog := br.GetLog(id)
+func (br *Batcher) logRequest(req client.Request) {
+	seq := req.GetSeq()
+	clog := br.GetLog(req.GetId())
 	clog.Values[seq] = req
 
 	if seq > clog.HighestSeen {
@@ -210,7 +227,7 @@ func (br *Batcher) GetClientCommands(ranges RangeMap) map[string][]client.Reques
 func (br *Batcher) SetClientCommands(cmdmap UpdateValueMap) {
 	for _, cmds := range cmdmap {
 		for _, cmd := range cmds {
-			br.LogRequest(cmd)
+			br.logRequest(cmd)
 		}
 	}
 }
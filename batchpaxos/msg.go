@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/net"
 )
 
 func init() {
@@ -12,6 +13,8 @@ func init() {
 
 	gob.Register(UpdateRequestMsg{})
 	gob.Register(UpdateReplyMsg{})
+
+	net.SetClass(UpdateReplyMsg{}, net.BulkClass)
 }
 
 // Main protocol
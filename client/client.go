@@ -0,0 +1,700 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/config"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/code.google.com/p/goprotobuf/proto"
+)
+
+var (
+	ErrRedirectsExhausted = errors.New("too many redirects while looking for the leader")
+	ErrTimeout            = errors.New("request timed out")
+	ErrOldCommand         = errors.New("replica reported the request as an old command")
+	ErrClusterUnavailable = errors.New("cannot contact any node in cluster")
+	ErrClientClosed       = errors.New("client is closed")
+	ErrSessionExpired     = errors.New("client session has expired")
+	ErrRequestTooLarge    = errors.New("request is larger than the replicas accept")
+)
+
+// A ReplicaError is returned for responses with an error code that has no
+// error of its own.
+type ReplicaError struct {
+	Code   Response_Error
+	Detail string
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica error %v: %s", e.Code, e.Detail)
+}
+
+// A Logger is used by the Client to log connection events. *log.Logger
+// satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// A Client sends requests to a Goxos service. Unlike ServiceConn, a Client
+// honors the cancellation and deadline of the context of each request, and
+// may be used by many goroutines at once. A Client keeps a single connection
+// to the service, which is reestablished when lost or when the replica
+// redirects us to the leader. Outstanding requests are then resent.
+//
+// Requests sent concurrently are written together in BATCH frames, and at
+// most clientWindow requests are outstanding at a time. Responses may arrive
+// in any order. If the session of the Client expires, it starts a new one
+// with a new id, in which the outstanding requests are resent.
+type Client struct {
+	id                string // Guarded by mu
+	readID            string // Client id of reads
+	conf              *config.Config
+	logger            Logger
+	maxRedirects      int
+	resendInterval    time.Duration
+	keepaliveInterval time.Duration
+	batchMaxSize      int
+	batchLinger       time.Duration
+	window            chan struct{} // Holds a token per outstanding request
+	stop              chan struct{} // Closed by Close
+
+	dialMu sync.Mutex // Serializes connection setup
+	mu     sync.Mutex // Guards the fields below
+	nodes  []string
+	epoch  uint64 // Membership epoch of nodes
+	next   int    // Index in nodes of the next node to connect to
+	conn   net.Conn
+	addr   string // Node address of conn
+	gen    uint64 // Incremented for every new connection
+	seq    uint32
+	reqs   map[uint32]*Future
+	closed bool
+	sent   time.Time // When we last sent a request
+
+	readConn *readConn // Guarded by mu
+
+	writeMu    sync.Mutex // Serializes writes to conn
+	readMu     sync.Mutex // Serializes writes to readConn
+	readDialMu sync.Mutex // Serializes read connection setup
+	batchMu    sync.Mutex // Guards the fields below
+	pending    []*pendingWrite
+	flushing   bool
+}
+
+// NewClient returns a Client for the service with the nodes given in conf.
+// The connection is established by the first request. If logger is nil,
+// nothing is logged.
+func NewClient(conf *config.Config, logger Logger) (*Client, error) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	readID, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	nodeMap, err := conf.GetNodeMap("nodes")
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		id:                id,
+		readID:            readID,
+		conf:              conf,
+		logger:            logger,
+		maxRedirects:      conf.GetInt("maxRedirects", config.DefMaxRedirects),
+		resendInterval:    conf.GetDuration("resendInterval", config.DefResendInterval),
+		keepaliveInterval: conf.GetDuration("keepaliveInterval", config.DefKeepaliveInterval),
+		batchMaxSize:      conf.GetInt("clientBatchMaxSize", config.DefClientBatchMaxSize),
+		batchLinger:       conf.GetDuration("clientBatchLinger", config.DefClientBatchLinger),
+		stop:              make(chan struct{}),
+		reqs:              make(map[uint32]*Future),
+	}
+	if window := conf.GetInt("clientWindow", config.DefClientWindow); window > 0 {
+		c.window = make(chan struct{}, window)
+	}
+	for _, node := range nodeMap.Nodes() {
+		c.nodes = append(c.nodes, node.ClientAddr())
+	}
+	if len(c.nodes) == 0 {
+		return nil, ErrClusterUnavailable
+	}
+	if c.keepaliveInterval > 0 {
+		go c.keepalive()
+	}
+	return c, nil
+}
+
+// Do sends the request to the service and waits for the response.
+func (c *Client) Do(ctx context.Context, request []byte) ([]byte, error) {
+	return c.Go(ctx, request).Result()
+}
+
+// Go sends the request to the service without waiting for the response.
+func (c *Client) Go(ctx context.Context, request []byte) *Future {
+	f := &Future{
+		done:   make(chan struct{}),
+		resend: make(chan struct{}, 1),
+		retry:  make(chan time.Duration, 1),
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		f.complete(nil, ErrClientClosed)
+		return f
+	}
+	seq := c.seq
+	c.seq++
+	// Acknowledge the responses to all requests below the oldest
+	// outstanding one, so that the replicas can forget them.
+	ack := seq
+	for s := range c.reqs {
+		if s < ack {
+			ack = s
+		}
+	}
+	id := c.id
+	f.req = &Request{
+		Type: Request_EXEC.Enum(),
+		Id:   &id,
+		Seq:  &seq,
+		Val:  request,
+		Ack:  &ack,
+	}
+	f.sendTime = time.Now()
+	c.reqs[seq] = f
+	c.mu.Unlock()
+
+	go c.run(ctx, f)
+	return f
+}
+
+// Close closes the connection to the service. Outstanding requests fail with
+// ErrClientClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		close(c.stop)
+	}
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	rc := c.readConn
+	reqs := c.reqs
+	c.reqs = make(map[uint32]*Future)
+	c.mu.Unlock()
+
+	if rc != nil {
+		c.dropReadConn(rc, ErrClientClosed)
+	}
+
+	for _, f := range reqs {
+		f.complete(nil, ErrClientClosed)
+	}
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// run sends the request of f, and resends it on a new connection or after
+// resendInterval, until f completes or ctx is done.
+func (c *Client) run(ctx context.Context, f *Future) {
+	defer c.forget(f)
+	if c.window != nil {
+		select {
+		case c.window <- struct{}{}:
+			defer func() { <-c.window }()
+		case <-f.done:
+			return
+		case <-ctx.Done():
+			f.complete(nil, contextError(ctx))
+			return
+		}
+	}
+	resend := time.NewTimer(c.resendInterval)
+	defer resend.Stop()
+	for {
+		c.mu.Lock()
+		req := f.req // Replaced if the session is renewed
+		c.mu.Unlock()
+		if err := c.send(ctx, req); err != nil {
+			if ctx.Err() != nil {
+				err = contextError(ctx)
+			}
+			f.complete(nil, err)
+			return
+		}
+		resend.Reset(c.resendInterval)
+		select {
+		case <-f.done:
+			return
+		case <-ctx.Done():
+			f.complete(nil, contextError(ctx))
+			return
+		case <-f.resend:
+		case <-resend.C:
+			c.logger.Printf("client: resending seq %d", req.GetSeq())
+		case wait := <-f.retry:
+			// The replica rejected the request as overloaded
+			select {
+			case <-f.done:
+				return
+			case <-ctx.Done():
+				f.complete(nil, contextError(ctx))
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+func (c *Client) forget(f *Future) {
+	c.mu.Lock()
+	if c.reqs[f.req.GetSeq()] == f {
+		delete(c.reqs, f.req.GetSeq())
+	}
+	c.mu.Unlock()
+}
+
+// keepalive tells the leader that we are alive whenever we have been idle
+// for keepaliveInterval, so that our session is not expired.
+func (c *Client) keepalive() {
+	ticker := time.NewTicker(c.keepaliveInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+		c.mu.Lock()
+		// We have no session before our first request
+		idle := c.seq > 0 && time.Since(c.sent) >= c.keepaliveInterval
+		id := c.id
+		c.mu.Unlock()
+		if !idle {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.keepaliveInterval)
+		err := c.send(ctx, &Request{Type: Request_KEEPALIVE.Enum(), Id: &id})
+		cancel()
+		if err != nil {
+			c.logger.Printf("client: sending keepalive failed: %v", err)
+		}
+	}
+}
+
+// contextError returns ErrTimeout if the deadline of ctx was exceeded, and
+// the error of ctx otherwise.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+// send writes req to the current connection, connecting first if needed. If
+// the write fails, the connection is dropped and we retry on a new one.
+func (c *Client) send(ctx context.Context, req *Request) error {
+	for {
+		conn, gen, err := c.connection(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.write(ctx, conn, req)
+		if err == nil {
+			c.mu.Lock()
+			c.sent = time.Now()
+			c.mu.Unlock()
+			return nil
+		}
+		c.logger.Printf("client: write to %v failed: %v", conn.RemoteAddr(), err)
+		c.dropConn(gen, "")
+		if ctx.Err() != nil {
+			return contextError(ctx)
+		}
+	}
+}
+
+func writeDeadline(ctx context.Context, conf *config.Config) time.Time {
+	deadline := time.Now().Add(conf.GetDuration("writeTimeout", config.DefWriteTimeout))
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// connection returns the current connection and its generation, connecting
+// to the service if there is none.
+func (c *Client) connection(ctx context.Context) (net.Conn, uint64, error) {
+	c.mu.Lock()
+	conn, gen, closed := c.conn, c.gen, c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, 0, ErrClientClosed
+	}
+	if conn != nil {
+		return conn, gen, nil
+	}
+
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	c.mu.Lock()
+	conn, gen = c.conn, c.gen
+	c.mu.Unlock()
+	if conn != nil {
+		// Someone else connected while we waited
+		return conn, gen, nil
+	}
+
+	conn, addr, err := c.dial(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, 0, ErrClientClosed
+	}
+	c.conn = conn
+	c.addr = addr
+	c.gen++
+	gen = c.gen
+	c.mu.Unlock()
+
+	go c.receive(conn, gen)
+	return conn, gen, nil
+}
+
+// dial connects and handshakes with a node, following redirects to the
+// leader.
+func (c *Client) dial(ctx context.Context) (net.Conn, string, error) {
+	dialer := net.Dialer{Timeout: c.conf.GetDuration("dialTimeout", config.DefDialTimeout)}
+	cycles := c.conf.GetInt("cycleListMax", config.DefCycleListMax)
+	redirects := 0
+	failures := 0
+	for {
+		c.mu.Lock()
+		addr := c.nodes[c.next]
+		nrOfNodes := len(c.nodes)
+		c.mu.Unlock()
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			var redirect string
+			redirect, err = c.handshake(ctx, conn)
+			if err == nil && redirect == "" {
+				c.logger.Printf("client: connected to %v", addr)
+				return conn, addr, nil
+			}
+			conn.Close()
+			if err == nil {
+				redirects++
+				if redirects > c.maxRedirects {
+					return nil, "", ErrRedirectsExhausted
+				}
+				c.logger.Printf("client: %v redirected us to %v", addr, redirect)
+				c.setNext(redirect)
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, "", contextError(ctx)
+		}
+
+		c.logger.Printf("client: connecting to %v failed: %v", addr, err)
+		c.mu.Lock()
+		c.next = (c.next + 1) % len(c.nodes)
+		c.mu.Unlock()
+		failures++
+		if failures >= nrOfNodes*cycles {
+			return nil, "", ErrClusterUnavailable
+		}
+		if failures%nrOfNodes == 0 {
+			// Tried every node; wait before the next cycle
+			select {
+			case <-time.After(c.conf.GetDuration("cycleNodesWait", config.DefCycleNodesWait)):
+			case <-ctx.Done():
+				return nil, "", contextError(ctx)
+			}
+		}
+	}
+}
+
+// handshake sends our id to the node on conn, and asks to be told about
+// membership changes. Returns the address of the leader if the node
+// redirects us.
+func (c *Client) handshake(ctx context.Context, conn net.Conn) (redirect string, err error) {
+	conn.SetDeadline(writeDeadline(ctx, c.conf))
+	defer conn.SetDeadline(time.Time{})
+	c.mu.Lock()
+	id := c.id
+	c.mu.Unlock()
+	hello := &Request{Type: Request_HELLO.Enum(), Id: &id, WatchMembers: proto.Bool(true)}
+	if err := write(conn, hello); err != nil {
+		return "", err
+	}
+	var resp Response
+	if err := read(conn, &resp); err != nil {
+		return "", err
+	}
+	if resp.GetType() != Response_HELLO_RESP {
+		return "", errors.New("unexpected handshake response type " + resp.GetType().String())
+	}
+	c.updateMembers(&resp)
+	switch resp.GetErrorCode() {
+	case Response_NONE:
+		return "", nil
+	case Response_REDIRECT:
+		return resp.GetErrorDetail(), nil
+	default:
+		return "", &ReplicaError{resp.GetErrorCode(), resp.GetErrorDetail()}
+	}
+}
+
+// updateMembers replaces the known nodes with the members in resp, unless
+// they are from an older epoch. Returns false if the node we are connected
+// to is no longer a member.
+func (c *Client) updateMembers(resp *Response) bool {
+	members := resp.GetMembers()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(members) == 0 || resp.GetEpoch() < c.epoch {
+		return true
+	}
+	if !MembIDEqual(MembID(members), MembID(c.nodes)) {
+		c.logger.Printf("client: membership changed to %v in epoch %d", members, resp.GetEpoch())
+		next := c.nodes[c.next]
+		c.nodes = append([]string(nil), members...)
+		c.next = 0
+		for i, node := range c.nodes {
+			if node == next {
+				c.next = i
+			}
+		}
+	}
+	c.epoch = resp.GetEpoch()
+	if c.conn == nil {
+		return true
+	}
+	for _, node := range c.nodes {
+		if node == c.addr {
+			return true
+		}
+	}
+	return false
+}
+
+// setNext makes addr the next node to connect to, adding it to the known
+// nodes if missing. Must not be called with mu held.
+func (c *Client) setNext(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, node := range c.nodes {
+		if node == addr {
+			c.next = i
+			return
+		}
+	}
+	c.nodes = append(c.nodes, addr)
+	c.next = len(c.nodes) - 1
+}
+
+// dropConn closes the connection of generation gen, if it is still the
+// current one, and asks every outstanding request to be resent. If next is
+// not empty, it is the next node to connect to.
+func (c *Client) dropConn(gen uint64, next string) {
+	c.mu.Lock()
+	if c.gen != gen || c.conn == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+	if next == "" {
+		c.next = (c.next + 1) % len(c.nodes)
+	}
+	reqs := make([]*Future, 0, len(c.reqs))
+	for _, f := range c.reqs {
+		reqs = append(reqs, f)
+	}
+	c.mu.Unlock()
+
+	if next != "" {
+		c.setNext(next)
+	}
+	for _, f := range reqs {
+		select {
+		case f.resend <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// receive reads responses from conn, and completes the matching requests.
+func (c *Client) receive(conn net.Conn, gen uint64) {
+	for {
+		var resp Response
+		if err := read(conn, &resp); err != nil {
+			c.logger.Printf("client: read from %v failed: %v", conn.RemoteAddr(), err)
+			c.dropConn(gen, "")
+			return
+		}
+
+		if resp.GetType() == Response_MEMBERSHIP {
+			if !c.updateMembers(&resp) {
+				c.logger.Printf("client: %v is no longer a member", conn.RemoteAddr())
+				c.dropConn(gen, "")
+				return
+			}
+			continue
+		}
+
+		switch resp.GetErrorCode() {
+		case Response_REDIRECT:
+			// Redirects are not tied to a request
+			c.updateMembers(&resp)
+			c.logger.Printf("client: redirected to %v", resp.GetErrorDetail())
+			c.dropConn(gen, resp.GetErrorDetail())
+			return
+		case Response_NONE:
+			c.complete(resp.GetSeq(), resp.GetVal(), nil)
+		case Response_OLD_CMD:
+			c.complete(resp.GetSeq(), nil, ErrOldCommand)
+		case Response_SESSION_EXPIRED:
+			if c.renew(resp.GetId(), gen) {
+				return
+			}
+		case Response_TOO_LARGE:
+			c.complete(resp.GetSeq(), nil, ErrRequestTooLarge)
+		case Response_OVERLOADED:
+			c.retryAfter(resp.GetSeq(), time.Duration(resp.GetRetryAfterMs())*time.Millisecond)
+		default:
+			c.complete(resp.GetSeq(), nil,
+				&ReplicaError{resp.GetErrorCode(), resp.GetErrorDetail()})
+		}
+	}
+}
+
+func (c *Client) complete(seq uint32, val []byte, err error) {
+	c.mu.Lock()
+	f, found := c.reqs[seq]
+	delete(c.reqs, seq)
+	c.mu.Unlock()
+	if found {
+		f.complete(val, err)
+	}
+}
+
+// renew starts a new session after the session with id has expired, like
+// the embedded client of a replica does, and resends the outstanding
+// requests in it. Since replicas know connections by the id of their
+// client, the connection of generation gen is replaced. Returns false if
+// the session was renewed before.
+func (c *Client) renew(expired string, gen uint64) bool {
+	id, err := generateID()
+	c.mu.Lock()
+	if expired != c.id {
+		c.mu.Unlock()
+		return false
+	}
+	if err != nil {
+		reqs := c.reqs
+		c.reqs = make(map[uint32]*Future)
+		c.mu.Unlock()
+		for _, f := range reqs {
+			f.complete(nil, ErrSessionExpired)
+		}
+		return false
+	}
+	c.logger.Printf("client: session %s expired, renewing as %s", expired, id)
+	c.id = id
+	seqs := make([]int, 0, len(c.reqs))
+	for seq := range c.reqs {
+		seqs = append(seqs, int(seq))
+	}
+	sort.Ints(seqs)
+	reqs := c.reqs
+	c.reqs = make(map[uint32]*Future)
+	c.seq = 0
+	for _, old := range seqs {
+		f := reqs[uint32(old)]
+		seq, ack := c.seq, uint32(0)
+		c.seq++
+		f.req = &Request{Type: Request_EXEC.Enum(), Id: &id, Seq: &seq, Val: f.req.Val, Ack: &ack}
+		c.reqs[seq] = f
+	}
+	addr := c.addr
+	c.mu.Unlock()
+	c.dropConn(gen, addr)
+	return true
+}
+
+// retryAfter asks the request with seq to be resent after wait.
+func (c *Client) retryAfter(seq uint32, wait time.Duration) {
+	c.mu.Lock()
+	f, found := c.reqs[seq]
+	c.mu.Unlock()
+	if !found {
+		return
+	}
+	select {
+	case f.retry <- wait:
+	default:
+	}
+}
+
+// A Future is the pending result of a request sent with Client.Go.
+type Future struct {
+	req         *Request
+	once        sync.Once
+	done        chan struct{}
+	resend      chan struct{}
+	retry       chan time.Duration // Wait before resending
+	val         []byte
+	err         error
+	sendTime    time.Time
+	receiveTime time.Time
+}
+
+func (f *Future) complete(val []byte, err error) {
+	f.once.Do(func() {
+		f.val, f.err = val, err
+		f.receiveTime = time.Now()
+		close(f.done)
+	})
+}
+
+// Done returns a channel that is closed when the result is ready.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the result of the request.
+func (f *Future) Result() ([]byte, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// Latency waits for the result of the request, and returns the time from the
+// request was sent until the result was ready.
+func (f *Future) Latency() time.Duration {
+	<-f.done
+	return f.receiveTime.Sub(f.sendTime)
+}
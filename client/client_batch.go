@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/relab/goxos/config"
+)
+
+// errConnChanged is returned for a request queued for a connection that was
+// replaced before the request could be written.
+var errConnChanged = errors.New("connection changed before write")
+
+// A pendingWrite is a request waiting to be written in the next frame.
+type pendingWrite struct {
+	conn net.Conn
+	req  *Request
+	done chan error
+}
+
+// write writes req to conn. Unless batching is turned off, req is queued and
+// written together with other queued requests by a flushing goroutine.
+func (c *Client) write(ctx context.Context, conn net.Conn, req *Request) error {
+	if c.batchMaxSize <= 1 {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		conn.SetWriteDeadline(writeDeadline(ctx, c.conf))
+		return write(conn, req)
+	}
+
+	w := &pendingWrite{conn: conn, req: req, done: make(chan error, 1)}
+	c.batchMu.Lock()
+	c.pending = append(c.pending, w)
+	start := !c.flushing
+	c.flushing = true
+	c.batchMu.Unlock()
+	if start {
+		go c.flush()
+	}
+	return <-w.done
+}
+
+// flush writes queued requests in frames of at most batchMaxSize requests,
+// until the queue is empty.
+func (c *Client) flush() {
+	for {
+		if c.batchLinger > 0 {
+			c.batchMu.Lock()
+			full := len(c.pending) >= c.batchMaxSize
+			c.batchMu.Unlock()
+			if !full {
+				time.Sleep(c.batchLinger)
+			}
+		}
+
+		c.batchMu.Lock()
+		n := len(c.pending)
+		if n == 0 {
+			c.flushing = false
+			c.batchMu.Unlock()
+			return
+		}
+		if n > c.batchMaxSize {
+			n = c.batchMaxSize
+		}
+		batch := c.pending[:n:n]
+		c.pending = append([]*pendingWrite(nil), c.pending[n:]...)
+		c.batchMu.Unlock()
+
+		c.writeBatch(batch)
+	}
+}
+
+// writeBatch writes the requests of batch that are queued for the same
+// connection as the first one in a single frame.
+func (c *Client) writeBatch(batch []*pendingWrite) {
+	conn := batch[0].conn
+	var reqs []*Request
+	for _, w := range batch {
+		if w.conn == conn {
+			reqs = append(reqs, w.req)
+		}
+	}
+	frame := reqs[0]
+	if len(reqs) > 1 {
+		frame = &Request{Type: Request_BATCH.Enum(), Id: reqs[0].Id, Batch: reqs}
+	}
+
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(c.conf.GetDuration("writeTimeout", config.DefWriteTimeout)))
+	err := write(conn, frame)
+	c.writeMu.Unlock()
+
+	for _, w := range batch {
+		if w.conn == conn {
+			w.done <- err
+		} else {
+			w.done <- errConnChanged
+		}
+	}
+}
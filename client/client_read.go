@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/config"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/code.google.com/p/goprotobuf/proto"
+)
+
+// errReadRedirected is returned by readFollower when the replica will not
+// serve the read, and it must go to the leader.
+var errReadRedirected = errors.New("read redirected to the leader")
+
+// A readOverloaded error is returned by readFollower when the replica
+// rejected the read as overloaded. It holds the retry-after hint.
+type readOverloaded time.Duration
+
+func (e readOverloaded) Error() string {
+	return "replica overloaded, retry after " + time.Duration(e).String()
+}
+
+// A Staleness bounds how old the state a read may be served from is. A
+// replica serves the read if it lags at most Slots decided slots behind the
+// highest slot it knows to be decided, or if it was up to date at most Time
+// ago. The zero Staleness requires the replica to be up to date.
+type Staleness struct {
+	Slots uint64
+	Time  time.Duration
+}
+
+// A readConn is the connection used for reads, to the replica that answered
+// our handshake first. Reads use a separate client id, since they are not
+// part of our session.
+type readConn struct {
+	conn net.Conn
+	addr string
+	mu   sync.Mutex // Guards the fields below
+	seq  uint32
+	reqs map[uint32]chan *Response
+	err  error // Set when conn has failed
+}
+
+// Read sends a read-only request to the nearest replica, which answers it
+// from its applied state if the state is within bound. If the replica is too
+// far behind, or does not serve reads, the request is sent to the leader as
+// with Do. A read rejected as overloaded is resent after the retry-after
+// hint. The application must be able to answer request without changing its
+// state.
+func (c *Client) Read(ctx context.Context, request []byte, bound Staleness) ([]byte, error) {
+	resp, err := c.readFollower(ctx, request, bound)
+	for wait, ok := err.(readOverloaded); ok; wait, ok = err.(readOverloaded) {
+		select {
+		case <-time.After(time.Duration(wait)):
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		}
+		resp, err = c.readFollower(ctx, request, bound)
+	}
+	switch err {
+	case nil:
+		return resp, nil
+	case errReadRedirected:
+	default:
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		if err == ErrClientClosed || err == ErrRequestTooLarge {
+			return nil, err
+		}
+		c.logger.Printf("client: follower read failed: %v", err)
+	}
+	return c.Do(ctx, request)
+}
+
+func (c *Client) readFollower(ctx context.Context, request []byte, bound Staleness) ([]byte, error) {
+	rc, err := c.readConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan *Response, 1)
+	rc.mu.Lock()
+	if rc.err != nil {
+		rc.mu.Unlock()
+		return nil, rc.err
+	}
+	seq := rc.seq
+	rc.seq++
+	rc.reqs[seq] = done
+	rc.mu.Unlock()
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.reqs, seq)
+		rc.mu.Unlock()
+	}()
+
+	req := &Request{
+		Type:          Request_READ.Enum(),
+		Id:            &c.readID,
+		Seq:           &seq,
+		Val:           request,
+		MaxStaleSlots: proto.Uint64(bound.Slots),
+	}
+	if bound.Time > 0 {
+		req.MaxStaleMs = proto.Uint64(uint64(bound.Time / time.Millisecond))
+	}
+	c.readMu.Lock()
+	rc.conn.SetWriteDeadline(writeDeadline(ctx, c.conf))
+	err = write(rc.conn, req)
+	c.readMu.Unlock()
+	if err != nil {
+		c.dropReadConn(rc, err)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-done:
+		if !ok {
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			return nil, rc.err
+		}
+		switch resp.GetErrorCode() {
+		case Response_NONE:
+			return resp.GetVal(), nil
+		case Response_REDIRECT:
+			return nil, errReadRedirected
+		case Response_OVERLOADED:
+			return nil, readOverloaded(time.Duration(resp.GetRetryAfterMs()) * time.Millisecond)
+		case Response_TOO_LARGE:
+			return nil, ErrRequestTooLarge
+		default:
+			return nil, &ReplicaError{resp.GetErrorCode(), resp.GetErrorDetail()}
+		}
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+}
+
+// readConnection returns the connection used for reads, connecting to the
+// nearest node if there is none.
+func (c *Client) readConnection(ctx context.Context) (*readConn, error) {
+	c.readDialMu.Lock()
+	defer c.readDialMu.Unlock()
+	c.mu.Lock()
+	rc, closed := c.readConn, c.closed
+	nodes := append([]string(nil), c.nodes...)
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrClientClosed
+	}
+	if rc != nil {
+		return rc, nil
+	}
+
+	conn, addr, err := c.dialNearest(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	rc = &readConn{conn: conn, addr: addr, reqs: make(map[uint32]chan *Response)}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClientClosed
+	}
+	c.readConn = rc
+	c.mu.Unlock()
+	c.logger.Printf("client: reading from %v", addr)
+
+	go c.receiveReads(rc)
+	return rc, nil
+}
+
+// dialNearest connects to every node at once, and keeps the connection of
+// the node that completes the handshake first.
+func (c *Client) dialNearest(ctx context.Context, nodes []string) (net.Conn, string, error) {
+	type result struct {
+		conn net.Conn
+		addr string
+	}
+	dialer := net.Dialer{Timeout: c.conf.GetDuration("dialTimeout", config.DefDialTimeout)}
+	results := make(chan result, len(nodes))
+	for _, addr := range nodes {
+		go func(addr string) {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				if err = c.readHandshake(ctx, conn); err != nil {
+					conn.Close()
+				}
+			}
+			if err != nil {
+				conn = nil
+			}
+			results <- result{conn, addr}
+		}(addr)
+	}
+
+	for i := range nodes {
+		r := <-results
+		if r.conn == nil {
+			continue
+		}
+		go func(remaining int) {
+			// Close the connections to the nodes further away
+			for ; remaining > 0; remaining-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}(len(nodes) - i - 1)
+		return r.conn, r.addr, nil
+	}
+	if ctx.Err() != nil {
+		return nil, "", contextError(ctx)
+	}
+	return nil, "", ErrClusterUnavailable
+}
+
+func (c *Client) readHandshake(ctx context.Context, conn net.Conn) error {
+	conn.SetDeadline(writeDeadline(ctx, c.conf))
+	defer conn.SetDeadline(time.Time{})
+	hello := &Request{Type: Request_HELLO.Enum(), Id: &c.readID, FollowerReads: proto.Bool(true)}
+	if err := write(conn, hello); err != nil {
+		return err
+	}
+	var resp Response
+	if err := read(conn, &resp); err != nil {
+		return err
+	}
+	if resp.GetType() != Response_HELLO_RESP {
+		return errors.New("unexpected handshake response type " + resp.GetType().String())
+	}
+	if resp.GetErrorCode() != Response_NONE {
+		return &ReplicaError{resp.GetErrorCode(), resp.GetErrorDetail()}
+	}
+	return nil
+}
+
+// receiveReads reads responses from the read connection, and hands them to
+// the waiting reads.
+func (c *Client) receiveReads(rc *readConn) {
+	for {
+		var resp Response
+		if err := read(rc.conn, &resp); err != nil {
+			c.dropReadConn(rc, err)
+			return
+		}
+		if resp.GetType() != Response_EXEC_RESP {
+			continue
+		}
+		rc.mu.Lock()
+		done, found := rc.reqs[resp.GetSeq()]
+		delete(rc.reqs, resp.GetSeq())
+		rc.mu.Unlock()
+		if found {
+			done <- &resp
+		}
+	}
+}
+
+// dropReadConn closes rc, failing the reads waiting on it with err. The next
+// read connects anew.
+func (c *Client) dropReadConn(rc *readConn, err error) {
+	c.mu.Lock()
+	if c.readConn == rc {
+		c.readConn = nil
+	}
+	c.mu.Unlock()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.err != nil {
+		return
+	}
+	c.logger.Printf("client: read connection to %v failed: %v", rc.addr, err)
+	rc.err = err
+	rc.conn.Close()
+	for seq, done := range rc.reqs {
+		close(done)
+		delete(rc.reqs, seq)
+	}
+}
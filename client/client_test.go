@@ -0,0 +1,442 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/config"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/code.google.com/p/goprotobuf/proto"
+)
+
+// fakeReplica accepts client connections on l. If redirect is set, clients
+// are redirected there during the handshake. Otherwise requests are answered
+// with their own value, unless silent is set. If push is set, it is sent
+// after the first response. READ requests are redirected to the leader if
+// redirectReads is set. The first overloaded requests are rejected with
+// OVERLOADED, and the first lost requests are not answered. If sessions is
+// set, requests from clients whose first request it has not seen are
+// rejected with SESSION_EXPIRED.
+type fakeReplica struct {
+	l             net.Listener
+	redirect      string
+	silent        bool
+	redirectReads bool
+	overloaded    int
+	lost          int
+	sessions      map[string]bool
+	push          *Response
+	mu            sync.Mutex
+	frames        int // Number of frames received
+	served        int // Number of requests answered
+	reads         int // Number of reads answered
+}
+
+func newFakeReplica(t *testing.T) *fakeReplica {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeReplica{l: l}
+	go r.serve()
+	return r
+}
+
+func (r *fakeReplica) addr() string {
+	return r.l.Addr().String()
+}
+
+func (r *fakeReplica) serve() {
+	for {
+		conn, err := r.l.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *fakeReplica) handle(conn net.Conn) {
+	defer conn.Close()
+	var hello Request
+	if err := read(conn, &hello); err != nil {
+		return
+	}
+	if r.redirect != "" && !hello.GetFollowerReads() {
+		write(conn, genErrResp(Response_HELLO_RESP, Response_REDIRECT, r.redirect))
+		return
+	}
+	write(conn, genResp(Response_HELLO_RESP, nil))
+
+	for {
+		var frame Request
+		if err := read(conn, &frame); err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.frames++
+		r.mu.Unlock()
+		if r.silent {
+			continue
+		}
+		if frame.GetType() == Request_READ {
+			resp := genResp(Response_EXEC_RESP, frame.GetVal())
+			r.mu.Lock()
+			if r.redirectReads {
+				resp = genErrResp(Response_EXEC_RESP, Response_REDIRECT, r.redirect)
+			} else if r.overloaded > 0 {
+				r.overloaded--
+				resp = genErrResp(Response_EXEC_RESP, Response_OVERLOADED, "")
+				resp.RetryAfterMs = proto.Uint32(50)
+			}
+			r.mu.Unlock()
+			resp.Id, resp.Seq = frame.Id, frame.Seq
+			write(conn, resp)
+			r.mu.Lock()
+			r.reads++
+			r.mu.Unlock()
+			continue
+		}
+		reqs := []*Request{&frame}
+		if frame.GetType() == Request_BATCH {
+			reqs = frame.GetBatch()
+		}
+		for _, req := range reqs {
+			resp := genResp(Response_EXEC_RESP, req.GetVal())
+			r.mu.Lock()
+			if r.lost > 0 {
+				r.lost--
+				r.mu.Unlock()
+				continue
+			}
+			if r.overloaded > 0 {
+				r.overloaded--
+				resp = genErrResp(Response_EXEC_RESP, Response_OVERLOADED, "")
+				resp.RetryAfterMs = proto.Uint32(50)
+			}
+			if r.sessions != nil {
+				if req.GetSeq() == 0 {
+					r.sessions[req.GetId()] = true
+				} else if !r.sessions[req.GetId()] {
+					resp = genErrResp(Response_EXEC_RESP, Response_SESSION_EXPIRED, "")
+				}
+			}
+			r.mu.Unlock()
+			resp.Id, resp.Seq = req.Id, req.Seq
+			write(conn, resp)
+			if r.push != nil {
+				write(conn, r.push)
+			}
+			r.mu.Lock()
+			r.served++
+			r.mu.Unlock()
+		}
+	}
+}
+
+func clientConfig(addrs ...string) *config.Config {
+	var nodes []string
+	for i, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		nodes = append(nodes, fmt.Sprintf("%d:%s:0:%s", i, host, port))
+	}
+	cfg := config.NewConfig()
+	cfg.Set("nodes", strings.Join(nodes, ","))
+	cfg.Set("cycleListMax", "1")
+	cfg.Set("cycleNodesWait", "10ms")
+	return cfg
+}
+
+func TestClientConcurrentDo(t *testing.T) {
+	r := newFakeReplica(t)
+	defer r.l.Close()
+	c, err := NewClient(clientConfig(r.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := []byte(fmt.Sprintf("request %d", i))
+			resp, err := c.Do(context.Background(), req)
+			if err != nil {
+				t.Errorf("request %d: %v", i, err)
+			} else if !bytes.Equal(resp, req) {
+				t.Errorf("request %d: got response %q, want %q", i, resp, req)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClientBatching(t *testing.T) {
+	r := newFakeReplica(t)
+	defer r.l.Close()
+	cfg := clientConfig(r.addr())
+	cfg.Set("clientBatchLinger", "20ms")
+	cfg.Set("clientWindow", "8")
+	c, err := NewClient(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const n = 64
+	futures := make([]*Future, n)
+	for i := range futures {
+		futures[i] = c.Go(context.Background(), []byte(fmt.Sprint(i)))
+	}
+	for i, f := range futures {
+		resp, err := f.Result()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if string(resp) != fmt.Sprint(i) {
+			t.Errorf("request %d: got response %q", i, resp)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.served != n {
+		t.Errorf("replica answered %d requests, want %d", r.served, n)
+	}
+	// The window allows at most 8 requests per frame
+	if r.frames < n/8 || r.frames == n {
+		t.Errorf("requests were sent in %d frames, want between %d and %d", r.frames, n/8, n-1)
+	}
+}
+
+func TestClientOverloaded(t *testing.T) {
+	r := newFakeReplica(t)
+	defer r.l.Close()
+	r.overloaded = 1
+	c, err := NewClient(clientConfig(r.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.Do(context.Background(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("request was resent after %v, before the retry-after hint of 50ms", d)
+	}
+}
+
+func TestClientFirstRequestLost(t *testing.T) {
+	r := newFakeReplica(t)
+	defer r.l.Close()
+	r.lost = 1
+	r.sessions = make(map[string]bool)
+	c, err := NewClient(clientConfig(r.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	id := c.id
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, []byte("x")); err != ErrTimeout {
+		t.Fatalf("got error %v for lost request, want %v", err, ErrTimeout)
+	}
+	// The replica has not seen the first request, so the second starts a
+	// new session
+	val, err := c.Do(context.Background(), []byte("y"))
+	if err != nil || string(val) != "y" {
+		t.Fatalf("second request returned %q, %v", val, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.id == id {
+		t.Error("session was not renewed")
+	}
+}
+
+func TestClientReadOverloaded(t *testing.T) {
+	leader := newFakeReplica(t)
+	defer leader.l.Close()
+	follower := newFakeReplica(t)
+	defer follower.l.Close()
+	follower.redirect = leader.addr()
+	follower.overloaded = 1
+
+	c, err := NewClient(clientConfig(follower.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	if _, err := c.Read(context.Background(), []byte("x"), Staleness{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("read was resent after %v, before the retry-after hint of 50ms", d)
+	}
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	if follower.reads != 2 || leader.served != 0 {
+		t.Errorf("follower answered %d reads and leader %d requests, want 2 and 0", follower.reads, leader.served)
+	}
+}
+
+func TestClientRedirect(t *testing.T) {
+	leader := newFakeReplica(t)
+	defer leader.l.Close()
+	follower := newFakeReplica(t)
+	defer follower.l.Close()
+	follower.redirect = leader.addr()
+
+	c, err := NewClient(clientConfig(follower.addr(), leader.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do(context.Background(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replica redirecting to itself exhausts the redirects
+	follower.redirect = follower.addr()
+	c, err = NewClient(clientConfig(follower.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do(context.Background(), []byte("x")); err != ErrRedirectsExhausted {
+		t.Errorf("got error %v, want %v", err, ErrRedirectsExhausted)
+	}
+}
+
+func TestClientTimeoutAndCancel(t *testing.T) {
+	r := newFakeReplica(t)
+	defer r.l.Close()
+	r.silent = true
+	c, err := NewClient(clientConfig(r.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, []byte("x")); err != ErrTimeout {
+		t.Errorf("got error %v, want %v", err, ErrTimeout)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	f := c.Go(ctx, []byte("x"))
+	cancel()
+	if _, err := f.Result(); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClientUnavailable(t *testing.T) {
+	r := newFakeReplica(t)
+	addr := r.addr()
+	r.l.Close()
+	c, err := NewClient(clientConfig(addr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do(context.Background(), []byte("x")); err != ErrClusterUnavailable {
+		t.Errorf("got error %v, want %v", err, ErrClusterUnavailable)
+	}
+}
+
+func TestClientMembershipPush(t *testing.T) {
+	old := newFakeReplica(t)
+	defer old.l.Close()
+	standby := newFakeReplica(t)
+	defer standby.l.Close()
+
+	// The old replica is replaced by the standby in epoch 1
+	epoch := uint64(1)
+	old.push = &Response{
+		Type:    Response_MEMBERSHIP.Enum(),
+		Epoch:   &epoch,
+		Members: []string{standby.addr()},
+	}
+
+	c, err := NewClient(clientConfig(old.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Do(context.Background(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		c.mu.Lock()
+		moved := c.epoch == epoch && c.conn == nil
+		c.mu.Unlock()
+		if moved {
+			break
+		}
+		if i == 100 {
+			t.Fatal("client did not leave the replaced replica")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.Do(context.Background(), []byte("y")); err != nil {
+		t.Fatal(err)
+	}
+	standby.mu.Lock()
+	defer standby.mu.Unlock()
+	if standby.served != 1 {
+		t.Errorf("standby answered %d requests, want 1", standby.served)
+	}
+}
+
+func TestClientRead(t *testing.T) {
+	leader := newFakeReplica(t)
+	defer leader.l.Close()
+	follower := newFakeReplica(t)
+	defer follower.l.Close()
+	follower.redirect = leader.addr()
+
+	c, err := NewClient(clientConfig(follower.addr()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp, err := c.Read(context.Background(), []byte("x"), Staleness{Slots: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "x" {
+		t.Errorf("got response %q, want %q", resp, "x")
+	}
+	follower.mu.Lock()
+	if follower.reads != 1 {
+		t.Errorf("follower answered %d reads, want 1", follower.reads)
+	}
+	follower.mu.Unlock()
+
+	// A follower that is too far behind sends the read to the leader
+	follower.redirectReads = true
+	if _, err := c.Read(context.Background(), []byte("y"), Staleness{}); err != nil {
+		t.Fatal(err)
+	}
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	if leader.served != 1 {
+		t.Errorf("leader answered %d requests, want 1", leader.served)
+	}
+}
@@ -8,6 +8,41 @@ listens on a socket for connections from clients, does a handshake with the clie
 then creates a ClientConn -- which is responsible for further handling of each specific
 client.
 
+Applications send requests using a Client, which offers a synchronous (Do) and an
+asynchronous (Go) method, both honoring the cancellation and deadline of a context.
+ServiceConn is the older interface used by the kvs client.
+
+Every request carries the client id and a sequence number. The replicas keep
+the response to each executed request until the client acknowledges it
+(Request.Ack), so a request resent after a reconnect or a leader change is
+executed at most once. The leader ends the sessions of clients it has not
+heard from during the replicas' sessionTimeout; idle Clients therefore send
+keepalives. Requests from a client whose session has ended, or that a
+replica cannot order since it has not seen the earlier requests of the
+client, are rejected with SESSION_EXPIRED. A Client then starts a new session
+under a new id, and resends its outstanding requests in it.
+
+Replicas include the current membership epoch and the client addresses of the
+members in handshake and redirect responses, and push MEMBERSHIP responses to
+Clients when a replica is replaced or the group is reconfigured. A Client then
+replaces its node list, and reconnects if its replica is no longer a member.
+
+Client.Read sends a READ request to the replica that answers the handshake
+first, bypassing the log. With followerReads enabled, the replica answers it
+from its applied state if it lags the latest decided slot it knows of by at
+most the Staleness of the read. The latest decided slot is only known from
+recent reports of a quorum or of the leader. Otherwise the read waits up to
+readWaitTimeout and is then redirected; the Client resends it to the leader as
+with Do. Without followerReads, every read is sent as with Do. The
+application must implement app.Reader.
+
+Replicas reject requests they cannot take on at once, rather than letting
+them time out. A request is rejected with OVERLOADED when maxInFlight requests
+are waiting for agreement, when its client exceeds clientRateLimit, or when
+the replica cannot keep up; the response carries a retry-after hint that the
+Client waits for before resending. Requests larger than maxRequestSize are
+rejected with TOO_LARGE, and fail with ErrRequestTooLarge.
+
 To recompile the Protobuf msg.proto file, use the command: protoc msg.proto --go_out=.
 */
 package client
@@ -16,17 +16,29 @@ var _ = math.Inf
 type Request_Type int32
 
 const (
-	Request_HELLO Request_Type = 1
-	Request_EXEC  Request_Type = 2
+	Request_HELLO     Request_Type = 1
+	Request_EXEC      Request_Type = 2
+	Request_KEEPALIVE Request_Type = 3
+	Request_EXPIRE    Request_Type = 4
+	Request_BATCH     Request_Type = 5
+	Request_READ      Request_Type = 6
 )
 
 var Request_Type_name = map[int32]string{
 	1: "HELLO",
 	2: "EXEC",
+	3: "KEEPALIVE",
+	4: "EXPIRE",
+	5: "BATCH",
+	6: "READ",
 }
 var Request_Type_value = map[string]int32{
-	"HELLO": 1,
-	"EXEC":  2,
+	"HELLO":     1,
+	"EXEC":      2,
+	"KEEPALIVE": 3,
+	"EXPIRE":    4,
+	"BATCH":     5,
+	"READ":      6,
 }
 
 func (x Request_Type) Enum() *Request_Type {
@@ -54,15 +66,18 @@ type Response_Type int32
 const (
 	Response_HELLO_RESP Response_Type = 1
 	Response_EXEC_RESP  Response_Type = 2
+	Response_MEMBERSHIP Response_Type = 3
 )
 
 var Response_Type_name = map[int32]string{
 	1: "HELLO_RESP",
 	2: "EXEC_RESP",
+	3: "MEMBERSHIP",
 }
 var Response_Type_value = map[string]int32{
 	"HELLO_RESP": 1,
 	"EXEC_RESP":  2,
+	"MEMBERSHIP": 3,
 }
 
 func (x Response_Type) Enum() *Response_Type {
@@ -130,14 +145,17 @@ func (x *Response_Protocol) UnmarshalJSON(data []byte) error {
 type Response_Error int32
 
 const (
-	Response_NONE        Response_Error = 0
-	Response_REDIRECT    Response_Error = 1
-	Response_INVALID_ID  Response_Error = 2
-	Response_MISSING_ID  Response_Error = 3
-	Response_MISSING_TAG Response_Error = 4
-	Response_MISSING_VAL Response_Error = 5
-	Response_OLD_CMD     Response_Error = 6
-	Response_OTHER       Response_Error = 15
+	Response_NONE            Response_Error = 0
+	Response_REDIRECT        Response_Error = 1
+	Response_INVALID_ID      Response_Error = 2
+	Response_MISSING_ID      Response_Error = 3
+	Response_MISSING_TAG     Response_Error = 4
+	Response_MISSING_VAL     Response_Error = 5
+	Response_OLD_CMD         Response_Error = 6
+	Response_SESSION_EXPIRED Response_Error = 7
+	Response_OVERLOADED      Response_Error = 8
+	Response_TOO_LARGE       Response_Error = 9
+	Response_OTHER           Response_Error = 15
 )
 
 var Response_Error_name = map[int32]string{
@@ -148,17 +166,23 @@ var Response_Error_name = map[int32]string{
 	4:  "MISSING_TAG",
 	5:  "MISSING_VAL",
 	6:  "OLD_CMD",
+	7:  "SESSION_EXPIRED",
+	8:  "OVERLOADED",
+	9:  "TOO_LARGE",
 	15: "OTHER",
 }
 var Response_Error_value = map[string]int32{
-	"NONE":        0,
-	"REDIRECT":    1,
-	"INVALID_ID":  2,
-	"MISSING_ID":  3,
-	"MISSING_TAG": 4,
-	"MISSING_VAL": 5,
-	"OLD_CMD":     6,
-	"OTHER":       15,
+	"NONE":            0,
+	"REDIRECT":        1,
+	"INVALID_ID":      2,
+	"MISSING_ID":      3,
+	"MISSING_TAG":     4,
+	"MISSING_VAL":     5,
+	"OLD_CMD":         6,
+	"SESSION_EXPIRED": 7,
+	"OVERLOADED":      8,
+	"TOO_LARGE":       9,
+	"OTHER":           15,
 }
 
 func (x Response_Error) Enum() *Response_Error {
@@ -186,6 +210,12 @@ type Request struct {
 	Id               *string       `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
 	Seq              *uint32       `protobuf:"varint,3,opt,name=seq" json:"seq,omitempty"`
 	Val              []byte        `protobuf:"bytes,4,opt,name=val" json:"val,omitempty"`
+	Ack              *uint32       `protobuf:"varint,5,opt,name=ack" json:"ack,omitempty"`
+	WatchMembers     *bool         `protobuf:"varint,6,opt,name=watch_members" json:"watch_members,omitempty"`
+	Batch            []*Request    `protobuf:"bytes,7,rep,name=batch" json:"batch,omitempty"`
+	MaxStaleSlots    *uint64       `protobuf:"varint,8,opt,name=max_stale_slots" json:"max_stale_slots,omitempty"`
+	MaxStaleMs       *uint64       `protobuf:"varint,9,opt,name=max_stale_ms" json:"max_stale_ms,omitempty"`
+	FollowerReads    *bool         `protobuf:"varint,10,opt,name=follower_reads" json:"follower_reads,omitempty"`
 	XXX_unrecognized []byte        `json:"-"`
 }
 
@@ -221,12 +251,57 @@ func (this *Request) GetVal() []byte {
 	return nil
 }
 
+func (this *Request) GetAck() uint32 {
+	if this != nil && this.Ack != nil {
+		return *this.Ack
+	}
+	return 0
+}
+
+func (this *Request) GetWatchMembers() bool {
+	if this != nil && this.WatchMembers != nil {
+		return *this.WatchMembers
+	}
+	return false
+}
+
+func (this *Request) GetBatch() []*Request {
+	if this != nil {
+		return this.Batch
+	}
+	return nil
+}
+
+func (this *Request) GetMaxStaleSlots() uint64 {
+	if this != nil && this.MaxStaleSlots != nil {
+		return *this.MaxStaleSlots
+	}
+	return 0
+}
+
+func (this *Request) GetMaxStaleMs() uint64 {
+	if this != nil && this.MaxStaleMs != nil {
+		return *this.MaxStaleMs
+	}
+	return 0
+}
+
+func (this *Request) GetFollowerReads() bool {
+	if this != nil && this.FollowerReads != nil {
+		return *this.FollowerReads
+	}
+	return false
+}
+
 type Response struct {
 	Type             *Response_Type     `protobuf:"varint,1,req,name=type,enum=client.Response_Type" json:"type,omitempty"`
 	Id               *string            `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
 	Seq              *uint32            `protobuf:"varint,3,opt,name=seq" json:"seq,omitempty"`
 	Val              []byte             `protobuf:"bytes,4,opt,name=val" json:"val,omitempty"`
 	Protocol         *Response_Protocol `protobuf:"varint,5,opt,name=protocol,enum=client.Response_Protocol" json:"protocol,omitempty"`
+	Epoch            *uint64            `protobuf:"varint,6,opt,name=epoch" json:"epoch,omitempty"`
+	Members          []string           `protobuf:"bytes,7,rep,name=members" json:"members,omitempty"`
+	RetryAfterMs     *uint32            `protobuf:"varint,8,opt,name=retry_after_ms" json:"retry_after_ms,omitempty"`
 	ErrorCode        *Response_Error    `protobuf:"varint,14,opt,name=error_code,enum=client.Response_Error,def=0" json:"error_code,omitempty"`
 	ErrorDetail      *string            `protobuf:"bytes,15,opt,name=error_detail" json:"error_detail,omitempty"`
 	XXX_unrecognized []byte             `json:"-"`
@@ -273,6 +348,27 @@ func (this *Response) GetProtocol() Response_Protocol {
 	return 0
 }
 
+func (this *Response) GetEpoch() uint64 {
+	if this != nil && this.Epoch != nil {
+		return *this.Epoch
+	}
+	return 0
+}
+
+func (this *Response) GetMembers() []string {
+	if this != nil {
+		return this.Members
+	}
+	return nil
+}
+
+func (this *Response) GetRetryAfterMs() uint32 {
+	if this != nil && this.RetryAfterMs != nil {
+		return *this.RetryAfterMs
+	}
+	return 0
+}
+
 func (this *Response) GetErrorCode() Response_Error {
 	if this != nil && this.ErrorCode != nil {
 		return *this.ErrorCode
@@ -2,13 +2,23 @@ package client
 
 import (
 	"bytes"
+	"encoding/gob"
 	"fmt"
 	"hash/fnv"
 )
 
+func init() {
+	// Requests are recorded as untyped trace entries.
+	gob.Register(&Request{})
+}
+
 // the length of our ID field (base64 encoded SHA-1 hash)
 const validIDLength = 28
 
+// SessionsID is the id of the EXPIRE requests proposed by the leader to end
+// the sessions of idle clients. It is never a valid client id.
+const SessionsID = "sessions"
+
 var h = fnv.New32a()
 
 // Display the Request in truncated string form.
@@ -43,6 +53,29 @@ func (r *Request) IsIDInvalid() bool {
 	return r.GetId() == "" || len(r.GetId()) != validIDLength
 }
 
+// NewExpireRequest returns the seq'th request that ends the sessions of the
+// given clients.
+func NewExpireRequest(seq uint32, ids []string) *Request {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(ids); err != nil {
+		panic(err)
+	}
+	id := SessionsID
+	return &Request{
+		Type: Request_EXPIRE.Enum(),
+		Id:   &id,
+		Seq:  &seq,
+		Ack:  &seq,
+		Val:  b.Bytes(),
+	}
+}
+
+// ExpiredIDs returns the client ids of an EXPIRE request.
+func (r *Request) ExpiredIDs() (ids []string, err error) {
+	err = gob.NewDecoder(bytes.NewReader(r.GetVal())).Decode(&ids)
+	return
+}
+
 // Display the Response in truncated string form.
 func (r *Response) SimpleString() string {
 	if !r.HasError() {
@@ -87,6 +87,11 @@ func handshakeReq(conn net.Conn, id *string) (*Response, error) {
 }
 
 func (c *ReplicaConn) handshakeResp(hsResp *Response) (ServiceConn, error) {
+	if members := hsResp.GetMembers(); len(members) > 0 {
+		// Use the current members the next time we need to connect
+		c.nodes = members
+		c.nextNodeToConnectTo %= len(c.nodes)
+	}
 	switch hsResp.GetErrorCode() {
 	case Response_NONE:
 		log.Println("handshake: id accepted")
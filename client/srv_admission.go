@@ -0,0 +1,141 @@
+package client
+
+import (
+	"time"
+
+	"github.com/relab/goxos/config"
+)
+
+// Requests that have not been answered within inFlightExpiry are no longer
+// counted as in flight. They may have been lost in a leader change.
+const inFlightExpiry = 30 * time.Second
+
+// An admission decides whether the ClientHandler accepts a request for
+// agreement. It bounds the number of requests in flight at the replica, the
+// size of each request, and the rate of requests from each client. It is
+// only used by the goroutine handling requests and responses.
+type admission struct {
+	maxInFlight    int
+	maxRequestSize int
+	rate           float64 // Requests per second per client, 0 is no limit
+	burst          float64
+	retryAfter     time.Duration
+
+	inFlight map[string]map[uint32]time.Time // Time admitted, by id and seq
+	count    int                             // Number of requests in inFlight
+	buckets  map[string]*tokenBucket
+}
+
+// A tokenBucket holds up to burst tokens, and gains rate tokens per second.
+// Each request takes one token.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newAdmission(conf *config.Config) *admission {
+	return &admission{
+		maxInFlight:    conf.GetInt("maxInFlight", config.DefMaxInFlight),
+		maxRequestSize: conf.GetInt("maxRequestSize", config.DefMaxRequestSize),
+		rate:           conf.GetFloat("clientRateLimit", config.DefClientRateLimit),
+		burst:          float64(conf.GetInt("clientRateBurst", config.DefClientRateBurst)),
+		retryAfter:     conf.GetDuration("overloadRetryAfter", config.DefOverloadRetryAfter),
+		inFlight:       make(map[string]map[uint32]time.Time),
+		buckets:        make(map[string]*tokenBucket),
+	}
+}
+
+// admit returns NONE if req may be forwarded for agreement. Otherwise it
+// returns the error to reject req with, and for OVERLOADED how long the
+// client should wait before retrying. Admitted requests count as in flight
+// until done is called for them.
+func (a *admission) admit(req *Request, now time.Time) (Response_Error, time.Duration) {
+	if a.maxRequestSize > 0 && len(req.GetVal()) > a.maxRequestSize {
+		return Response_TOO_LARGE, 0
+	}
+	seqs := a.inFlight[req.GetId()]
+	if _, resent := seqs[req.GetSeq()]; resent {
+		// Already admitted; the client resent it
+		return Response_NONE, 0
+	}
+	if a.maxInFlight > 0 && a.count >= a.maxInFlight {
+		return Response_OVERLOADED, a.retryAfter
+	}
+	if wait := a.take(req.GetId(), now); wait > 0 {
+		return Response_OVERLOADED, wait
+	}
+	if seqs == nil {
+		seqs = make(map[uint32]time.Time)
+		a.inFlight[req.GetId()] = seqs
+	}
+	seqs[req.GetSeq()] = now
+	a.count++
+	return Response_NONE, 0
+}
+
+// take takes a token from the bucket of id. If the bucket is empty, it
+// returns how long until the next token.
+func (a *admission) take(id string, now time.Time) time.Duration {
+	if a.rate <= 0 {
+		return 0
+	}
+	b, found := a.buckets[id]
+	if !found {
+		b = &tokenBucket{tokens: a.burst, last: now}
+		a.buckets[id] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * a.rate
+	if b.tokens > a.burst {
+		b.tokens = a.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / a.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// rejected undoes the admission of a request that could not be forwarded.
+func (a *admission) rejected(req *Request) {
+	a.done(req.GetId(), req.GetSeq())
+}
+
+// done is called when the response to a request is sent.
+func (a *admission) done(id string, seq uint32) {
+	seqs, found := a.inFlight[id]
+	if !found {
+		return
+	}
+	if _, found := seqs[seq]; found {
+		delete(seqs, seq)
+		a.count--
+	}
+	if len(seqs) == 0 {
+		delete(a.inFlight, id)
+	}
+}
+
+// forget removes the state of a client that is gone.
+func (a *admission) forget(id string) {
+	a.count -= len(a.inFlight[id])
+	delete(a.inFlight, id)
+	delete(a.buckets, id)
+}
+
+// expire stops counting requests that have been in flight for too long, and
+// removes full token buckets, which are the same as new ones.
+func (a *admission) expire(now time.Time) {
+	for id, seqs := range a.inFlight {
+		for seq, admitted := range seqs {
+			if now.Sub(admitted) > inFlightExpiry {
+				a.done(id, seq)
+			}
+		}
+	}
+	for id, b := range a.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*a.rate >= a.burst {
+			delete(a.buckets, id)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/config"
+)
+
+func admissionReq(id string, seq uint32, size int) *Request {
+	return &Request{Type: Request_EXEC.Enum(), Id: &id, Seq: &seq, Val: make([]byte, size)}
+}
+
+func TestAdmissionLimits(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Set("maxInFlight", "2")
+	conf.Set("maxRequestSize", "10")
+	a := newAdmission(conf)
+	now := time.Now()
+
+	if code, _ := a.admit(admissionReq("a", 0, 11), now); code != Response_TOO_LARGE {
+		t.Errorf("got %v for large request, want %v", code, Response_TOO_LARGE)
+	}
+	for seq := uint32(0); seq < 2; seq++ {
+		if code, _ := a.admit(admissionReq("a", seq, 10), now); code != Response_NONE {
+			t.Fatalf("request %d rejected with %v", seq, code)
+		}
+	}
+	code, retryAfter := a.admit(admissionReq("b", 0, 0), now)
+	if code != Response_OVERLOADED || retryAfter != config.DefOverloadRetryAfter {
+		t.Errorf("got %v, retry after %v, want %v, retry after %v",
+			code, retryAfter, Response_OVERLOADED, config.DefOverloadRetryAfter)
+	}
+	// A resent request is not counted twice
+	if code, _ := a.admit(admissionReq("a", 1, 0), now); code != Response_NONE {
+		t.Errorf("resent request rejected with %v", code)
+	}
+
+	a.done("a", 0)
+	if code, _ := a.admit(admissionReq("b", 0, 0), now); code != Response_NONE {
+		t.Errorf("request rejected with %v after a response", code)
+	}
+	a.forget("b")
+	a.expire(now.Add(2 * inFlightExpiry))
+	if a.count != 0 || len(a.inFlight) != 0 {
+		t.Errorf("%d requests still in flight after expiry", a.count)
+	}
+}
+
+func TestAdmissionRateLimit(t *testing.T) {
+	conf := config.NewConfig()
+	conf.Set("clientRateLimit", "10")
+	conf.Set("clientRateBurst", "2")
+	a := newAdmission(conf)
+	now := time.Now()
+
+	for seq := uint32(0); seq < 2; seq++ {
+		if code, _ := a.admit(admissionReq("a", seq, 0), now); code != Response_NONE {
+			t.Fatalf("request %d within burst rejected with %v", seq, code)
+		}
+	}
+	code, retryAfter := a.admit(admissionReq("a", 2, 0), now)
+	if code != Response_OVERLOADED || retryAfter != 100*time.Millisecond {
+		t.Errorf("got %v, retry after %v, want %v, retry after 100ms", code, retryAfter, Response_OVERLOADED)
+	}
+	// Other clients have buckets of their own
+	if code, _ := a.admit(admissionReq("b", 0, 0), now); code != Response_NONE {
+		t.Errorf("request from other client rejected with %v", code)
+	}
+	if code, _ := a.admit(admissionReq("a", 2, 0), now.Add(100*time.Millisecond)); code != Response_NONE {
+		t.Errorf("request after refill rejected with %v", code)
+	}
+}
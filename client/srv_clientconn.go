@@ -11,13 +11,17 @@ import (
 
 // A ClientConn is the server-side represention a connection between a replica
 // and a client. Connected is set to true/false depending on the status of the
-// connection.
+// connection. WatchMembers is set if the client wants to be told about
+// membership changes, and followerReads if the connection is only used for
+// reads.
 type ClientConn struct {
-	conn      net.Conn
-	connected bool
-	addr      string
-	reqChan   chan<- *Request
-	respChan  chan *Response // Used by WriteAsync to queue responses for writing
+	conn          net.Conn
+	connected     bool
+	watchMembers  bool
+	followerReads bool
+	addr          string
+	reqChan       chan<- *Request
+	respChan      chan *Response // Used by WriteAsync to queue responses for writing
 }
 
 // Create a new ClientConn. A low-level socket structure must be passed in along with a
@@ -55,7 +59,17 @@ func (cc *ClientConn) Serve() {
 			err = errors.New("invalid id: " + req.GetId())
 			return
 		}
-		cc.reqChan <- &req
+		if req.GetType() != Request_BATCH {
+			cc.reqChan <- &req
+			continue
+		}
+		for _, r := range req.GetBatch() {
+			if r.GetId() != req.GetId() {
+				err = errors.New("batch contains request from other client: " + r.GetId())
+				return
+			}
+			cc.reqChan <- r
+		}
 	}
 }
 
@@ -4,4 +4,6 @@ type ClientHandler interface {
 	Start()
 	Stop()
 	ForwardResponse(resp *Response)
+	ExpireSessions(ids []string)
+	DropRequest(req *Request)
 }
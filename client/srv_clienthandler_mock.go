@@ -7,3 +7,7 @@ func (chm *ClientHandlerMock) Start() {}
 func (chm *ClientHandlerMock) Stop() {}
 
 func (chm *ClientHandlerMock) ForwardResponse(resp *Response) {}
+
+func (chm *ClientHandlerMock) ExpireSessions(ids []string) {}
+
+func (chm *ClientHandlerMock) DropRequest(req *Request) {}
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
 	"github.com/relab/goxos/liveness"
 	gnet "github.com/relab/goxos/net"
@@ -17,6 +21,9 @@ var allowDirect = map[string]bool{
 	"batchpaxos": true,
 }
 
+// How often the ClientHandler checks whether the membership has changed.
+const membershipCheckInterval = time.Second
+
 // The ClientHandler module maintains all of the connections that a replica has with
 // its clients.
 type ClientHandlerTCP struct {
@@ -30,14 +37,18 @@ type ClientHandlerTCP struct {
 	reqChan       chan *Request
 	propChan      chan<- *Request
 	respChan      chan *Response
+	dropChan      chan *Request
+	expireChan    chan []string
 	clients       map[string]*ClientConn
 	replies       map[string]*Response
+	adm           *admission
+	membID        []byte // Membership last pushed to clients
 	stop          chan bool
 	stopCheckIn   *sync.WaitGroup
 }
 
 // Create a new ClientHandler.
-func NewClientHandlerTCP(id grp.ID, paxosType string, gm grp.GroupManager,
+func NewClientHandlerTCP(id grp.ID, paxosType string, conf *config.Config, gm grp.GroupManager,
 	ld liveness.LeaderDetector, propChan chan<- *Request,
 	stopCheckIn *sync.WaitGroup) *ClientHandlerTCP {
 
@@ -61,8 +72,11 @@ func NewClientHandlerTCP(id grp.ID, paxosType string, gm grp.GroupManager,
 		reqChan:     make(chan *Request, 64),
 		propChan:    propChan,
 		respChan:    make(chan *Response, 512),
+		dropChan:    make(chan *Request, 512),
+		expireChan:  make(chan []string, 16),
 		clients:     make(map[string]*ClientConn),
 		replies:     make(map[string]*Response),
+		adm:         newAdmission(conf),
 		stop:        make(chan bool),
 		stopCheckIn: stopCheckIn,
 	}
@@ -100,14 +114,26 @@ func (ch *ClientHandlerTCP) Start() {
 		glog.V(1).Info("start handling requests and responses")
 		defer ch.stopCheckIn.Done()
 
+		ch.membID = MembID(ch.membership().Members)
+		membershipCheck := time.NewTicker(membershipCheckInterval)
+		defer membershipCheck.Stop()
+
 		for {
 			select {
+			case <-membershipCheck.C:
+				ch.pushMembership()
+				ch.removeReadOnlyClients()
+				ch.adm.expire(time.Now())
 			case trustID := <-ch.trust:
 				ch.leader = trustID
 			case req := <-ch.reqChan:
 				ch.handleRequest(req)
 			case resp := <-ch.respChan:
 				ch.handleResponse(resp)
+			case req := <-ch.dropChan:
+				ch.adm.done(req.GetId(), req.GetSeq())
+			case ids := <-ch.expireChan:
+				ch.handleExpire(ids)
 			case grpPrepare := <-ch.grpSubscriber.PrepareChan():
 				ch.handleGrpHold(grpPrepare)
 			case <-ch.stop:
@@ -126,6 +152,18 @@ func (ch *ClientHandlerTCP) ForwardResponse(resp *Response) {
 	ch.respChan <- resp
 }
 
+// DropRequest tells the ClientHandler that req will not be answered, so that
+// it no longer counts as in flight.
+func (ch *ClientHandlerTCP) DropRequest(req *Request) {
+	ch.dropChan <- req
+}
+
+// ExpireSessions closes the connections of the given clients and forgets
+// them. It is called when the expiry of their sessions has been decided.
+func (ch *ClientHandlerTCP) ExpireSessions(ids []string) {
+	ch.expireChan <- ids
+}
+
 // Shut down the ClientHandler module.
 func (ch *ClientHandlerTCP) Stop() {
 	ch.stop <- true
@@ -148,12 +186,13 @@ func (ch *ClientHandlerTCP) greetClient(conn net.Conn) {
 		return
 	}
 
-	if ch.redirect() {
+	if ch.redirect() && !req.GetFollowerReads() {
 		// If I'm not the leader and don't allow direct messages, then
 		// redirect the client to the leader.
 		if leader, found := ch.grpmgr.NodeMap().LookupNode(ch.leader); found {
 			glog.V(2).Info("greetClient: sending redirect and closing")
 			resp := genErrResp(Response_HELLO_RESP, Response_REDIRECT, leader.ClientAddr())
+			ch.addMembership(resp)
 			write(conn, resp)
 			conn.Close()
 			return
@@ -169,6 +208,7 @@ func (ch *ClientHandlerTCP) greetClient(conn net.Conn) {
 	glog.V(2).Infof("greetClient: id %v accepted, replying and serving", req.GetId())
 	resp := genResp(Response_HELLO_RESP, nil)
 	resp.Protocol = ch.getPaxosType()
+	ch.addMembership(resp)
 	err = write(conn, resp)
 	if err != nil {
 		glog.Warning("greetClient: write error, closing:", err)
@@ -176,6 +216,8 @@ func (ch *ClientHandlerTCP) greetClient(conn net.Conn) {
 		return
 	}
 	cc := NewClientConn(conn, ch.reqChan)
+	cc.watchMembers = req.GetWatchMembers()
+	cc.followerReads = req.GetFollowerReads()
 	ch.clients[req.GetId()] = cc
 	go cc.Serve()
 	return
@@ -194,6 +236,12 @@ func (ch *ClientHandlerTCP) handleRequest(req *Request) {
 		glog.Infoln("received", req.SimpleString())
 	}
 
+	if req.GetType() == Request_READ {
+		// Reads are served by any replica that is recent enough
+		ch.forward(req)
+		return
+	}
+
 	if ch.redirect() {
 		// If I'm not the leader and don't allow direct messages, then
 		// redirect the client to the leader.
@@ -212,11 +260,23 @@ func (ch *ClientHandlerTCP) handleRequest(req *Request) {
 		}
 
 		resp := genErrResp(Response_EXEC_RESP, Response_REDIRECT, leader.ClientAddr())
+		ch.addMembership(resp)
 		client.WriteAsync(resp)
 		return
 	}
 
-	if req.GetType() != Request_EXEC {
+	switch req.GetType() {
+	case Request_EXEC:
+	case Request_KEEPALIVE:
+		select {
+		case ch.propChan <- req:
+		default:
+			// Keepalives are sent again, and an overloaded replica
+			// is not idle enough to expire the client
+			glog.V(2).Infoln("dropping keepalive from", req.GetId())
+		}
+		return
+	default:
 		glog.Warning("received message from client was not a command, ignoring")
 		return
 	}
@@ -234,10 +294,50 @@ func (ch *ClientHandlerTCP) handleRequest(req *Request) {
 		}
 	}
 
-	ch.propChan <- req
+	ch.forward(req)
+}
+
+// forward passes req on for agreement if it is admitted, and rejects it
+// otherwise. Requests are also rejected if the replica cannot keep up, so
+// that clients are told to back off instead of waiting for a response.
+func (ch *ClientHandlerTCP) forward(req *Request) {
+	code, retryAfter := ch.adm.admit(req, time.Now())
+	if code != Response_NONE {
+		ch.reject(req, code, retryAfter)
+		return
+	}
+	select {
+	case ch.propChan <- req:
+	default:
+		ch.adm.rejected(req)
+		ch.reject(req, Response_OVERLOADED, ch.adm.retryAfter)
+	}
+}
+
+func (ch *ClientHandlerTCP) reject(req *Request, code Response_Error, retryAfter time.Duration) {
+	glog.V(2).Infof("rejecting %v: %v", req.SimpleString(), code)
+	client, found := ch.clients[req.GetId()]
+	if !found || !client.connected {
+		return
+	}
+	var detail string
+	switch code {
+	case Response_TOO_LARGE:
+		detail = fmt.Sprintf("request larger than %d bytes", ch.adm.maxRequestSize)
+	case Response_OVERLOADED:
+		detail = "replica overloaded"
+	}
+	resp := genErrResp(Response_EXEC_RESP, code, detail)
+	resp.Id, resp.Seq = req.Id, req.Seq
+	if retryAfter > 0 {
+		ms := uint32((retryAfter + time.Millisecond - 1) / time.Millisecond)
+		resp.RetryAfterMs = &ms
+	}
+	client.WriteAsync(resp)
 }
 
 func (ch *ClientHandlerTCP) handleResponse(resp *Response) {
+	ch.adm.done(resp.GetId(), resp.GetSeq())
 	cc, found := ch.clients[resp.GetId()]
 	if !found || !cc.connected {
 		return
@@ -247,10 +347,79 @@ func (ch *ClientHandlerTCP) handleResponse(resp *Response) {
 		glog.Infoln("client found and connected, sending", resp.SimpleString())
 	}
 
-	ch.replies[resp.GetId()] = resp
+	if !cc.followerReads {
+		ch.replies[resp.GetId()] = resp
+	}
 	cc.WriteAsync(resp)
 }
 
+func (ch *ClientHandlerTCP) handleExpire(ids []string) {
+	for _, id := range ids {
+		if cc, found := ch.clients[id]; found {
+			glog.V(2).Infoln("session expired, closing connection to", cc)
+			cc.Close()
+			delete(ch.clients, id)
+		}
+		delete(ch.replies, id)
+		ch.adm.forget(id)
+	}
+}
+
+// membership returns a MEMBERSHIP response with the client addresses of the
+// current members, ordered by Paxos id. The epoch is the highest epoch of any
+// member, which increases whenever a replica is replaced.
+func (ch *ClientHandlerTCP) membership() *Response {
+	nm := ch.grpmgr.NodeMap()
+	ids := append([]grp.ID(nil), nm.IDs()...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].PaxosID < ids[j].PaxosID })
+	resp := genResp(Response_MEMBERSHIP, nil)
+	var epoch uint64
+	for _, id := range ids {
+		node, _ := nm.LookupNode(id)
+		resp.Members = append(resp.Members, node.ClientAddr())
+		if uint64(id.Epoch) > epoch {
+			epoch = uint64(id.Epoch)
+		}
+	}
+	resp.Epoch = &epoch
+	return resp
+}
+
+// addMembership adds the current epoch and members to resp.
+func (ch *ClientHandlerTCP) addMembership(resp *Response) {
+	m := ch.membership()
+	resp.Epoch, resp.Members = m.Epoch, m.Members
+}
+
+// pushMembership sends the membership to every client that asked to watch
+// it, if it has changed since it was last pushed.
+func (ch *ClientHandlerTCP) pushMembership() {
+	resp := ch.membership()
+	membID := MembID(resp.Members)
+	if MembIDEqual(membID, ch.membID) {
+		return
+	}
+	ch.membID = membID
+	glog.V(2).Infof("membership changed to %v in epoch %d, notifying clients",
+		resp.Members, resp.GetEpoch())
+	for _, cc := range ch.clients {
+		if cc.connected && cc.watchMembers {
+			cc.WriteAsync(resp)
+		}
+	}
+}
+
+// removeReadOnlyClients forgets disconnected clients that only sent reads.
+// They have no session, so they are never expired.
+func (ch *ClientHandlerTCP) removeReadOnlyClients() {
+	for id, cc := range ch.clients {
+		if cc.followerReads && !cc.connected {
+			delete(ch.clients, id)
+			ch.adm.forget(id)
+		}
+	}
+}
+
 // redirect returns true if a request should be redirected to the current
 // leader.
 func (ch *ClientHandlerTCP) redirect() bool {
@@ -271,6 +440,7 @@ func (ch *ClientHandlerTCP) handleGrpHold(gp *sync.WaitGroup) {
 	if leader {
 		ch.leader = ch.id
 	}
+	ch.pushMembership()
 }
 
 func (ch *ClientHandlerTCP) getPaxosType() *Response_Protocol {
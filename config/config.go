@@ -99,6 +99,28 @@ func (c *Config) GetInt(key string, defaultVal int) int {
 	return dur
 }
 
+// Returns the config as a float64. If the config is not set, the
+// supplied default value is returned. If the config is not possible
+// to parse as a float (strconv.ParseFloat), the default value is
+// returned and an warning message is written to glog.
+func (c *Config) GetFloat(key string, defaultVal float64) float64 {
+	cfgValue, found := c.values[key]
+
+	if !found {
+		return defaultVal
+	}
+
+	f, err := strconv.ParseFloat(cfgValue, 64)
+
+	if err != nil {
+		glog.Warningf("Could not parse config \"%s\": \"%s\" as float (see strconv.ParseFloat). Using default value: \"%v\".",
+			key, cfgValue, defaultVal)
+		return defaultVal
+	}
+
+	return f
+}
+
 // Returns the config as an time.Duration. If the config is not set,
 // the supplied default value is returned. If the config is not
 // possible to parse as an int (time.ParseDuration), the default value
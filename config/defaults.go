@@ -42,6 +42,73 @@ const (
 	// timeout by?
 	DefFdDeltaIncrease = 250 * time.Millisecond
 
+	// fdType: Timeout | PhiAccrual
+	// Timeout suspects a node that sent no heartbeat during the last
+	// fdTimeoutInterval. PhiAccrual suspects a node when the phi value
+	// computed from its recent heartbeat inter-arrival times exceeds
+	// fdPhiThreshold.
+	DefFdType = "Timeout"
+
+	// fdPhiThreshold: float
+	// Phi value at which a node is suspected. A phi of 8 means that the
+	// probability of the suspicion being false is about 10^-8.
+	DefFdPhiThreshold = 8.0
+
+	// fdPhiWindowSize: int
+	// Number of heartbeat inter-arrival times kept per node.
+	DefFdPhiWindowSize = 100
+
+	// fdPhiMinStdDev: duration
+	// Lower bound for the standard deviation of inter-arrival times, so
+	// that very regular heartbeats do not make phi rise too quickly.
+	DefFdPhiMinStdDev = 50 * time.Millisecond
+
+	// fdPhiCheckInterval: duration
+	// How frequently does the PhiAccrual FD compute phi for each node?
+	DefFdPhiCheckInterval = 100 * time.Millisecond
+
+	// fdProbeHelpers: int
+	// Before suspecting a node, ask this many other nodes whether they
+	// still receive heartbeats from it. The node is only suspected if
+	// none of them do. 0 turns off indirect probing.
+	DefFdProbeHelpers = 0
+
+	// fdProbeTimeout: duration
+	// How long do we wait for answers from the helpers of an indirect
+	// probe before suspecting the node?
+	DefFdProbeTimeout = 250 * time.Millisecond
+
+	// fdQuorumConfirm: bool
+	// If true, failure handlers (AReconfiguration, Reconfiguration) only
+	// act on a node once a quorum of nodes suspect it.
+	DefFdQuorumConfirm = false
+
+	// leaderDetector: Monarchical | UpToDate | Preferred
+	// Monarchical trusts the non-suspected node with the highest id.
+	// UpToDate does the same, but ignores nodes lagging more than
+	// ldMaxLag slots behind. Preferred trusts the first non-suspected
+	// node in ldPreferredLeaders.
+	DefLeaderDetector = "Monarchical"
+
+	// ldMaxLag: int
+	// How many slots may a node lag behind the most up-to-date node and
+	// still be elected by the UpToDate leader detector?
+	DefLdMaxLag = 50
+
+	// ldStatusInterval: duration
+	// How frequently does the UpToDate leader detector broadcast the
+	// progress of this node?
+	DefLdStatusInterval = 250 * time.Millisecond
+
+	// ldPreferredLeaders: [id], ...
+	// Node ids in order of preference for the Preferred leader detector.
+	DefLdPreferredLeaders = ""
+
+	// ldTransferTimeout: duration
+	// How long does the target of a leadership transfer wait to catch up
+	// with the old leader before it takes over anyway?
+	DefLdTransferTimeout = 2 * time.Second
+
 	// parallelPaxosProposers: int
 	// Number of parallel proposers to run
 	DefParallelPaxosProposers = 2
@@ -88,6 +155,132 @@ const (
 
 	DefLivenessValues = "100,50,250"
 
+	// netChunkSize: int (bytes)
+	// Large messages, such as catch-up responses, are sent to other
+	// replicas in chunks of at most this size, so that heartbeats and
+	// other small messages can be sent in between.
+	DefNetChunkSize = 64 * 1024
+
+	// reconnectMinBackoff: duration
+	// How long do we wait before redialing a replica the first time a
+	// connection attempt fails or a connection is lost?
+	DefReconnectMinBackoff = 100 * time.Millisecond
+
+	// reconnectMaxBackoff: duration
+	// Upper bound for the exponentially increasing wait between
+	// connection attempts to a replica.
+	DefReconnectMaxBackoff = 5 * time.Second
+
+	// reconnectQueuePolicy: Drop | Queue
+	// What do we do with messages to a replica we are not connected to?
+	// Queued messages are sent when the connection is reestablished.
+	DefReconnectQueuePolicy = "Drop"
+
+	// reconnectQueueSize: int
+	// Maximum number of messages queued per disconnected replica. The
+	// oldest message is dropped when the queue is full.
+	DefReconnectQueueSize = 128
+
+	// sessionMaxReplies: int
+	// Maximum number of responses kept per client for commands the
+	// client has not acknowledged. Resent commands older than the kept
+	// responses are answered with OLD_CMD instead of being executed again.
+	DefSessionMaxReplies = 1024
+
+	// sessionMaxExpired: int
+	// Number of ended client sessions remembered, so that commands
+	// resent by their clients are rejected with SESSION_EXPIRED instead
+	// of being executed again. 0 means no limit.
+	DefSessionMaxExpired = 65536
+
+	// sessionTimeout: duration
+	// The leader proposes to end the session of a client it has not
+	// heard from for this long. Requests from a client whose session
+	// has ended are rejected with SESSION_EXPIRED. 0 turns off expiry.
+	DefSessionTimeout = 5 * time.Minute
+
+	// stateHashInterval: int
+	// Number of slots between the state digests replicas compare to
+	// detect diverged state. Requires an application implementing
+	// app.Hasher. 0 turns off the comparison.
+	DefStateHashInterval = 0
+
+	// stateHashQuarantine: bool
+	// Should a replica whose state digest differs from that of a
+	// majority give up leadership, and stop answering clients and voting?
+	// Only supported by the multipaxos, parallelpaxos and batchpaxos
+	// protocols.
+	DefStateHashQuarantine = false
+
+	// executionWorkers: int
+	// Number of commands of an application implementing
+	// app.KeyedHandler that may execute at the same time, if their keys
+	// do not conflict. 0 means GOMAXPROCS, and 1 executes commands one
+	// at a time.
+	DefExecutionWorkers = 0
+
+	// maxPendingExecutions: int
+	// Maximum number of decided commands waiting to be executed or
+	// applied before the server stops taking decided values.
+	DefMaxPendingExecutions = 1024
+
+	// followerReads: bool
+	// Should replicas, the leader included, serve READ requests from
+	// their applied state? Requires an application implementing
+	// app.Reader. If not, READs are redirected, and clients send them
+	// through the log.
+	DefFollowerReads = false
+
+	// readStatusInterval: duration
+	// How often does a replica serving follower reads tell the others
+	// the highest slot it has learned to be decided? Reads are only
+	// served within a slot bound if a quorum, or the leader, has told
+	// within the last three intervals.
+	DefReadStatusInterval = 100 * time.Millisecond
+
+	// readWaitTimeout: duration
+	// How long may a READ wait for the replica to catch up before the
+	// client is redirected to the leader?
+	DefReadWaitTimeout = 500 * time.Millisecond
+
+	// maxInFlight: int
+	// Maximum number of client requests a replica has accepted but not
+	// yet answered. Further requests are rejected with OVERLOADED. 0
+	// means no limit.
+	DefMaxInFlight = 4096
+
+	// maxRequestSize: int (bytes)
+	// Requests with a larger value are rejected with TOO_LARGE. 0 means
+	// no limit.
+	DefMaxRequestSize = 1 << 20
+
+	// clientRateLimit: float (requests per second)
+	// How many requests per second a replica accepts from each client,
+	// on average. Further requests are rejected with OVERLOADED. 0
+	// means no limit.
+	DefClientRateLimit = 0.0
+
+	// clientRateBurst: int
+	// How many requests a client may send at once before being limited
+	// by clientRateLimit.
+	DefClientRateBurst = 100
+
+	// overloadRetryAfter: duration
+	// How long a replica that has too many requests in flight tells
+	// clients to wait before resending.
+	DefOverloadRetryAfter = 100 * time.Millisecond
+
+	// stateSpoolDir: string
+	// Directory to write the state of applications implementing
+	// app.Streamer to before it is transferred to a new replica. Empty
+	// means the default directory for temporary files.
+	DefStateSpoolDir = ""
+
+	// traceDir: string
+	// Directory to record a trace of replica inputs to, for later
+	// replay. Empty turns off tracing.
+	DefTraceDir = ""
+
 	// Dunno if this is used:
 	MinNrNodes = 3
 
@@ -131,4 +324,36 @@ const (
 	// awaitResponseTimeout: int (seconds)
 	// How long should we wait before timing out (wihtout a response) when sending requests?
 	DefAwaitResponseTimeout = 60 * time.Second
+
+	// maxRedirects: int
+	// How many times may a replica redirect us to the leader before the
+	// client gives up connecting?
+	DefMaxRedirects = 5
+
+	// resendInterval: duration
+	// How long does the client wait for a response before resending a
+	// request?
+	DefResendInterval = 5 * time.Second
+
+	// keepaliveInterval: duration
+	// How often does an idle client tell the leader that it is still
+	// alive, so that its session does not expire? Should be well below
+	// the sessionTimeout of the replicas. 0 turns off keepalives.
+	DefKeepaliveInterval = 1 * time.Minute
+
+	// clientBatchMaxSize: int
+	// Maximum number of requests a Client writes in one frame. 1 turns
+	// off batching.
+	DefClientBatchMaxSize = 64
+
+	// clientBatchLinger: duration
+	// How long does a Client wait for more requests before writing a
+	// frame? With 0, only requests sent while the previous frame was
+	// being written are batched.
+	DefClientBatchLinger = time.Duration(0)
+
+	// clientWindow: int
+	// Maximum number of outstanding requests of a Client. Further
+	// requests wait until a response arrives. 0 means no limit.
+	DefClientWindow = 1024
 )
@@ -41,9 +41,13 @@ const (
 	ReconfigJoined            Type = 41
 
 	// Failure Handling Common: 48-55
-	FailureHandlingSuspect   Type = 48
-	FailureHandlingInitStart Type = 49
-	FailureHandlingInitDone  Type = 50
+	FailureHandlingSuspect        Type = 48
+	FailureHandlingInitStart      Type = 49
+	FailureHandlingInitDone       Type = 50
+	FailureHandlingProbeStart     Type = 51
+	FailureHandlingProbeRefuted   Type = 52
+	FailureHandlingProbeConfirmed Type = 53
+	FailureHandlingQuorumSuspect  Type = 54
 
 	// Catch-up: 56-63
 	CatchUpMakeReq          Type = 56
@@ -75,6 +79,10 @@ const (
 
 	// Client Request Latency: 88-95
 	ClientRequestLatency Type = 88
+
+	// State divergence: 96-103
+	StateHashMismatch Type = 96
+	StateQuarantined  Type = 97
 )
 
 //go:generate stringer -type=Type
@@ -106,7 +114,9 @@ const layout = "2006-01-02 15:04:05.999999999"
 
 func (e Event) String() string {
 	switch e.Type {
-	case FailureHandlingSuspect, ThroughputSample:
+	case FailureHandlingSuspect, FailureHandlingProbeStart,
+		FailureHandlingProbeRefuted, FailureHandlingProbeConfirmed,
+		FailureHandlingQuorumSuspect, ThroughputSample, StateHashMismatch:
 		return fmt.Sprintf("%v:\t%30v %3d",
 			e.Time.Format(layout), e.Type, e.Value)
 	case ClientRequestLatency:
@@ -4,7 +4,7 @@ package event
 
 import "fmt"
 
-const _Type_name = "UnknownStartRunningProcessingShutdownStartExitThroughputSampleInitListeningInitTransferStartInitTransferDoneInitInitializedLRWaitForActivationLRActivatedReconfigFirstSlotReceivedReconfigJoinedFailureHandlingSuspectFailureHandlingInitStartFailureHandlingInitDoneCatchUpMakeReqCatchUpSentReqCatchUpRecvReqCatchUpSentRespCatchUpRecvRespCatchUpDoneHandlingRespLRStartLRPrepareEpochSentLRPrepareEpochRecvLRActivatedFromPELRPreConnectSleepReconfigStartReconfigProposeReconfigExecReconfCmdReconfigDoneARecStartARecRMSentARecStopPaxosARecActivatedFromCPsARecRestartClientRequestLatency"
+const _Type_name = "UnknownStartRunningProcessingShutdownStartExitThroughputSampleInitListeningInitTransferStartInitTransferDoneInitInitializedLRWaitForActivationLRActivatedReconfigFirstSlotReceivedReconfigJoinedFailureHandlingSuspectFailureHandlingInitStartFailureHandlingInitDoneFailureHandlingProbeStartFailureHandlingProbeRefutedFailureHandlingProbeConfirmedFailureHandlingQuorumSuspectCatchUpMakeReqCatchUpSentReqCatchUpRecvReqCatchUpSentRespCatchUpRecvRespCatchUpDoneHandlingRespLRStartLRPrepareEpochSentLRPrepareEpochRecvLRActivatedFromPELRPreConnectSleepReconfigStartReconfigProposeReconfigExecReconfCmdReconfigDoneARecStartARecRMSentARecStopPaxosARecActivatedFromCPsARecRestartClientRequestLatencyStateHashMismatchStateQuarantined"
 
 var _Type_map = map[Type]string{
 	0:  _Type_name[0:7],
@@ -25,27 +25,33 @@ var _Type_map = map[Type]string{
 	48: _Type_name[192:214],
 	49: _Type_name[214:238],
 	50: _Type_name[238:261],
-	56: _Type_name[261:275],
-	57: _Type_name[275:289],
-	58: _Type_name[289:303],
-	59: _Type_name[303:318],
-	60: _Type_name[318:333],
-	61: _Type_name[333:356],
-	64: _Type_name[356:363],
-	65: _Type_name[363:381],
-	66: _Type_name[381:399],
-	67: _Type_name[399:416],
-	68: _Type_name[416:433],
-	72: _Type_name[433:446],
-	73: _Type_name[446:461],
-	74: _Type_name[461:482],
-	75: _Type_name[482:494],
-	80: _Type_name[494:503],
-	81: _Type_name[503:513],
-	82: _Type_name[513:526],
-	83: _Type_name[526:546],
-	84: _Type_name[546:557],
-	88: _Type_name[557:577],
+	51: _Type_name[261:286],
+	52: _Type_name[286:313],
+	53: _Type_name[313:342],
+	54: _Type_name[342:370],
+	56: _Type_name[370:384],
+	57: _Type_name[384:398],
+	58: _Type_name[398:412],
+	59: _Type_name[412:427],
+	60: _Type_name[427:442],
+	61: _Type_name[442:465],
+	64: _Type_name[465:472],
+	65: _Type_name[472:490],
+	66: _Type_name[490:508],
+	67: _Type_name[508:525],
+	68: _Type_name[525:542],
+	72: _Type_name[542:555],
+	73: _Type_name[555:570],
+	74: _Type_name[570:591],
+	75: _Type_name[591:603],
+	80: _Type_name[603:612],
+	81: _Type_name[612:622],
+	82: _Type_name[622:635],
+	83: _Type_name[635:655],
+	84: _Type_name[655:666],
+	88: _Type_name[666:686],
+	96: _Type_name[686:703],
+	97: _Type_name[703:719],
 }
 
 func (i Type) String() string {
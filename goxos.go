@@ -1,12 +1,16 @@
 package goxos
 
 import (
+	"context"
+
 	"github.com/relab/goxos/app"
 	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/elog"
 	e "github.com/relab/goxos/elog/event"
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
 	"github.com/relab/goxos/server"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
@@ -24,6 +28,7 @@ type Replica struct {
 
 // Create a new Goxos replica. Arguments required are an integer id, a string application id,
 // a path to a configuration file, and an application that fulfills the app.Handler interface.
+// Several applications can share one replica group by passing a mux.Mux holding them.
 func NewReplica(id uint, appID string, config config.Config, ah app.Handler) *Replica {
 	return &Replica{
 		id:          grp.NewIDFromInt(int8(id), 0),
@@ -75,3 +80,119 @@ func (r *Replica) Stop() error {
 	elog.Flush()
 	return err
 }
+
+// TransferLeadership makes the replica with the given id the Paxos leader.
+// The current leader stops proposing, and the new leader starts Phase 1 as
+// soon as it has caught up. Used to move leadership away from a replica
+// before restarting it.
+func (r *Replica) TransferLeadership(id uint) error {
+	if !r.started {
+		return ErrNodeNotRunning
+	}
+	return r.server.TransferLeadership(grp.PaxosID(id))
+}
+
+// Upgrade proposes that every replica switch to the given version of the
+// application at the same slot. The application must be an app.Versions,
+// and the replica must be the leader. Subscribe to events and wait for
+// server.Upgraded to know that the upgrade has been decided.
+func (r *Replica) Upgrade(version uint32) error {
+	if !r.started {
+		return ErrNodeNotRunning
+	}
+	return r.server.Upgrade(version)
+}
+
+// Propose gets cmd executed by the replicated service, and waits for the
+// response of the local application. It is for applications running in the
+// same process as the replica, and needs no client connection. If this
+// replica is not the leader, cmd is forwarded to the leader. The command is
+// executed at most once, even if it has to be resent.
+func (r *Replica) Propose(ctx context.Context, cmd []byte) ([]byte, error) {
+	p, err := r.ProposeAsync(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return p.Result()
+}
+
+// ProposeAsync is like Propose, but does not wait for the response.
+func (r *Replica) ProposeAsync(ctx context.Context, cmd []byte) (*server.Proposal, error) {
+	if !r.started {
+		return nil, ErrNodeNotRunning
+	}
+	return r.server.Propose(ctx, cmd), nil
+}
+
+// Status returns what the replica knows about itself and its group. The
+// zero Status is returned if the replica has not been initialized.
+func (r *Replica) Status() server.Status {
+	if r.server == nil {
+		return server.Status{Leader: grp.UndefinedID()}
+	}
+	return r.server.Status()
+}
+
+// Leader returns the id of the replica this replica considers the leader.
+func (r *Replica) Leader() grp.ID {
+	return r.Status().Leader
+}
+
+// IsLeader returns true if this replica considers itself the leader.
+func (r *Replica) IsLeader() bool {
+	status := r.Status()
+	return status.Leader != grp.UndefinedID() && status.Leader == status.ID
+}
+
+// AppliedSlot returns the slot up to which every decided command has been
+// executed by the local application.
+func (r *Replica) AppliedSlot() paxos.SlotID {
+	return r.Status().AppliedSlot
+}
+
+// Epoch returns the current membership epoch.
+func (r *Replica) Epoch() grp.Epoch {
+	return r.Status().Epoch
+}
+
+// Members returns the ids of the current members, ordered by Paxos id.
+func (r *Replica) Members() []grp.ID {
+	return r.Status().Members
+}
+
+// SubscribeToEvents returns a channel on which leader changes,
+// reconfigurations, and suspicions of other replicas are delivered. Events
+// are dropped if the channel is not read from.
+func (r *Replica) SubscribeToEvents(name string) (<-chan server.Event, error) {
+	if r.server == nil {
+		return nil, ErrNodeNotInitialized
+	}
+	return r.server.SubscribeToEvents(name), nil
+}
+
+// Replay runs the replica in isolation, feeding it the inputs recorded in
+// the given trace file. Replay returns when every recorded input has been
+// replayed. The replica is left running so that its state can be inspected,
+// and must be halted using Stop.
+func (r *Replica) Replay(traceFile string) error {
+	if !r.initialized {
+		return ErrNodeNotInitialized
+	}
+
+	if r.started {
+		return ErrCanNotStartAlreadyRunningNode
+	}
+
+	reader, err := trace.NewReader(traceFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	glog.V(1).Info("replaying trace ", traceFile)
+
+	r.server.InitModulesReplay()
+	r.started = true
+
+	return r.server.Replay(reader)
+}
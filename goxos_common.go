@@ -8,5 +8,6 @@ var (
 	ErrNodeNotInitialized            = errors.New("goxos node must be initialized before started")
 	ErrCanNotStartAlreadyRunningNode = errors.New("can't start already running Goxos node")
 	ErrCanNotStopNonRunningNode      = errors.New("can't stop non-runnning Goxos node")
+	ErrNodeNotRunning                = errors.New("goxos node is not running")
 	ErrMethodUnavailable             = errors.New("method unavailable for a Goxos replacer/reconfig node")
 )
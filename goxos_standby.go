@@ -48,7 +48,7 @@ func (s *StandbyReplica) Standby() error {
 	}
 
 	glog.V(2).Info("attempting to set application state")
-	err = s.ah.SetState(initData.AppState.State)
+	err = initData.SetAppState(s.ah)
 	if err != nil {
 		err = logAbortAndGenError("setting application state failed", err)
 		initData.ApplyStateResult(err)
@@ -69,6 +69,13 @@ func (s *StandbyReplica) Standby() error {
 		s.ah,
 		initData.AppState.SlotMarker,
 	)
+	s.server.SetClock(initData.AppState.Clock)
+	err = s.server.SetSessions(initData.AppState.Sessions)
+	if err != nil {
+		err = logAbortAndGenError("setting client sessions failed", err)
+		initData.ApplyStateResult(err)
+		return err
+	}
 
 	fhType := conf.GetString("failureHandlingType", config.DefFailureHandlingType)
 	switch strings.ToLower(fhType) {
@@ -83,6 +90,8 @@ func (s *StandbyReplica) Standby() error {
 			conf.GetString("failureHandlingType", config.DefFailureHandlingType))
 	}
 
+	s.server.SetLeaderTransfer(initData.AppState.TransferTo, initData.AppState.TransferEpoch)
+
 	glog.V(2).Info("init modules done and application state set, responding to init listener")
 	initData.ApplyStateResult(nil)
 	s.initialized = true
@@ -160,6 +160,7 @@ func (t *MapResponse) Marshal(wire io.Writer) {
 		bs[0] = byte(t.Value[i])
 		wire.Write(bs)
 	}
+	bs = b[:1]
 	bs[0] = byte(t.Found)
 	wire.Write(bs)
 	bs = b[:]
@@ -0,0 +1,33 @@
+package common
+
+import "fmt"
+
+// A TxRequest is a batch of map requests executed atomically, possibly on
+// keys of several shard groups. The requests are executed in order, so a
+// Read returns the value of an earlier Write or Delete of the same key in
+// the batch.
+type TxRequest struct {
+	Reqs []MapRequest
+}
+
+// A TxResponse tells whether a transaction committed, and holds the
+// responses to its requests if it did. Reason tells why it was aborted.
+type TxResponse struct {
+	Committed bool
+	Reason    string
+	Resps     []MapResponse
+}
+
+// Reasons for aborting a transaction.
+const (
+	AbortConflict  = "a key is locked by another transaction"
+	AbortRecovered = "aborted by another client while the transaction was stalled"
+	AbortCanceled  = "the transaction was canceled before it was prepared"
+)
+
+func (resp TxResponse) String() string {
+	if !resp.Committed {
+		return fmt.Sprintf("Transaction aborted: %s", resp.Reason)
+	}
+	return fmt.Sprintf("Transaction of %d requests committed", len(resp.Resps))
+}
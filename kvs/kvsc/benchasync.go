@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/kvs/bgen"
 	kc "github.com/relab/goxos/kvs/common"
 )
@@ -23,11 +25,12 @@ func runBenchAsync() {
 		log.Fatalln("Aborted! Reason:", err)
 	}
 
-	log.Println("Dialing kvs cluster")
-	serviceConnection, err := client.Dial(clientConfig)
+	log.Println("Creating client for kvs cluster")
+	c, err := client.NewClient(clientConfig, stdLogger{})
 	if err != nil {
-		log.Fatalln("Error dailing cluster:", err)
+		log.Fatalln("Error creating client:", err)
 	}
+	defer c.Close()
 
 	reqLatencies := make([][]time.Duration, *runs)
 
@@ -39,7 +42,7 @@ func runBenchAsync() {
 	log.Println("Running...")
 	for i := 0; i < *runs; i++ {
 		log.Println("Performing run", i)
-		reqLatencies[i], err = performAsyncRun(serviceConnection, *cmds)
+		reqLatencies[i], err = performAsyncRun(c, *cmds)
 		if err != nil {
 			log.Fatalln("Aborted! Reason:", err)
 		}
@@ -67,7 +70,7 @@ func countResponsesNotReceived(times [][]time.Duration) int {
 	return counter
 }
 
-func performAsyncRun(conn client.ServiceConn, nrOfCmds int) ([]time.Duration, error) {
+func performAsyncRun(c *client.Client, nrOfCmds int) ([]time.Duration, error) {
 	key := make([]byte, *kl)
 	val := make([]byte, *vl)
 	mapreq := kc.MapRequest{Ct: kc.Write,
@@ -75,47 +78,47 @@ func performAsyncRun(conn client.ServiceConn, nrOfCmds int) ([]time.Duration, er
 		Value: val,
 	}
 
-	responseChannels := make([]<-chan client.ResponseData, nrOfCmds)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		clientConfig.GetDuration("awaitResponseTimeout", config.DefAwaitResponseTimeout))
+	defer cancel()
+	futures := make([]*client.Future, nrOfCmds)
 
 	for i := 0; i < nrOfCmds; i++ {
-		//log.Println("Sending command number: ", i)
 		bgen.GetBytes(key)
 		bgen.GetBytes(val)
 		mapreq.Marshal(buffer)
 
-		responseChannels[i] = conn.Send(buffer.Bytes())
-
-		// time.Sleep(10 * time.Millisecond)
+		// The client keeps the request until it is answered
+		request := append([]byte(nil), buffer.Bytes()...)
+		futures[i] = c.Go(ctx, request)
 
 		buffer.Reset()
 	}
 
 	log.Println("Waiting for responses")
-	reqLatencies, err := getRequestLatencies(responseChannels)
-
-	return reqLatencies, err
+	return getRequestLatencies(futures)
 }
 
-func getRequestLatencies(responseChannels []<-chan client.ResponseData) ([]time.Duration, error) {
-	//<-time.After(2 * time.Second)
-	reqLatencies := make([]time.Duration, len(responseChannels))
-
-	for index, responseChan := range responseChannels {
-	readloop:
-		for readFromChannelAttempts := 0; readFromChannelAttempts < 5; readFromChannelAttempts++ {
-			select {
-			case response := <-responseChan:
-				if response.Err != nil {
-					return nil, response.Err
-				}
-				reqLatencies[index] = response.ReceiveTime.Sub(response.SendTime)
-				//log.Printf("Response received: %v", response)
-				break readloop
-			default:
-				//log.Printf("Waiting 10 ms")
-				<-time.After(10 * time.Millisecond)
-			}
+// getRequestLatencies returns the latency of every request. Requests that
+// timed out have latency 0.
+func getRequestLatencies(futures []*client.Future) ([]time.Duration, error) {
+	reqLatencies := make([]time.Duration, len(futures))
+	for index, f := range futures {
+		_, err := f.Result()
+		switch err {
+		case nil:
+			reqLatencies[index] = f.Latency()
+		case client.ErrTimeout:
+		default:
+			return nil, err
 		}
 	}
 	return reqLatencies, nil
 }
+
+// stdLogger logs client connection events with the standard logger.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -20,7 +20,7 @@ var (
 	configFile = flag.String("config-file", "config.ini", "path for configuration file to be used")
 
 	// Mode
-	mode = flag.String("mode", "", "run mode: (user | bench | bench-async | exp)")
+	mode = flag.String("mode", "", "run mode: (user | bench | bench-async | exp | shard)")
 
 	// Benchmark mode
 	report   = flag.Bool("report", false, "bench: save run report to disk")
@@ -36,6 +36,14 @@ var (
 	kl      = flag.Int("kl", 16, "bench/exp: number of bytes for key")
 	vl      = flag.Int("vl", 16, "bench/exp: number of bytes for value")
 	prewait = flag.Duration("prewait", 0, "batch/exp: pre-start wait")
+
+	// Shard mode; the config file holds the nodes of the partition map group
+	shardOp    = flag.String("op", "map", "shard: operation (map | add-group | split | migrate | read | write | delete | tx-write)")
+	shardGroup = flag.String("group", "", "shard: group to add or migrate to")
+	shardNodes = flag.String("nodes", "", "shard: nodes of the group to add, in the format of the nodes setting")
+	shardKey   = flag.String("key", "", "shard: key to split at, start of the range to migrate, or key to access; comma-separated keys for tx-write")
+	shardEnd   = flag.String("end", "", "shard: end of the range to migrate; empty for the end of the key space")
+	shardValue = flag.String("value", "", "shard: value to write, to every key for tx-write")
 )
 
 func Usage() {
@@ -67,6 +75,8 @@ func main() {
 		runBenchAsync()
 	case "exp":
 		runExp()
+	case "shard":
+		runShard()
 	default:
 		fmt.Fprintf(os.Stderr, "Unkown mode specified: %q\n", *mode)
 		flag.Usage()
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	kc "github.com/relab/goxos/kvs/common"
+	"github.com/relab/goxos/kvs/shard"
+)
+
+// runShard runs a single operation on a sharded store, whose partition map
+// is replicated by the nodes in the config file.
+func runShard() {
+	c, err := shard.NewClient(clientConfig, nil)
+	if err != nil {
+		log.Fatalln("Error creating shard client:", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	switch *shardOp {
+	case "map":
+		m, err := c.Map(ctx)
+		if err != nil {
+			log.Fatalln("Error fetching partition map:", err)
+		}
+		fmt.Println("Partition map version", m.Version)
+		for _, a := range m.Assignments {
+			fmt.Printf("%v\t%s (%s)\n", a.Range, a.Group, m.Groups[a.Group])
+		}
+	case "add-group":
+		err = c.AddGroup(ctx, *shardGroup, *shardNodes)
+	case "split":
+		err = c.Split(ctx, *shardKey)
+	case "migrate":
+		err = c.Migrate(ctx, shard.Range{Start: *shardKey, End: *shardEnd}, *shardGroup)
+	case "read", "write", "delete":
+		ct := map[string]kc.CommandType{"read": kc.Read, "write": kc.Write, "delete": kc.Delete}[*shardOp]
+		var resp kc.MapResponse
+		resp, err = c.Do(ctx, kc.MapRequest{Ct: ct, Key: []byte(*shardKey), Value: []byte(*shardValue)})
+		if err == nil {
+			fmt.Println(resp)
+		}
+	case "tx-write":
+		var tx kc.TxRequest
+		for _, key := range strings.Split(*shardKey, ",") {
+			tx.Reqs = append(tx.Reqs, kc.MapRequest{Ct: kc.Write, Key: []byte(key), Value: []byte(*shardValue)})
+		}
+		var resp kc.TxResponse
+		resp, err = c.Transact(ctx, tx)
+		if err == nil {
+			fmt.Println(resp)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unkown shard operation specified: %q\n", *shardOp)
+		flag.Usage()
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error running %s: %v", *shardOp, err)
+	}
+}
@@ -2,33 +2,31 @@ package main
 
 import (
 	"bytes"
+	"sync"
 
 	kc "github.com/relab/goxos/kvs/common"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
 
+// GoxosHandler is the replicated map. Commands on different keys may be
+// executed concurrently, so the map is guarded by mu.
 type GoxosHandler struct {
+	mu    sync.RWMutex
 	kvmap map[string][]byte
 }
 
-var (
-	buffer = new(bytes.Buffer)
-	kvreq  kc.MapRequest
-	kvresp kc.MapResponse
-)
-
 func (gh *GoxosHandler) Execute(req []byte) (resp []byte) {
-	buffer.Reset()
-	buffer.Write(req)
+	var kvreq kc.MapRequest
+	var kvresp kc.MapResponse
+	var buffer bytes.Buffer
 
-	if err := kvreq.Unmarshal(buffer); err != nil {
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil {
 		glog.Errorln("Execute: Unmarshal error:", err)
-		buffer.Reset()
 		kvresp = kc.MapResponse{
 			Err: []byte("I can't decode you request"),
 		}
-		kvresp.Marshal(buffer)
+		kvresp.Marshal(&buffer)
 		return buffer.Bytes()
 	}
 
@@ -39,7 +37,9 @@ func (gh *GoxosHandler) Execute(req []byte) (resp []byte) {
 	switch kvreq.Ct {
 
 	case kc.Read:
+		gh.mu.RLock()
 		val, found := gh.kvmap[string(kvreq.Key)]
+		gh.mu.RUnlock()
 		kvresp = kc.MapResponse{
 			Value:  val,
 			ToType: kc.Read,
@@ -50,13 +50,17 @@ func (gh *GoxosHandler) Execute(req []byte) (resp []byte) {
 			kvresp.Found = 0
 		}
 	case kc.Write:
+		gh.mu.Lock()
 		gh.kvmap[string(kvreq.Key)] = kvreq.Value
+		gh.mu.Unlock()
 		kvresp = kc.MapResponse{
 			Value:  kvreq.Value,
 			ToType: kc.Write,
 		}
 	case kc.Delete:
+		gh.mu.Lock()
 		delete(gh.kvmap, string(kvreq.Key))
+		gh.mu.Unlock()
 		kvresp = kc.MapResponse{
 			ToType: kc.Delete,
 		}
@@ -65,17 +69,41 @@ func (gh *GoxosHandler) Execute(req []byte) (resp []byte) {
 
 	}
 
-	buffer.Reset()
-	kvresp.Marshal(buffer)
+	kvresp.Marshal(&buffer)
 
 	if glog.V(3) {
 		glog.Info(kvresp)
 	}
 
-	resp = make([]byte, buffer.Len())
-	if _, err := buffer.Read(resp); err != nil {
-		glog.Errorln("Execute:", err)
+	return buffer.Bytes()
+}
+
+// Keys returns the key a map command reads or writes, so that commands on
+// different keys can be executed concurrently. Commands that cannot be
+// decoded do not touch the map.
+func (gh *GoxosHandler) Keys(req []byte) (reads, writes []string) {
+	var kvreq kc.MapRequest
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil {
+		return nil, nil
+	}
+	switch kvreq.Ct {
+	case kc.Read:
+		return []string{string(kvreq.Key)}, nil
+	case kc.Write, kc.Delete:
+		return nil, []string{string(kvreq.Key)}
 	}
+	return nil, nil
+}
 
-	return resp
+// Read answers read requests from the current map, so that replicas can serve
+// them without agreement. Other requests are rejected.
+func (gh *GoxosHandler) Read(req []byte) (resp []byte) {
+	var kvreq kc.MapRequest
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil || kvreq.Ct != kc.Read {
+		var buffer bytes.Buffer
+		kvresp := kc.MapResponse{Err: []byte("Only read requests can be served without agreement")}
+		kvresp.Marshal(&buffer)
+		return buffer.Bytes()
+	}
+	return gh.Execute(req)
 }
@@ -10,8 +10,10 @@ import (
 	"syscall"
 
 	"github.com/relab/goxos"
+	"github.com/relab/goxos/app"
 	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/kvs/common"
+	"github.com/relab/goxos/kvs/shard"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/davecheney/profile"
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
@@ -22,11 +24,13 @@ const appID = "kvs"
 var (
 	id             = flag.Uint("id", 0, "id for node (must match entry in config file)")
 	configFile     = flag.String("config-file", "config.ini", "path for configuration file to be used")
-	mode           = flag.String("mode", "normal", "replica operation mode (normal | standby)")
+	mode           = flag.String("mode", "normal", "replica operation mode (normal | standby | replay)")
+	shardRole      = flag.String("shard", "", "run as part of a sharded store: map (the partition map) | store (a shard group)")
 	allCores       = flag.Bool("all-cores", false, "use all available logical CPUs")
 	gcOff          = flag.Bool("gc-off", false, "turn garbage collection off")
 	loadState      = flag.String("load-state", "", "file path to gob encoded data to use as initial state")
 	standbyIP      = flag.String("standby-ip", "127.0.0.1", "the node's IP address if started as replacer")
+	traceFile      = flag.String("trace-file", "", "trace file to replay in replay mode")
 	writeStateHash = flag.Bool("write-state-hash", true, "write hash of state to disk on exit")
 	cpuprofile     = flag.Bool("cpuprofile", false, "write cpu profile to disk")
 	memprofile     = flag.Bool("memprofile", false, "write memory profile to disk")
@@ -88,6 +92,22 @@ func start() {
 	gh := &GoxosHandler{
 		kvmap: make(map[string][]byte),
 	}
+	var ah app.Handler = gh
+
+	switch *shardRole {
+	case "":
+	case "map":
+		ah = shard.NewMapService()
+	case "store":
+		ah = shard.NewStore()
+	default:
+		fmt.Fprintf(os.Stderr, "Unkown shard role provided (%q)", *shardRole)
+		flag.Usage()
+		os.Exit(0)
+	}
+	if *shardRole != "" && *loadState != "" {
+		glog.Fatalln("an initial state cannot be loaded for a sharded store")
+	}
 
 	if *loadState != "" {
 		if *mode == "replacer" || *mode == "reconfig" {
@@ -100,7 +120,7 @@ func start() {
 	}
 
 	defer func() {
-		if *writeStateHash {
+		if *writeStateHash && *shardRole == "" {
 			if err := gh.writeStateHash(); err != nil {
 				glog.Warning(err)
 			}
@@ -120,7 +140,7 @@ func start() {
 	switch *mode {
 
 	case "normal":
-		goxos := goxos.NewReplica(*id, appID, *goxosConfig, gh)
+		goxos := goxos.NewReplica(*id, appID, *goxosConfig, ah)
 
 		goxos.Init()
 		if err := goxos.Start(); err != nil {
@@ -135,7 +155,7 @@ func start() {
 		}()
 
 	case "standby":
-		goxos := goxos.NewStandbyReplica(gh, appID, *standbyIP)
+		goxos := goxos.NewStandbyReplica(ah, appID, *standbyIP)
 		if err := goxos.Standby(); err != nil {
 			glog.Fatalln("initialization of goxos replacer failed:", err)
 		}
@@ -147,6 +167,18 @@ func start() {
 			}
 		}()
 
+	case "replay":
+		goxos := goxos.NewReplica(*id, appID, *goxosConfig, ah)
+
+		goxos.Init()
+		if err := goxos.Replay(*traceFile); err != nil {
+			glog.Errorln("replay failed:", err)
+		}
+		if err := goxos.Stop(); err != nil {
+			glog.Errorln("error when stopping goxos:", err)
+		}
+		return
+
 	default:
 		fmt.Fprintf(os.Stderr, "Unkown operation mode provided (%q)", *mode)
 		flag.Usage()
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/gob"
+	"io"
 	"io/ioutil"
 	"sort"
 
@@ -29,6 +31,43 @@ func (gh *GoxosHandler) SetState(state []byte) error {
 	return nil
 }
 
+// A kvPair is a map entry of a streamed state.
+type kvPair struct {
+	Key   string
+	Value []byte
+}
+
+// WriteState writes the map one entry at a time, so that a large map is not
+// encoded in memory at once.
+func (gh *GoxosHandler) WriteState(slotMarker uint, w io.Writer) (sm uint, err error) {
+	bw := bufio.NewWriter(w)
+	encoder := gob.NewEncoder(bw)
+	for k, v := range gh.kvmap {
+		if err := encoder.Encode(kvPair{k, v}); err != nil {
+			return 0, err
+		}
+	}
+	return slotMarker, bw.Flush()
+}
+
+func (gh *GoxosHandler) ReadState(r io.Reader) error {
+	decoder := gob.NewDecoder(r)
+	kvmap := make(map[string][]byte)
+	for {
+		var pair kvPair
+		err := decoder.Decode(&pair)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		kvmap[pair.Key] = pair.Value
+	}
+	gh.kvmap = kvmap
+	return nil
+}
+
 func (gh *GoxosHandler) loadState(filePath string) error {
 	glog.V(1).Infoln("loading state from", filePath)
 
@@ -47,13 +86,24 @@ func (gh *GoxosHandler) loadState(filePath string) error {
 	return nil
 }
 
-func (gh *GoxosHandler) writeStateHash() error {
+// StateHash returns a SHA-1 hash of the map, so that replicas can compare
+// their states while running.
+func (gh *GoxosHandler) StateHash() []byte {
 	// Map key iteration order is randomized. The bytes from gob encoding
 	// the map is therefore not deterministic and results in different
 	// hashes.
-	glog.V(1).Info("generating state hash...")
+	keys := gh.sortedKeys()
+
+	// Write every key and value to hasher in key sorted order
+	hasher := sha1.New()
+	for i := range keys {
+		hasher.Write([]byte(keys[i]))
+		hasher.Write(gh.kvmap[keys[i]])
+	}
+	return hasher.Sum(nil)
+}
 
-	// Get all keys
+func (gh *GoxosHandler) sortedKeys() []string {
 	keys := make([]string, len(gh.kvmap))
 	i := 0
 	for k := range gh.kvmap {
@@ -61,16 +111,15 @@ func (gh *GoxosHandler) writeStateHash() error {
 		i++
 	}
 	sort.Strings(keys)
+	return keys
+}
 
-	// Write every key and value to hasher in key sorted order
-	hasher := sha1.New()
-	for i := range keys {
-		hasher.Write([]byte(keys[i]))
-		hasher.Write(gh.kvmap[keys[i]])
-	}
-	stateFingerprint := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+func (gh *GoxosHandler) writeStateHash() error {
+	glog.V(1).Info("generating state hash...")
+	stateFingerprint := base64.StdEncoding.EncodeToString(gh.StateHash())
 
 	// Debugging information
+	keys := gh.sortedKeys()
 	glog.V(1).Infof("hash generated using %d map entries", len(keys))
 	if len(gh.kvmap) > 1 {
 		glog.V(1).Infof("first entry: %s - %s",
@@ -0,0 +1,356 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/app/router"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
+	kc "github.com/relab/goxos/kvs/common"
+)
+
+// How long a Client waits before retrying a request for a range that is
+// being moved, for a key that is locked, or for a group that rejected the
+// key.
+const movingBackoff = 50 * time.Millisecond
+
+// How many times a Client fetches the map again without its version
+// changing before it gives up on a group rejecting a key the map assigns it.
+const maxStaleMaps = 3
+
+// How many times in a row a Client retries a request for a range that is
+// being moved before it gives up, e.g. because the Client moving it has
+// stopped halfway.
+const maxMovingRetries = 200
+
+// How long a Client takes at most to undo a failed Migrate.
+const rollbackTimeout = 10 * time.Second
+
+// A Client sends map requests to the groups owning their keys, and changes
+// the partition map. It may be used by many goroutines at once.
+type Client struct {
+	conf   *config.Config
+	logger client.Logger
+	meta   *router.Stub
+	admin  *router.Router // Declares the commands of a Store
+
+	mu     sync.Mutex // Guards the fields below
+	m      *Map
+	metaC  *client.Client
+	groups map[string]*client.Client
+	locked map[string]time.Time // Transaction id → when its lock was first met
+}
+
+// NewClient returns a Client for the map group with the nodes given in
+// conf. The other settings of conf are used for the clients of the shard
+// groups as well.
+func NewClient(conf *config.Config, logger client.Logger) (*Client, error) {
+	metaC, err := client.NewClient(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conf:   conf,
+		logger: logger,
+		meta:   mapCommands(router.New(router.GobCodec{}), nil).Stub(metaC.Do),
+		admin:  storeCommands(router.New(router.GobCodec{}), nil),
+		m:      new(Map),
+		metaC:  metaC,
+		groups: make(map[string]*client.Client),
+		locked: make(map[string]time.Time),
+	}, nil
+}
+
+// Close closes the connections to every group.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.metaC.Close()
+	for _, gc := range c.groups {
+		if cerr := gc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Map fetches the partition map from the map group.
+func (c *Client) Map(ctx context.Context) (*Map, error) {
+	c.mu.Lock()
+	known := c.m.Version
+	c.mu.Unlock()
+	m := new(Map)
+	if err := c.meta.Call(ctx, cmdMap, known, m); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m.Version >= c.m.Version {
+		c.m = m
+	}
+	return c.m, nil
+}
+
+// group returns the client of the named group.
+func (c *Client) group(name string) (*client.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gc, found := c.groups[name]; found {
+		return gc, nil
+	}
+	nodes, found := c.m.Groups[name]
+	if !found {
+		return nil, fmt.Errorf("shard: unknown group %q", name)
+	}
+	conf := config.NewConfig()
+	for k, v := range c.conf.CloneToKeyValueMap() {
+		conf.Set(k, v)
+	}
+	conf.Set("nodes", nodes)
+	gc, err := client.NewClient(conf, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.groups[name] = gc
+	return gc, nil
+}
+
+// owner returns the name of the group owning key in the map, and the
+// version of the map, fetching the map if it does not assign key.
+func (c *Client) owner(ctx context.Context, key string) (string, uint64, error) {
+	c.mu.Lock()
+	a, found := c.m.Lookup(key)
+	version := c.m.Version
+	c.mu.Unlock()
+	if !found {
+		m, err := c.Map(ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		if a, found = m.Lookup(key); !found {
+			return "", 0, errors.New("shard: no group has been added")
+		}
+		version = m.Version
+	}
+	return a.Group, version, nil
+}
+
+// Do sends req to the group owning its key, and returns its response.
+// Requests rejected because the range has moved, or is being moved, are
+// resent once the map has been fetched again, until ctx is done. If the
+// group owning the key rejects it, and the map fetched maxStaleMaps times
+// in a row is no newer, an error is returned, as it is if the range is still
+// being moved after maxMovingRetries retries. Requests for keys locked by a
+// transaction are resent once it has finished, or has been aborted after
+// stalling for lockTimeout.
+func (c *Client) Do(ctx context.Context, req kc.MapRequest) (kc.MapResponse, error) {
+	var buf bytes.Buffer
+	req.Marshal(&buf)
+	stale, moving := 0, 0
+	for {
+		group, version, err := c.owner(ctx, string(req.Key))
+		if err != nil {
+			return kc.MapResponse{}, err
+		}
+		gc, err := c.group(group)
+		if err != nil {
+			return kc.MapResponse{}, err
+		}
+		val, err := gc.Do(ctx, buf.Bytes())
+		if err != nil {
+			return kc.MapResponse{}, err
+		}
+		var resp kc.MapResponse
+		if err := resp.Unmarshal(bytes.NewReader(val)); err != nil {
+			return kc.MapResponse{}, err
+		}
+		switch msg := string(resp.Err); {
+		case strings.HasPrefix(msg, ErrKeyLocked):
+			if err := c.stalled(ctx, group, strings.TrimPrefix(msg, ErrKeyLocked)); err != nil {
+				return kc.MapResponse{}, err
+			}
+			if err := backoff(ctx); err != nil {
+				return kc.MapResponse{}, err
+			}
+		case msg == ErrRangeMoving, msg == ErrWrongGroup:
+			if msg == ErrRangeMoving {
+				if moving++; moving == maxMovingRetries {
+					return kc.MapResponse{}, fmt.Errorf("shard: key %q is still being moved after %d retries",
+						req.Key, moving)
+				}
+			}
+			if err := backoff(ctx); err != nil {
+				return kc.MapResponse{}, err
+			}
+			m, err := c.Map(ctx)
+			if err != nil {
+				return kc.MapResponse{}, err
+			}
+			switch {
+			case m.Version > version || msg == ErrRangeMoving:
+				stale = 0
+			case stale < maxStaleMaps-1:
+				stale++
+			default:
+				return kc.MapResponse{}, fmt.Errorf("shard: group %q rejects key %q under map version %d",
+					group, req.Key, version)
+			}
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// backoff waits movingBackoff, or until ctx is done.
+func backoff(ctx context.Context) error {
+	select {
+	case <-time.After(movingBackoff):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddGroup adds a group with the given nodes, in the format of the nodes
+// setting, to the map. The first group added owns every key.
+func (c *Client) AddGroup(ctx context.Context, name, nodes string) error {
+	if err := c.meta.Call(ctx, cmdAddGroup, addGroupArgs{name, nodes}, nil); err != nil {
+		return err
+	}
+	m, err := c.Map(ctx)
+	if err != nil {
+		return err
+	}
+	if rs := m.Of(name); len(rs) != 1 || rs[0] != All {
+		return nil
+	}
+	// The first group; installing is a no-op if it has been done before.
+	state, err := encodeState(storeState{Owned: Ranges{All}})
+	if err != nil {
+		return err
+	}
+	return c.callStore(ctx, name, cmdInstall, state, nil)
+}
+
+// Split splits the range holding key in two, the second starting at key.
+// Both are still owned by the same group.
+func (c *Client) Split(ctx context.Context, at string) error {
+	return c.meta.Call(ctx, cmdSplit, at, nil)
+}
+
+// Migrate moves rng, which must be a range of the map, to the named group.
+// The old group freezes the range, its entries are loaded into the new group
+// a chunk at a time, the new group installs the range, the map is updated,
+// and the old group drops the range. If Migrate fails before the map has
+// been updated, the move is undone, and Migrate can be called again. If it
+// fails after that, the old group is left with the range frozen; call Drop
+// to delete it.
+func (c *Client) Migrate(ctx context.Context, rng Range, to string) error {
+	m, err := c.Map(ctx)
+	if err != nil {
+		return err
+	}
+	var from string
+	for _, a := range m.Assignments {
+		if a.Range == rng {
+			from = a.Group
+		}
+	}
+	switch from {
+	case "":
+		return fmt.Errorf("shard: range %v is not in the map; split it first", rng)
+	case to:
+		return nil
+	}
+	if _, found := m.Groups[to]; !found {
+		return fmt.Errorf("shard: unknown group %q", to)
+	}
+
+	state, err := encodeState(storeState{Owned: Ranges{rng}})
+	if err != nil {
+		return err
+	}
+	if err := c.callStore(ctx, from, cmdFreeze, rng, nil); err != nil {
+		return c.rollback(err, from, to, rng)
+	}
+	if err := c.copyRange(ctx, from, to, rng); err != nil {
+		return c.rollback(err, from, to, rng)
+	}
+	if err := c.callStore(ctx, to, cmdInstall, state, nil); err != nil {
+		return c.rollback(err, from, to, rng)
+	}
+	if err := c.meta.Call(ctx, cmdAssign, Assignment{rng, to}, nil); err != nil {
+		return c.rollback(err, from, to, rng)
+	}
+	return c.Drop(ctx, from, rng)
+}
+
+// copyRange loads the entries of rng, frozen at the group from, into the
+// group to, a chunk at a time.
+func (c *Client) copyRange(ctx context.Context, from, to string, rng Range) error {
+	args := exportArgs{Range: rng}
+	for {
+		var ch chunk
+		if err := c.callStore(ctx, from, cmdExport, args, &ch); err != nil {
+			return err
+		}
+		if err := c.callStore(ctx, to, cmdLoad, loadArgs{rng, ch.Data}, nil); err != nil {
+			return err
+		}
+		if ch.Done {
+			return nil
+		}
+		args.From = ch.Next
+	}
+}
+
+// rollback undoes a Migrate of rng that failed with err: the new group drops
+// the range, and the old group serves it again. The range is left frozen if
+// the map cannot be fetched, or assigns rng to another group than from,
+// since the move may have been completed; the error returned then says so.
+func (c *Client) rollback(err error, from, to string, rng Range) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	frozen := func(reason interface{}) error {
+		return fmt.Errorf("%v; range %v left frozen at %q: %v", err, rng, from, reason)
+	}
+	m, merr := c.Map(ctx)
+	if merr != nil {
+		return frozen(merr)
+	}
+	if a, _ := m.Lookup(rng.Start); a.Group != from {
+		return frozen(fmt.Sprintf("assigned to %q", a.Group))
+	}
+	// Fails unless the range has been installed
+	c.callStore(ctx, to, cmdFreeze, rng, nil)
+	if derr := c.Drop(ctx, to, rng); derr != nil {
+		return frozen(derr)
+	}
+	if uerr := c.callStore(ctx, from, cmdUnfreeze, rng, nil); uerr != nil {
+		return frozen(uerr)
+	}
+	return err
+}
+
+// Drop deletes a frozen range from the named group.
+func (c *Client) Drop(ctx context.Context, group string, rng Range) error {
+	return c.callStore(ctx, group, cmdDrop, rng, nil)
+}
+
+// callStore calls a command of the Store of the named group.
+func (c *Client) callStore(ctx context.Context, group string, cmd router.Cmd, arg, result interface{}) error {
+	gc, err := c.group(group)
+	if err != nil {
+		return err
+	}
+	stub := c.admin.Stub(func(ctx context.Context, req []byte) ([]byte, error) {
+		return gc.Do(ctx, append([]byte{adminTag}, req...))
+	})
+	return stub.Call(ctx, cmd, arg, result)
+}
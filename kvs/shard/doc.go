@@ -0,0 +1,32 @@
+/*
+Package shard spreads the key-value store over several Goxos groups, one
+per shard, so that its throughput is not limited by a single leader.
+
+A partition map assigns ranges of keys to groups. The map is itself
+replicated, by a group running a MapService. Every shard group runs a Store,
+which holds the entries of the ranges it owns and rejects requests for other
+keys. A Client fetches the map from the map group, sends each request to the
+group owning its key, and fetches the map again when a group rejects a
+request because the range has moved.
+
+Ranges are split in the map only, and are moved between groups by
+Migrate: the old group freezes the range, its entries are copied to the new
+group in chunks of bounded size, the new group installs the range, the map
+is updated, and the old group drops the range. Requests for a frozen range
+are retried until the move is complete. A move that fails before the map is
+updated is undone: the new group drops the entries it has loaded, and the
+old group serves the range again.
+
+The groups may run on the same hosts, each with ports of its own. A new
+group is added to the map with AddGroup; the first group added owns every
+key.
+
+Transact executes a batch of map requests atomically, with two-phase commit
+in which every participant is a group. Each group owning keys of the batch
+prepares its requests and locks their keys, and the group owning the first
+key records whether the transaction commits. Since these steps are
+commands of the groups, they survive the crash of replicas; if the Client
+running a transaction stops, the Clients meeting its locks abort it, or
+commit it if that was decided.
+*/
+package shard
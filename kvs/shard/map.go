@@ -0,0 +1,176 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/relab/goxos/app/router"
+)
+
+// An Assignment assigns a range of keys to a group.
+type Assignment struct {
+	Range
+	Group string
+}
+
+// A Map is the partition map. Its assignments are sorted, and cover the
+// key space once a group has been added. Groups holds the nodes of every
+// group, in the format of the nodes setting. Version is incremented by
+// every change.
+type Map struct {
+	Version     uint64
+	Assignments []Assignment
+	Groups      map[string]string
+}
+
+// Lookup returns the assignment of the range holding key.
+func (m *Map) Lookup(key string) (Assignment, bool) {
+	i := sort.Search(len(m.Assignments), func(i int) bool {
+		return m.Assignments[i].Start > key
+	})
+	if i == 0 {
+		return Assignment{}, false
+	}
+	return m.Assignments[i-1], m.Assignments[i-1].Contains(key)
+}
+
+// Of returns the ranges assigned to group.
+func (m *Map) Of(group string) Ranges {
+	var rs Ranges
+	for _, a := range m.Assignments {
+		if a.Group == group {
+			rs = rs.Add(a.Range)
+		}
+	}
+	return rs
+}
+
+func (m *Map) addGroup(name, nodes string) error {
+	if name == "" {
+		return errors.New("group name is empty")
+	}
+	if old, found := m.Groups[name]; found {
+		if old == nodes {
+			return nil
+		}
+		return fmt.Errorf("group %q already has other nodes", name)
+	}
+	if m.Groups == nil {
+		m.Groups = make(map[string]string)
+	}
+	m.Groups[name] = nodes
+	if len(m.Assignments) == 0 {
+		m.Assignments = []Assignment{{All, name}}
+	}
+	m.Version++
+	return nil
+}
+
+func (m *Map) split(at string) error {
+	a, found := m.Lookup(at)
+	if !found {
+		return errors.New("no group has been added")
+	}
+	if a.Start == at {
+		return nil
+	}
+	i := sort.Search(len(m.Assignments), func(i int) bool {
+		return m.Assignments[i].Start >= a.Start
+	})
+	m.Assignments = append(m.Assignments, Assignment{})
+	copy(m.Assignments[i+2:], m.Assignments[i+1:])
+	m.Assignments[i].End = at
+	m.Assignments[i+1] = Assignment{Range{at, a.End}, a.Group}
+	m.Version++
+	return nil
+}
+
+func (m *Map) assign(a Assignment) error {
+	if _, found := m.Groups[a.Group]; !found {
+		return fmt.Errorf("unknown group %q", a.Group)
+	}
+	for i := range m.Assignments {
+		if m.Assignments[i].Range != a.Range {
+			continue
+		}
+		if m.Assignments[i].Group != a.Group {
+			m.Assignments[i].Group = a.Group
+			m.Version++
+		}
+		return nil
+	}
+	return fmt.Errorf("range %v is not in the map; split it first", a.Range)
+}
+
+// Names of the commands of a MapService.
+var (
+	cmdMap      = router.Name("map")
+	cmdAddGroup = router.Name("addGroup")
+	cmdSplit    = router.Name("split")
+	cmdAssign   = router.Name("assign")
+)
+
+// An addGroupArgs holds the arguments of the addGroup command.
+type addGroupArgs struct {
+	Name  string
+	Nodes string
+}
+
+// mapCommands registers the commands of a MapService with r. The handlers
+// are nil for a client, which only needs their signatures.
+func mapCommands(r *router.Router, m *Map) *router.Router {
+	register := r.Handle
+	if m == nil {
+		register = r.Declare
+		m = new(Map)
+	}
+	register(cmdMap, func(known uint64) (Map, error) {
+		return *m, nil
+	})
+	register(cmdAddGroup, func(args addGroupArgs) (uint64, error) {
+		err := m.addGroup(args.Name, args.Nodes)
+		return m.Version, err
+	})
+	register(cmdSplit, func(at string) (uint64, error) {
+		err := m.split(at)
+		return m.Version, err
+	})
+	register(cmdAssign, func(a Assignment) (uint64, error) {
+		err := m.assign(a)
+		return m.Version, err
+	})
+	return r
+}
+
+// A MapService is the application of the group replicating the partition
+// map.
+type MapService struct {
+	*router.Router
+	m *Map
+}
+
+// NewMapService returns a MapService with an empty map.
+func NewMapService() *MapService {
+	m := new(Map)
+	return &MapService{Router: mapCommands(router.New(router.GobCodec{}), m), m: m}
+}
+
+func (ms *MapService) GetState(slotMarker uint) (uint, []byte) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ms.m); err != nil {
+		panic(fmt.Sprintf("shard: encoding map: %v", err))
+	}
+	return slotMarker, buf.Bytes()
+}
+
+func (ms *MapService) SetState(state []byte) error {
+	var m Map
+	if err := gob.NewDecoder(bytes.NewReader(state)).Decode(&m); err != nil {
+		return err
+	}
+	*ms.m = m
+	return nil
+}
@@ -0,0 +1,116 @@
+package shard
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Range is the keys from Start up to, but not including, End. An empty
+// End is the end of the key space.
+type Range struct {
+	Start string
+	End   string
+}
+
+// All is the whole key space.
+var All = Range{}
+
+// Contains returns true if key is in r.
+func (r Range) Contains(key string) bool {
+	return key >= r.Start && (r.End == "" || key < r.End)
+}
+
+func (r Range) String() string {
+	if r.End == "" {
+		return fmt.Sprintf("[%q, end)", r.Start)
+	}
+	return fmt.Sprintf("[%q, %q)", r.Start, r.End)
+}
+
+func (r Range) empty() bool {
+	return r.End != "" && r.End <= r.Start
+}
+
+// endLess returns true if the end a comes before the end b.
+func endLess(a, b string) bool {
+	return a != "" && (b == "" || a < b)
+}
+
+// Ranges is a set of keys, held as sorted, disjoint and non-adjacent
+// ranges.
+type Ranges []Range
+
+// Has returns true if key is in rs.
+func (rs Ranges) Has(key string) bool {
+	for _, r := range rs {
+		if r.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns true if every key of r is in rs.
+func (rs Ranges) Covers(r Range) bool {
+	for _, x := range rs {
+		if x.Start <= r.Start && !endLess(x.End, r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps returns true if some key of r is in rs.
+func (rs Ranges) Overlaps(r Range) bool {
+	for _, x := range rs {
+		if (x.End == "" || r.Start < x.End) && (r.End == "" || x.Start < r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add returns rs with the keys of r added.
+func (rs Ranges) Add(r Range) Ranges {
+	if r.empty() {
+		return rs
+	}
+	all := append(append(Ranges(nil), rs...), r)
+	sort.Slice(all, func(i, j int) bool { return all[i].Start < all[j].Start })
+	merged := all[:1]
+	for _, x := range all[1:] {
+		last := &merged[len(merged)-1]
+		if last.End != "" && x.Start > last.End {
+			merged = append(merged, x)
+			continue
+		}
+		if endLess(last.End, x.End) {
+			last.End = x.End
+		}
+	}
+	return merged
+}
+
+// Remove returns rs without the keys of r.
+func (rs Ranges) Remove(r Range) Ranges {
+	var left Ranges
+	for _, x := range rs {
+		// The part of x before r
+		if x.Start < r.Start {
+			before := Range{x.Start, r.Start}
+			if endLess(x.End, r.Start) {
+				before.End = x.End
+			}
+			left = append(left, before)
+		}
+		// The part of x after r
+		if endLess(r.End, x.End) {
+			after := Range{r.End, x.End}
+			if after.Start < x.Start {
+				after.Start = x.Start
+			}
+			left = append(left, after)
+		}
+	}
+	return left
+}
@@ -0,0 +1,211 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/app/router"
+	kc "github.com/relab/goxos/kvs/common"
+)
+
+func TestRanges(t *testing.T) {
+	var rs Ranges
+	rs = rs.Add(Range{"b", "d"}).Add(Range{"f", ""}).Add(Range{"d", "e"})
+	if want := (Ranges{{"b", "e"}, {"f", ""}}); !reflect.DeepEqual(rs, want) {
+		t.Fatalf("added ranges are %v, want %v", rs, want)
+	}
+	if !rs.Covers(Range{"c", "e"}) || rs.Covers(Range{"c", "g"}) || !rs.Covers(Range{"x", ""}) {
+		t.Errorf("%v covers the wrong ranges", rs)
+	}
+	if !rs.Overlaps(Range{"a", "c"}) || rs.Overlaps(Range{"e", "f"}) || !rs.Overlaps(Range{"", ""}) {
+		t.Errorf("%v overlaps the wrong ranges", rs)
+	}
+	rs = rs.Remove(Range{"c", "g"})
+	if want := (Ranges{{"b", "c"}, {"g", ""}}); !reflect.DeepEqual(rs, want) {
+		t.Fatalf("ranges after removal are %v, want %v", rs, want)
+	}
+	if rs.Has("c") || !rs.Has("b") || !rs.Has("zzz") {
+		t.Errorf("%v has the wrong keys", rs)
+	}
+}
+
+func TestMap(t *testing.T) {
+	var m Map
+	if err := m.split("k"); err == nil {
+		t.Error("split an empty map")
+	}
+	m.addGroup("a", "nodes-a")
+	m.addGroup("b", "nodes-b")
+	m.split("k")
+	m.split("t")
+	if err := m.assign(Assignment{Range{"k", "t"}, "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.assign(Assignment{Range{"k", ""}, "b"}); err == nil {
+		t.Error("assigned a range that is not in the map")
+	}
+	for key, group := range map[string]string{"": "a", "j": "a", "k": "b", "s": "b", "t": "a"} {
+		if a, found := m.Lookup(key); !found || a.Group != group {
+			t.Errorf("key %q is assigned to %q, want %q", key, a.Group, group)
+		}
+	}
+	if want := (Ranges{{"", "k"}, {"t", ""}}); !reflect.DeepEqual(m.Of("a"), want) {
+		t.Errorf("group a has %v, want %v", m.Of("a"), want)
+	}
+}
+
+// stub returns a stub for the commands of s moving ranges or taking part
+// in transactions.
+func stub(s *Store) *router.Stub {
+	return storeCommands(router.New(router.GobCodec{}), nil).Stub(func(_ context.Context, req []byte) ([]byte, error) {
+		return s.ExecuteWithContext(app.ExecCtx{Time: time.Now()}, append([]byte{adminTag}, req...)), nil
+	})
+}
+
+func do(s *Store, ct kc.CommandType, key, value string) kc.MapResponse {
+	var buf bytes.Buffer
+	req := kc.MapRequest{Ct: ct, Key: []byte(key), Value: []byte(value)}
+	req.Marshal(&buf)
+	var resp kc.MapResponse
+	resp.Unmarshal(bytes.NewReader(s.Execute(buf.Bytes())))
+	return resp
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	from, to := NewStore(), NewStore()
+	all, _ := encodeState(storeState{Owned: Ranges{All}})
+	if err := stub(from).Call(ctx, cmdInstall, all, nil); err != nil {
+		t.Fatal(err)
+	}
+	do(from, kc.Write, "a", "1")
+	big := string(make([]byte, exportChunkSize/2))
+	for _, k := range []string{"m", "n", "o"} {
+		do(from, kc.Write, k, big)
+	}
+
+	rng := Range{"k", ""}
+	if err := stub(from).Call(ctx, cmdExport, exportArgs{Range: rng}, nil); err == nil {
+		t.Error("exported a range that is not frozen")
+	}
+	if err := stub(from).Call(ctx, cmdFreeze, rng, nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp := do(from, kc.Write, "m", "3"); string(resp.Err) != ErrRangeMoving {
+		t.Errorf("write to frozen range returned %v", resp)
+	}
+
+	// Loaded entries are not served, and are dropped if the move fails
+	copyRange := func() (chunks int) {
+		args := exportArgs{Range: rng}
+		for done := false; !done; chunks++ {
+			var ch chunk
+			if err := stub(from).Call(ctx, cmdExport, args, &ch); err != nil {
+				t.Fatal(err)
+			}
+			if err := stub(to).Call(ctx, cmdLoad, loadArgs{rng, ch.Data}, nil); err != nil {
+				t.Fatal(err)
+			}
+			args.From, done = ch.Next, ch.Done
+		}
+		return chunks
+	}
+	if chunks := copyRange(); chunks != 2 {
+		t.Errorf("range exported in %d chunks, want 2", chunks)
+	}
+	if resp := do(to, kc.Read, "m", ""); string(resp.Err) != ErrWrongGroup {
+		t.Errorf("read before install returned %v", resp)
+	}
+	stub(to).Call(ctx, cmdDrop, rng, nil)
+	stub(from).Call(ctx, cmdUnfreeze, rng, nil)
+	if len(to.Data) != 0 || len(to.Loading) != 0 {
+		t.Errorf("new group holds %d entries of %v after rollback", len(to.Data), to.Loading)
+	}
+	if resp := do(from, kc.Write, "m", "2"); len(resp.Err) != 0 {
+		t.Errorf("write after rollback returned %v", resp)
+	}
+
+	stub(from).Call(ctx, cmdFreeze, rng, nil)
+	copyRange()
+	state, _ := encodeState(storeState{Owned: Ranges{rng}})
+	for i := 0; i < 2; i++ {
+		if err := stub(to).Call(ctx, cmdInstall, state, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := stub(from).Call(ctx, cmdDrop, rng, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if resp := do(to, kc.Read, "m", ""); len(resp.Err) != 0 || string(resp.Value) != "2" {
+		t.Errorf("read after migration returned %v", resp)
+	}
+	if resp := do(from, kc.Read, "m", ""); string(resp.Err) != ErrWrongGroup {
+		t.Errorf("read from old group returned %v", resp)
+	}
+	if resp := do(from, kc.Read, "a", ""); len(resp.Err) != 0 || string(resp.Value) != "1" {
+		t.Errorf("read of key not moved returned %v", resp)
+	}
+	if len(from.Data) != 1 || len(to.Data) != 3 || len(to.Loading) != 0 {
+		t.Errorf("groups hold %d and %d entries, want 1 and 3", len(from.Data), len(to.Data))
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+	all, _ := encodeState(storeState{Owned: Ranges{All}})
+	stub(s).Call(ctx, cmdInstall, all, nil)
+	do(s, kc.Write, "a", "1")
+
+	rec := txRecord{ID: "t1", Coordinator: "g", Participants: []string{"g"}, Reqs: []kc.MapRequest{
+		{Ct: kc.Read, Key: []byte("a")},
+		{Ct: kc.Write, Key: []byte("a"), Value: []byte("2")},
+		{Ct: kc.Read, Key: []byte("a")},
+		{Ct: kc.Delete, Key: []byte("b")},
+		{Ct: kc.Read, Key: []byte("b")},
+	}}
+	var resps []kc.MapResponse
+	if err := stub(s).Call(ctx, cmdPrepare, rec, &resps); err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 5 || string(resps[0].Value) != "1" || string(resps[2].Value) != "2" || resps[4].Found != 0 {
+		t.Errorf("prepare returned %v", resps)
+	}
+	if resp := do(s, kc.Read, "a", ""); string(resp.Err) != ErrKeyLocked+"t1" {
+		t.Errorf("read of locked key returned %v", resp)
+	}
+	other := txRecord{ID: "t2", Reqs: []kc.MapRequest{{Ct: kc.Write, Key: []byte("b")}}}
+	err := stub(s).Call(ctx, cmdPrepare, other, nil)
+	if cerr, ok := err.(*router.CommandError); !ok || cerr.Msg != ErrKeyLocked+"t1" {
+		t.Errorf("prepare of conflicting transaction returned %v", err)
+	}
+
+	var commit bool
+	for _, c := range []bool{true, false} {
+		if err := stub(s).Call(ctx, cmdDecide, decideArgs{"t1", c}, &commit); err != nil || !commit {
+			t.Errorf("decide(%v) returned %v, %v; want the first decision", c, commit, err)
+		}
+	}
+	if err := stub(s).Call(ctx, cmdCommit, "t1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp := do(s, kc.Read, "a", ""); len(resp.Err) != 0 || string(resp.Value) != "2" {
+		t.Errorf("read after commit returned %v", resp)
+	}
+
+	// A transaction aborted before it is prepared stays aborted.
+	if err := stub(s).Call(ctx, cmdAbort, "t2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub(s).Call(ctx, cmdPrepare, other, nil); err == nil {
+		t.Error("prepared an aborted transaction")
+	}
+	if len(s.Tx.Locks) != 0 || len(s.Tx.Prepared) != 0 {
+		t.Errorf("locks %v and prepared transactions %v left", s.Tx.Locks, s.Tx.Prepared)
+	}
+}
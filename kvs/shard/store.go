@@ -0,0 +1,399 @@
+package shard
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/app/router"
+	kc "github.com/relab/goxos/kvs/common"
+)
+
+// Errors of the responses to requests for keys a Store does not serve.
+const (
+	ErrWrongGroup  = "shard: key is not owned by this group"
+	ErrRangeMoving = "shard: key range is being moved to another group"
+	ErrKeyLocked   = "shard: key is locked by transaction " // Followed by its id
+)
+
+// Requests to a Store starting with adminTag are commands moving ranges or
+// taking part in transactions. Other requests are map requests, which start
+// with their command type.
+const adminTag byte = 0xff
+
+// rangesKey stands for the ranges of a Store in the keys of its commands.
+// Map requests read it, and other commands write it, so that ranges are not
+// moved and keys are not locked while map requests are executed.
+const rangesKey = "\x00ranges"
+
+// Names of the commands of a Store that move ranges.
+var (
+	cmdFreeze   = router.Name("freeze")
+	cmdUnfreeze = router.Name("unfreeze")
+	cmdExport   = router.Name("export")
+	cmdLoad     = router.Name("load")
+	cmdInstall  = router.Name("install")
+	cmdDrop     = router.Name("drop")
+)
+
+// The most bytes of keys and values an export returns, so that moving a
+// range takes commands well below the request size limit.
+const exportChunkSize = 256 << 10
+
+// exportArgs names a frozen range, and the first of its keys to export.
+type exportArgs struct {
+	Range Range
+	From  string
+}
+
+// A chunk holds entries of a range being moved, with the keys from the
+// start of the export up to, but not including, Next.
+type chunk struct {
+	Data map[string][]byte
+	Next string
+	Done bool // No keys are left from Next
+}
+
+// loadArgs holds entries of a range being moved to a Store.
+type loadArgs struct {
+	Range Range
+	Data  map[string][]byte
+}
+
+// storeState is the state of a Store. The entries of the ranges being
+// loaded are held in Data, but are not served before the ranges are
+// installed.
+type storeState struct {
+	Owned   Ranges
+	Frozen  Ranges
+	Loading Ranges
+	Data    map[string][]byte
+	Tx      txState
+}
+
+// A Store is the application of a shard group: a key-value map holding the
+// keys of the ranges the group owns. Map requests for other keys, and for
+// keys of ranges being moved, are answered with ErrWrongGroup and
+// ErrRangeMoving. A Store also takes part in transactions; see Client.Transact.
+type Store struct {
+	admin *router.Router
+	mu    sync.RWMutex // Guards data; the ranges only change in admin commands
+	storeState
+}
+
+// NewStore returns a Store owning no keys.
+func NewStore() *Store {
+	s := &Store{storeState: storeState{Data: make(map[string][]byte), Tx: newTxState()}}
+	s.admin = storeCommands(router.New(router.GobCodec{}), s)
+	return s
+}
+
+// storeCommands registers the commands of a Store that move ranges or take
+// part in transactions with r.
+// The handlers are nil for a client, which only needs their signatures.
+func storeCommands(r *router.Router, s *Store) *router.Router {
+	register := r.Handle
+	if s == nil {
+		register = r.Declare
+	}
+	register(cmdFreeze, func(rng Range) (bool, error) {
+		return s.freeze(rng)
+	})
+	register(cmdUnfreeze, func(rng Range) (bool, error) {
+		return s.unfreeze(rng)
+	})
+	register(cmdExport, func(args exportArgs) (chunk, error) {
+		return s.export(args)
+	})
+	register(cmdLoad, func(args loadArgs) (bool, error) {
+		return s.load(args)
+	})
+	register(cmdInstall, func(state []byte) (bool, error) {
+		return s.install(state)
+	})
+	register(cmdDrop, func(rng Range) (bool, error) {
+		return s.drop(rng)
+	})
+	return txCommands(r, s, register)
+}
+
+// freeze stops serving the keys of rng, so that they can be exported. It
+// returns false if rng is frozen already.
+func (s *Store) freeze(rng Range) (bool, error) {
+	if s.Frozen.Covers(rng) {
+		return false, nil
+	}
+	if !s.Owned.Covers(rng) {
+		return false, fmt.Errorf("range %v is not owned by this group", rng)
+	}
+	for k := range s.Tx.Locks {
+		if rng.Contains(k) {
+			return false, fmt.Errorf("range %v has prepared transactions", rng)
+		}
+	}
+	s.Frozen = s.Frozen.Add(rng)
+	return true, nil
+}
+
+// unfreeze serves the keys of rng again, after a move has been abandoned.
+// It returns false if rng is not frozen.
+func (s *Store) unfreeze(rng Range) (bool, error) {
+	if !s.Frozen.Covers(rng) {
+		return false, nil
+	}
+	s.Frozen = s.Frozen.Remove(rng)
+	return true, nil
+}
+
+// export returns the entries of a frozen range in key order, from
+// args.From, up to exportChunkSize bytes of them but at least one.
+func (s *Store) export(args exportArgs) (chunk, error) {
+	if !s.Frozen.Covers(args.Range) {
+		return chunk{}, fmt.Errorf("range %v is not frozen", args.Range)
+	}
+	var keys []string
+	for k := range s.Data {
+		if args.Range.Contains(k) && k >= args.From {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	ch := chunk{Data: make(map[string][]byte), Done: true}
+	size := 0
+	for i, k := range keys {
+		if size >= exportChunkSize {
+			ch.Next, ch.Done = keys[i], false
+			break
+		}
+		ch.Data[k] = s.Data[k]
+		size += len(k) + len(s.Data[k])
+	}
+	return ch, nil
+}
+
+// load adds entries of rng, which is being moved to this Store. They are
+// served once rng is installed. It returns false if rng has been installed.
+func (s *Store) load(args loadArgs) (bool, error) {
+	if s.Owned.Covers(args.Range) {
+		return false, nil
+	}
+	if s.Owned.Overlaps(args.Range) {
+		return false, fmt.Errorf("range %v overlaps the ranges of this group", args.Range)
+	}
+	s.Loading = s.Loading.Add(args.Range)
+	s.mu.Lock()
+	for k, v := range args.Data {
+		if args.Range.Contains(k) {
+			s.Data[k] = v
+		}
+	}
+	s.mu.Unlock()
+	return true, nil
+}
+
+// install adds the ranges and entries of the state of another Store, and
+// serves the entries loaded for its ranges. It returns false if they have
+// been installed before.
+func (s *Store) install(state []byte) (bool, error) {
+	moved, err := decodeState(state)
+	if err != nil {
+		return false, err
+	}
+	for _, rng := range moved.Owned {
+		if s.Owned.Covers(rng) {
+			return false, nil
+		}
+		if s.Owned.Overlaps(rng) {
+			return false, fmt.Errorf("range %v overlaps the ranges of this group", rng)
+		}
+	}
+	for _, rng := range moved.Owned {
+		s.Owned, s.Loading = s.Owned.Add(rng), s.Loading.Remove(rng)
+	}
+	s.mu.Lock()
+	for k, v := range moved.Data {
+		s.Data[k] = v
+	}
+	s.mu.Unlock()
+	return true, nil
+}
+
+// drop deletes a frozen range, or the entries loaded for a range. It returns
+// false if the range has been dropped before.
+func (s *Store) drop(rng Range) (bool, error) {
+	if s.Loading.Overlaps(rng) {
+		s.Loading = s.Loading.Remove(rng)
+		s.deleteRange(rng)
+		return true, nil
+	}
+	if !s.Owned.Overlaps(rng) {
+		return false, nil
+	}
+	if !s.Frozen.Covers(rng) {
+		return false, fmt.Errorf("range %v is not frozen", rng)
+	}
+	s.Owned, s.Frozen = s.Owned.Remove(rng), s.Frozen.Remove(rng)
+	s.deleteRange(rng)
+	return true, nil
+}
+
+func (s *Store) deleteRange(rng Range) {
+	s.mu.Lock()
+	for k := range s.Data {
+		if rng.Contains(k) {
+			delete(s.Data, k)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Execute executes a map request, or a command moving ranges or taking
+// part in a transaction, without an execution context.
+func (s *Store) Execute(req []byte) []byte {
+	return s.ExecuteWithContext(app.ExecCtx{}, req)
+}
+
+// ExecuteWithContext executes a map request, or a command moving ranges or
+// taking part in a transaction. The time of ctx is when a transaction
+// finished.
+func (s *Store) ExecuteWithContext(ctx app.ExecCtx, req []byte) []byte {
+	if len(req) > 0 && req[0] == adminTag {
+		return s.admin.ExecuteWithContext(ctx, req[1:])
+	}
+	var kvreq kc.MapRequest
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil {
+		return marshalResp(kc.MapResponse{Err: []byte("I can't decode you request")})
+	}
+	if fail := s.check(kvreq); fail != nil {
+		return fail
+	}
+
+	var kvresp kc.MapResponse
+	switch kvreq.Ct {
+	case kc.Read:
+		s.mu.RLock()
+		val, found := s.Data[string(kvreq.Key)]
+		s.mu.RUnlock()
+		kvresp = kc.MapResponse{Value: val, ToType: kc.Read}
+		if found {
+			kvresp.Found = 1
+		}
+	case kc.Write:
+		s.mu.Lock()
+		s.Data[string(kvreq.Key)] = kvreq.Value
+		s.mu.Unlock()
+		kvresp = kc.MapResponse{Value: kvreq.Value, ToType: kc.Write}
+	case kc.Delete:
+		s.mu.Lock()
+		delete(s.Data, string(kvreq.Key))
+		s.mu.Unlock()
+		kvresp = kc.MapResponse{ToType: kc.Delete}
+	default:
+		kvresp = kc.MapResponse{Err: []byte("Unkown map command")}
+	}
+	return marshalResp(kvresp)
+}
+
+// check returns the response to a request for a key the Store does not
+// serve, or nil.
+func (s *Store) check(kvreq kc.MapRequest) []byte {
+	key := string(kvreq.Key)
+	switch {
+	case !s.Owned.Has(key):
+		return marshalResp(kc.MapResponse{ToType: kvreq.Ct, Err: []byte(ErrWrongGroup)})
+	case s.Frozen.Has(key):
+		return marshalResp(kc.MapResponse{ToType: kvreq.Ct, Err: []byte(ErrRangeMoving)})
+	}
+	if id, locked := s.Tx.Locks[key]; locked {
+		return marshalResp(kc.MapResponse{ToType: kvreq.Ct, Err: []byte(ErrKeyLocked + id)})
+	}
+	return nil
+}
+
+// Keys returns the key a map request reads or writes, together with
+// rangesKey.
+func (s *Store) Keys(req []byte) (reads, writes []string) {
+	if len(req) > 0 && req[0] == adminTag {
+		return nil, []string{rangesKey}
+	}
+	var kvreq kc.MapRequest
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil {
+		return nil, nil
+	}
+	switch kvreq.Ct {
+	case kc.Read:
+		return []string{rangesKey, string(kvreq.Key)}, nil
+	case kc.Write, kc.Delete:
+		return []string{rangesKey}, []string{string(kvreq.Key)}
+	}
+	return nil, nil
+}
+
+// Read answers read requests from the current map.
+func (s *Store) Read(req []byte) []byte {
+	var kvreq kc.MapRequest
+	if err := kvreq.Unmarshal(bytes.NewReader(req)); err != nil || kvreq.Ct != kc.Read {
+		return marshalResp(kc.MapResponse{Err: []byte("Only read requests can be served without agreement")})
+	}
+	return s.Execute(req)
+}
+
+func (s *Store) GetState(slotMarker uint) (uint, []byte) {
+	state, err := encodeState(s.storeState)
+	if err != nil {
+		panic(fmt.Sprintf("shard: encoding state: %v", err))
+	}
+	return slotMarker, state
+}
+
+func (s *Store) SetState(state []byte) error {
+	st, err := decodeState(state)
+	if err != nil {
+		return err
+	}
+	s.storeState = st
+	return nil
+}
+
+// StateHash returns a hash of the ranges and entries of the Store.
+func (s *Store) StateHash() []byte {
+	keys := make([]string, 0, len(s.Data))
+	for k := range s.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hasher := sha1.New()
+	fmt.Fprint(hasher, s.Owned, s.Frozen, s.Loading)
+	s.hashTxState(hasher)
+	for _, k := range keys {
+		fmt.Fprintf(hasher, "%d:%s%d:", len(k), k, len(s.Data[k]))
+		hasher.Write(s.Data[k])
+	}
+	return hasher.Sum(nil)
+}
+
+func encodeState(st storeState) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(st)
+	return buf.Bytes(), err
+}
+
+func decodeState(state []byte) (storeState, error) {
+	var st storeState
+	err := gob.NewDecoder(bytes.NewReader(state)).Decode(&st)
+	if st.Data == nil {
+		st.Data = make(map[string][]byte)
+	}
+	st.Tx.init()
+	return st, err
+}
+
+func marshalResp(kvresp kc.MapResponse) []byte {
+	var buf bytes.Buffer
+	kvresp.Marshal(&buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,421 @@
+package shard
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/app/router"
+	kc "github.com/relab/goxos/kvs/common"
+)
+
+// How long a participant remembers the transactions it has finished, so
+// that a prepare executed after the abort of its transaction is refused.
+const txRetention = 10 * time.Minute
+
+// How long a Client waits for a transaction holding a lock before deciding
+// that its coordinator has stalled and aborting it.
+const lockTimeout = 2 * time.Second
+
+// Names of the commands of a Store taking part in transactions.
+var (
+	cmdPrepare = router.Name("prepare")
+	cmdCommit  = router.Name("commit")
+	cmdAbort   = router.Name("abort")
+	cmdTxInfo  = router.Name("txInfo")
+	cmdDecide  = router.Name("decide")
+	cmdForget  = router.Name("forget")
+)
+
+// A txRecord is a transaction prepared by a participant, holding the
+// requests for its keys and the responses to them.
+type txRecord struct {
+	ID           string
+	Coordinator  string // Group recording the decision
+	Participants []string
+	Reqs         []kc.MapRequest
+	Resps        []kc.MapResponse
+}
+
+// A decideArgs holds the arguments of the decide command.
+type decideArgs struct {
+	ID     string
+	Commit bool
+}
+
+// txState is the part of the state of a Store kept for transactions.
+type txState struct {
+	Prepared  map[string]*txRecord
+	Locks     map[string]string // Key → id of the transaction holding it
+	Finished  map[string]int64  // Id → when it committed or aborted
+	Decisions map[string]bool   // Transactions coordinated by this group
+}
+
+func newTxState() txState {
+	var st txState
+	st.init()
+	return st
+}
+
+// init makes the maps that are nil after decoding.
+func (st *txState) init() {
+	if st.Prepared == nil {
+		st.Prepared = make(map[string]*txRecord)
+	}
+	if st.Locks == nil {
+		st.Locks = make(map[string]string)
+	}
+	if st.Finished == nil {
+		st.Finished = make(map[string]int64)
+	}
+	if st.Decisions == nil {
+		st.Decisions = make(map[string]bool)
+	}
+}
+
+// txCommands registers the commands of a Store taking part in transactions.
+func txCommands(r *router.Router, s *Store, register func(router.Cmd, interface{})) *router.Router {
+	register(cmdPrepare, func(rec txRecord) ([]kc.MapResponse, error) {
+		return s.prepare(rec)
+	})
+	register(cmdCommit, func(ctx app.ExecCtx, id string) (bool, error) {
+		return s.commit(ctx, id), nil
+	})
+	register(cmdAbort, func(ctx app.ExecCtx, id string) (bool, error) {
+		return s.abort(ctx, id), nil
+	})
+	register(cmdTxInfo, func(id string) (txRecord, error) {
+		rec, found := s.Tx.Prepared[id]
+		if !found {
+			return txRecord{}, fmt.Errorf("transaction %s is not prepared", id)
+		}
+		return *rec, nil
+	})
+	register(cmdDecide, func(args decideArgs) (bool, error) {
+		if commit, found := s.Tx.Decisions[args.ID]; found {
+			return commit, nil
+		}
+		s.Tx.Decisions[args.ID] = args.Commit
+		return args.Commit, nil
+	})
+	register(cmdForget, func(id string) (bool, error) {
+		_, found := s.Tx.Decisions[id]
+		delete(s.Tx.Decisions, id)
+		return found, nil
+	})
+	return r
+}
+
+// prepare locks the keys of the requests of rec, and returns the responses
+// to them as if they were executed in order. It fails with the reason for
+// aborting the transaction, or with ErrWrongGroup or ErrRangeMoving.
+func (s *Store) prepare(rec txRecord) ([]kc.MapResponse, error) {
+	if prepared, found := s.Tx.Prepared[rec.ID]; found {
+		return prepared.Resps, nil
+	}
+	if _, found := s.Tx.Finished[rec.ID]; found {
+		return nil, errors.New(kc.AbortRecovered)
+	}
+	for _, req := range rec.Reqs {
+		key := string(req.Key)
+		switch {
+		case !s.Owned.Has(key):
+			return nil, errors.New(ErrWrongGroup)
+		case s.Frozen.Has(key):
+			return nil, errors.New(ErrRangeMoving)
+		}
+		if id, locked := s.Tx.Locks[key]; locked {
+			return nil, errors.New(ErrKeyLocked + id)
+		}
+	}
+
+	// Values written by earlier requests of the transaction
+	type entry struct {
+		val   []byte
+		found bool
+	}
+	written := make(map[string]entry)
+	rec.Resps = make([]kc.MapResponse, len(rec.Reqs))
+	for i, req := range rec.Reqs {
+		key := string(req.Key)
+		switch req.Ct {
+		case kc.Read:
+			e, found := written[key]
+			if !found {
+				e.val, e.found = s.Data[key]
+			}
+			rec.Resps[i] = kc.MapResponse{ToType: kc.Read, Value: e.val}
+			if e.found {
+				rec.Resps[i].Found = 1
+			}
+		case kc.Write:
+			written[key] = entry{req.Value, true}
+			rec.Resps[i] = kc.MapResponse{ToType: kc.Write, Value: req.Value}
+		case kc.Delete:
+			written[key] = entry{}
+			rec.Resps[i] = kc.MapResponse{ToType: kc.Delete}
+		default:
+			rec.Resps[i] = kc.MapResponse{Err: []byte("Unkown map command")}
+		}
+		s.Tx.Locks[key] = rec.ID
+	}
+	s.Tx.Prepared[rec.ID] = &rec
+	return rec.Resps, nil
+}
+
+// commit applies the writes of a prepared transaction and releases its
+// locks. It returns false if the transaction is not prepared.
+func (s *Store) commit(ctx app.ExecCtx, id string) bool {
+	rec, found := s.Tx.Prepared[id]
+	if found {
+		s.mu.Lock()
+		for _, req := range rec.Reqs {
+			switch req.Ct {
+			case kc.Write:
+				s.Data[string(req.Key)] = req.Value
+			case kc.Delete:
+				delete(s.Data, string(req.Key))
+			}
+		}
+		s.mu.Unlock()
+		s.release(rec)
+	}
+	s.finish(ctx, id)
+	return found
+}
+
+// abort releases the locks of a prepared transaction. It returns false if
+// the transaction is not prepared.
+func (s *Store) abort(ctx app.ExecCtx, id string) bool {
+	rec, found := s.Tx.Prepared[id]
+	if found {
+		s.release(rec)
+	}
+	s.finish(ctx, id)
+	return found
+}
+
+func (s *Store) release(rec *txRecord) {
+	for _, req := range rec.Reqs {
+		delete(s.Tx.Locks, string(req.Key))
+	}
+	delete(s.Tx.Prepared, rec.ID)
+}
+
+// finish remembers that id has finished, and forgets the transactions that
+// finished more than txRetention ago.
+func (s *Store) finish(ctx app.ExecCtx, id string) {
+	now := ctx.Time.UnixNano()
+	s.Tx.Finished[id] = now
+	for old, at := range s.Tx.Finished {
+		if now-at > int64(txRetention) {
+			delete(s.Tx.Finished, old)
+		}
+	}
+}
+
+// hashTxState writes the transaction state of s to w in a fixed order.
+func (s *Store) hashTxState(w io.Writer) {
+	ids := make([]string, 0, len(s.Tx.Prepared)+len(s.Tx.Decisions))
+	for id := range s.Tx.Prepared {
+		ids = append(ids, "p"+id)
+	}
+	for id, commit := range s.Tx.Decisions {
+		ids = append(ids, fmt.Sprint("d", commit, id))
+	}
+	sort.Strings(ids)
+	fmt.Fprint(w, ids)
+}
+
+// Transact executes the requests of tx atomically, with two-phase commit
+// over the groups owning their keys. Every participant group prepares its
+// requests, locking their keys, and the group owning the first key records
+// the decision to commit or abort. The prepared requests and the decision
+// are replicated by the groups, so if the Client stops halfway, another
+// Client meeting the locks aborts the transaction after lockTimeout, unless
+// it has been decided to commit, in which case it is committed.
+//
+// Transact aborts without retrying if a key is locked by another
+// transaction. If a range has moved, or is being moved, tx is retried once
+// the map has been fetched again, at most maxMovingRetries times. An error
+// is returned if the outcome is unknown.
+func (c *Client) Transact(ctx context.Context, tx kc.TxRequest) (kc.TxResponse, error) {
+	if len(tx.Reqs) == 0 {
+		return kc.TxResponse{Committed: true}, nil
+	}
+	for retries := 0; ; retries++ {
+		resp, moved, err := c.transact(ctx, tx)
+		if err != nil || !moved {
+			return resp, err
+		}
+		if retries == maxMovingRetries {
+			return kc.TxResponse{}, fmt.Errorf("shard: ranges of transaction still moving after %d retries", retries)
+		}
+		if err := backoff(ctx); err != nil {
+			return kc.TxResponse{}, err
+		}
+		if _, err := c.Map(ctx); err != nil {
+			return kc.TxResponse{}, err
+		}
+	}
+}
+
+// transact runs tx once. It returns true if tx was aborted because a range
+// has moved, or is being moved.
+func (c *Client) transact(ctx context.Context, tx kc.TxRequest) (kc.TxResponse, bool, error) {
+	id, err := newTxID()
+	if err != nil {
+		return kc.TxResponse{}, false, err
+	}
+	recs := make(map[string]*txRecord)
+	indices := make(map[string][]int)
+	var participants []string
+	for i, req := range tx.Reqs {
+		group, _, err := c.owner(ctx, string(req.Key))
+		if err != nil {
+			return kc.TxResponse{}, false, err
+		}
+		rec, found := recs[group]
+		if !found {
+			rec = &txRecord{ID: id}
+			recs[group] = rec
+			participants = append(participants, group)
+		}
+		rec.Reqs = append(rec.Reqs, req)
+		indices[group] = append(indices[group], i)
+	}
+	coordinator := participants[0]
+
+	type result struct {
+		group string
+		resps []kc.MapResponse
+		err   error
+	}
+	results := make(chan result, len(participants))
+	for _, group := range participants {
+		rec := recs[group]
+		rec.Coordinator, rec.Participants = coordinator, participants
+		go func(group string, rec txRecord) {
+			var resps []kc.MapResponse
+			err := c.callStore(ctx, group, cmdPrepare, rec, &resps)
+			results <- result{group, resps, err}
+		}(group, *rec)
+	}
+	commit, moved := true, false
+	var reason string
+	resps := make([]kc.MapResponse, len(tx.Reqs))
+	for range participants {
+		res := <-results
+		if res.err == nil {
+			for j, i := range indices[res.group] {
+				resps[i] = res.resps[j]
+			}
+			continue
+		}
+		commit = false
+		cerr, ok := res.err.(*router.CommandError)
+		switch {
+		case !ok:
+			reason = kc.AbortCanceled
+		case cerr.Msg == ErrWrongGroup || cerr.Msg == ErrRangeMoving:
+			moved = true
+		case strings.HasPrefix(cerr.Msg, ErrKeyLocked):
+			reason = kc.AbortConflict
+			if err := c.stalled(ctx, res.group, strings.TrimPrefix(cerr.Msg, ErrKeyLocked)); err != nil {
+				return kc.TxResponse{}, false, err
+			}
+		default:
+			reason = cerr.Msg
+		}
+	}
+
+	decided := commit
+	if err := c.callStore(ctx, coordinator, cmdDecide, decideArgs{id, commit}, &decided); err != nil {
+		// The locks taken are released by the Clients meeting them.
+		return kc.TxResponse{}, false, err
+	}
+	c.finish(ctx, id, coordinator, participants, decided)
+	if !decided {
+		if commit {
+			reason = kc.AbortRecovered
+		}
+		return kc.TxResponse{Reason: reason}, moved && reason == "", nil
+	}
+	return kc.TxResponse{Committed: true, Resps: resps}, false, nil
+}
+
+// finish commits or aborts a decided transaction at every participant, and
+// has the coordinator forget the decision. Failures are not returned: the
+// transaction is finished by the Clients meeting its locks.
+func (c *Client) finish(ctx context.Context, id, coordinator string, participants []string, commit bool) {
+	cmd := cmdAbort
+	if commit {
+		cmd = cmdCommit
+	}
+	for _, group := range participants {
+		if err := c.callStore(ctx, group, cmd, id, nil); err != nil {
+			return
+		}
+	}
+	c.callStore(ctx, coordinator, cmdForget, id, nil)
+}
+
+// stalled records that a key of the named group is locked by transaction
+// id, and recovers the transaction if it has held locks for lockTimeout.
+func (c *Client) stalled(ctx context.Context, group, id string) error {
+	now := time.Now()
+	c.mu.Lock()
+	since, seen := c.locked[id]
+	if !seen {
+		c.locked[id] = now
+		for other, at := range c.locked {
+			if now.Sub(at) > 10*lockTimeout {
+				delete(c.locked, other)
+			}
+		}
+	}
+	c.mu.Unlock()
+	if !seen || now.Sub(since) < lockTimeout {
+		return nil
+	}
+
+	err := c.recover(ctx, group, id)
+	c.mu.Lock()
+	delete(c.locked, id)
+	c.mu.Unlock()
+	return err
+}
+
+// recover finishes a transaction prepared by the named group. The
+// transaction is aborted, unless its coordinator has decided to commit it.
+func (c *Client) recover(ctx context.Context, group, id string) error {
+	var rec txRecord
+	if err := c.callStore(ctx, group, cmdTxInfo, id, &rec); err != nil {
+		if _, ok := err.(*router.CommandError); ok {
+			return nil // Finished since the lock was met
+		}
+		return err
+	}
+	var commit bool
+	if err := c.callStore(ctx, rec.Coordinator, cmdDecide, decideArgs{ID: id}, &commit); err != nil {
+		return err
+	}
+	c.finish(ctx, id, rec.Coordinator, rec.Participants, commit)
+	return nil
+}
+
+// newTxID returns a random transaction id.
+func newTxID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
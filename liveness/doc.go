@@ -4,9 +4,19 @@ emitter (HbEm).
 
 The failure detector algorithm is based on the eventually perfect failure detector algorithm
 described in the Introduction to Reliable and Secure Distributed Programming textbook.
+Alternatively, setting fdType to PhiAccrual makes the failure detector suspect a replica based
+on the phi accrual failure detector, which adapts to the observed heartbeat inter-arrival times
+of each replica. Both variants publish the same Suspect and Restore messages.
+If fdProbeHelpers is set, a replica is only suspected after an indirect probe, in which a few
+other replicas are asked whether they still hear from it. Failure handlers can further require
+that a quorum of replicas suspect a replica before acting, see SubscribeToConfirmedFdMsgs.
 
 The leader detector algorithm builds on the failure detector algorithm and is based on the
 monarchical eventual leader detection algorithm also described in the aforementioned textbook.
+The leaderDetector config selects how the leader is ranked among the non-suspected replicas:
+by Paxos id (Monarchical), by Paxos id among the replicas that are not lagging behind
+(UpToDate), or by a list of preferred leaders (Preferred). Leadership can also be moved
+explicitly using TransferLeadership.
 
 The heartbeat emitter algorithm emits heartbeats to the other replicas in the system based on
 a periodic timeout generated by a Ticker.
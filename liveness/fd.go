@@ -2,11 +2,13 @@ package liveness
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/elog"
 	e "github.com/relab/goxos/elog/event"
@@ -23,19 +25,35 @@ const (
 
 // The state of the failure detector (Fd)
 type Fd struct {
-	grpmgr          grp.GroupManager
-	grpSubscriber   grp.Subscriber
-	alive           map[grp.ID]bool
-	suspected       map[grp.ID]bool
-	timeout         time.Duration
-	Δ               time.Duration
-	ticker          *time.Ticker
-	heartbeatChan   <-chan grp.ID
-	fdSubscribers   map[string]chan FdMsg
-	resendSuspected chan bool
-	getSuspected    chan SuspectedRequest
-	stop            chan bool
-	stopCheckIn     *sync.WaitGroup
+	grpmgr               grp.GroupManager
+	grpSubscriber        grp.Subscriber
+	alive                map[grp.ID]bool
+	suspected            map[grp.ID]bool
+	timeout              time.Duration
+	Δ                    time.Duration
+	phi                  *phiDetector // Non-nil if using the phi accrual FD
+	checkInterval        time.Duration
+	ticker               *time.Ticker
+	heartbeatChan        <-chan grp.ID
+	lastHeard            map[grp.ID]time.Time
+	fdSubscribers        map[string]chan FdMsg
+	prober               *prober // Non-nil if indirect probing is enabled
+	outB                 chan<- interface{}
+	dmx                  Registrar
+	probeReqChan         chan ProbeRequest
+	probeRespChan        chan ProbeResponse
+	probeTimeoutChan     chan probeTimeout
+	suspicionChan        chan Suspicion
+	quorumConfirm        bool
+	suspectedBy          map[grp.ID]map[grp.ID]bool
+	confirmed            map[grp.ID]bool
+	confirmedSubscribers map[string]chan FdMsg
+	resendSuspected      chan bool
+	getSuspected         chan SuspectedRequest
+	replay               bool
+	replayTimeout        chan bool
+	stop                 chan bool
+	stopCheckIn          *sync.WaitGroup
 }
 
 // The type of message sent to other modules who are interested in receiving
@@ -48,22 +66,56 @@ type FdMsg struct {
 	ID    grp.ID
 }
 
-// Construct a new failure detector
+// Construct a new failure detector. The fdType config selects between the
+// fixed timeout and the phi accrual failure detector. Probe and suspicion
+// messages are broadcast on outB, and received on channels registered with
+// dmx.
 func NewFd(id grp.ID, gm grp.GroupManager, cfg config.Config,
-	heartbeatChan <-chan grp.ID, stopCheckIn *sync.WaitGroup) *Fd {
-	return &Fd{
-		grpmgr:          gm,
-		alive:           make(map[grp.ID]bool),
-		suspected:       make(map[grp.ID]bool),
-		fdSubscribers:   make(map[string]chan FdMsg),
-		resendSuspected: make(chan bool),
-		getSuspected:    make(chan SuspectedRequest),
-		timeout:         cfg.GetDuration("fdTimeoutInterval", config.DefFdTimeoutInterval),
-		Δ:               cfg.GetDuration("fdDeltaIncrease", config.DefFdDeltaIncrease),
-		heartbeatChan:   heartbeatChan,
-		stop:            make(chan bool),
-		stopCheckIn:     stopCheckIn,
+	heartbeatChan <-chan grp.ID, outB chan<- interface{}, dmx Registrar,
+	stopCheckIn *sync.WaitGroup) *Fd {
+	fd := &Fd{
+		grpmgr:               gm,
+		alive:                make(map[grp.ID]bool),
+		suspected:            make(map[grp.ID]bool),
+		lastHeard:            make(map[grp.ID]time.Time),
+		fdSubscribers:        make(map[string]chan FdMsg),
+		outB:                 outB,
+		dmx:                  dmx,
+		probeReqChan:         make(chan ProbeRequest, 64),
+		probeRespChan:        make(chan ProbeResponse, 64),
+		probeTimeoutChan:     make(chan probeTimeout, 64),
+		suspicionChan:        make(chan Suspicion, 64),
+		quorumConfirm:        cfg.GetBool("fdQuorumConfirm", config.DefFdQuorumConfirm),
+		suspectedBy:          make(map[grp.ID]map[grp.ID]bool),
+		confirmed:            make(map[grp.ID]bool),
+		confirmedSubscribers: make(map[string]chan FdMsg),
+		resendSuspected:      make(chan bool),
+		getSuspected:         make(chan SuspectedRequest),
+		replayTimeout:        make(chan bool),
+		timeout:              cfg.GetDuration("fdTimeoutInterval", config.DefFdTimeoutInterval),
+		Δ:                    cfg.GetDuration("fdDeltaIncrease", config.DefFdDeltaIncrease),
+		heartbeatChan:        heartbeatChan,
+		stop:                 make(chan bool),
+		stopCheckIn:          stopCheckIn,
 	}
+	fd.checkInterval = fd.timeout
+
+	fdType := cfg.GetString("fdType", config.DefFdType)
+	switch strings.TrimSpace(strings.ToLower(fdType)) {
+	case "phiaccrual":
+		fd.phi = newPhiDetector(cfg, fd.timeout)
+		fd.checkInterval = cfg.GetDuration("fdPhiCheckInterval", config.DefFdPhiCheckInterval)
+	case "timeout":
+	default:
+		glog.Warningf("unknown fdType %q, using Timeout", fdType)
+	}
+
+	if helpers := cfg.GetInt("fdProbeHelpers", config.DefFdProbeHelpers); helpers > 0 {
+		fd.prober = newProber(helpers,
+			cfg.GetDuration("fdProbeTimeout", config.DefFdProbeTimeout))
+	}
+
+	return fd
 }
 
 // Start running the failure detector, spawn a goroutine to handle incoming and
@@ -71,16 +123,38 @@ func NewFd(id grp.ID, gm grp.GroupManager, cfg config.Config,
 func (fd *Fd) Start() {
 	glog.V(1).Info("starting")
 	fd.grpSubscriber = fd.grpmgr.SubscribeToHold("fd")
+	if fd.dmx != nil {
+		fd.dmx.RegisterChannel(fd.probeReqChan)
+		fd.dmx.RegisterChannel(fd.probeRespChan)
+		fd.dmx.RegisterChannel(fd.suspicionChan)
+	}
 
 	go func() {
 		defer fd.stopCheckIn.Done()
-		fd.ticker = time.NewTicker(fd.timeout)
+		if !fd.replay {
+			fd.ticker = time.NewTicker(fd.checkInterval)
+		}
 		for {
 			select {
-			case <-fd.ticker.C:
+			case <-fd.tickerChan():
+				trace.RecordTimer(trace.FdTimer)
+				fd.timeoutProcedure()
+			case <-fd.replayTimeout:
 				fd.timeoutProcedure()
 			case id := <-fd.heartbeatChan:
 				fd.alive[id] = true
+				fd.lastHeard[id] = time.Now()
+				if fd.phi != nil {
+					fd.phi.heartbeat(id, time.Now())
+				}
+			case req := <-fd.probeReqChan:
+				fd.handleProbeRequest(req)
+			case resp := <-fd.probeRespChan:
+				fd.handleProbeResponse(resp)
+			case timeout := <-fd.probeTimeoutChan:
+				fd.handleProbeTimeout(timeout)
+			case s := <-fd.suspicionChan:
+				fd.handleSuspicion(s)
 			case grpPrepare := <-fd.grpSubscriber.PrepareChan():
 				fd.handleGrpHold(grpPrepare)
 			case <-fd.resendSuspected:
@@ -100,6 +174,21 @@ func (fd *Fd) Stop() {
 	fd.stop <- true
 }
 
+// EnableReplay turns off the ticker of the failure detector. Timeouts must
+// then be triggered using Timeout. Must be called before Start.
+func (fd *Fd) EnableReplay() {
+	fd.replay = true
+}
+
+// Timeout runs the timeout procedure of a failure detector in replay mode.
+func (fd *Fd) Timeout() {
+	fd.replayTimeout <- true
+}
+
+func (fd *Fd) tickerChan() <-chan time.Time {
+	return tickerChan(fd.ticker)
+}
+
 // If a module is interested in receiving Suspect and Restore events, this interest
 // can be registered with this method. A name is needed to uniquely identify the
 // subscription.
@@ -110,6 +199,19 @@ func (fd *Fd) SubscribeToFdMsgs(name string) <-chan FdMsg {
 	return fdChan
 }
 
+// Failure handlers that should only act on a replica suspected by a quorum
+// of replicas register with this method instead of SubscribeToFdMsgs. If
+// fdQuorumConfirm is off, it is the same as SubscribeToFdMsgs.
+func (fd *Fd) SubscribeToConfirmedFdMsgs(name string) <-chan FdMsg {
+	if !fd.quorumConfirm {
+		return fd.SubscribeToFdMsgs(name)
+	}
+	fdChan := make(chan FdMsg, fd.grpmgr.Quorum())
+	fd.confirmedSubscribers[name] = fdChan
+
+	return fdChan
+}
+
 // Let the current replica know about all currently suspected replicas. Used by the
 // Live Replacement module.
 func (fd *Fd) ResendCurrentSuspected() {
@@ -127,24 +229,73 @@ func (fd *Fd) timeoutProcedure() {
 		glog.Info("timeout")
 	}
 
+	if fd.phi != nil {
+		fd.phiProcedure()
+		return
+	}
+
 	if !fd.isAliveSuspectedIntersectionEmpty() {
 		fd.timeout = fd.timeout + fd.Δ
-		fd.ticker = time.NewTicker(fd.timeout)
+		if !fd.replay {
+			fd.ticker.Stop()
+			fd.ticker = time.NewTicker(fd.timeout)
+		}
 	}
 
+	now := time.Now()
 	fd.alive[fd.grpmgr.GetID()] = true // add ourselves
 	for _, id := range fd.grpmgr.NodeMap().IDs() {
 		if fd.notInAliveAndSuspected(id) {
-			fd.suspected[id] = true
-			elog.Log(e.NewEventWithMetric(e.FailureHandlingSuspect, uint64(id.PaxosID)))
-			fd.publishFdMsg(FdMsg{Suspect, id})
+			fd.trySuspect(id, now)
 		} else if fd.inAliveAndSuspected(id) {
-			delete(fd.suspected, id)
-			fd.publishFdMsg(FdMsg{Restore, id})
+			fd.restore(id)
+		} else if fd.alive[id] {
+			fd.cancelProbe(id)
 		}
 	}
 
 	fd.alive = make(map[grp.ID]bool)
+	fd.resyncSuspicions()
+}
+
+// phiProcedure suspects every node whose phi value has reached the
+// threshold, and restores suspected nodes whose phi value has dropped below
+// it again.
+func (fd *Fd) phiProcedure() {
+	now := time.Now()
+	self := fd.grpmgr.GetID()
+	ids := fd.grpmgr.NodeMap().IDs()
+	fd.phi.retain(ids)
+	for _, id := range ids {
+		if id == self {
+			continue
+		}
+		suspect := fd.phi.suspect(id, now)
+		if suspect && !fd.suspected[id] {
+			fd.trySuspect(id, now)
+		} else if !suspect {
+			fd.cancelProbe(id)
+			if fd.suspected[id] {
+				fd.restore(id)
+			}
+		}
+	}
+
+	fd.alive = make(map[grp.ID]bool)
+	fd.resyncSuspicions()
+}
+
+func (fd *Fd) suspect(id grp.ID) {
+	fd.suspected[id] = true
+	elog.Log(e.NewEventWithMetric(e.FailureHandlingSuspect, uint64(id.PaxosID)))
+	fd.publishFdMsg(FdMsg{Suspect, id})
+	fd.announceSuspicion()
+}
+
+func (fd *Fd) restore(id grp.ID) {
+	delete(fd.suspected, id)
+	fd.publishFdMsg(FdMsg{Restore, id})
+	fd.announceSuspicion()
 }
 
 func (fd *Fd) isAliveSuspectedIntersectionEmpty() bool {
@@ -1,7 +1,20 @@
 package liveness
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+var (
+	ErrTransferUnavailable = errors.New("leader detector can not transfer leadership")
+	ErrTransferTarget      = errors.New("transfer target is not a proposer or is suspected")
 )
 
 type LeaderDetector interface {
@@ -11,4 +24,59 @@ type LeaderDetector interface {
 	SubscribeToReplacementLdMsgs(name string) <-chan grp.ID
 	PaxosLeader() grp.ID
 	ReplacementLeader() grp.ID
+	TransferLeadership(to grp.ID) error
+	LatestTransfer() LeaderTransfer
+	SetLatestTransfer(t LeaderTransfer)
+}
+
+// A ProgressFunc returns the all-decided-up-to slot of the local replica.
+type ProgressFunc func() uint64
+
+// NewLeaderDetector returns the leader detector selected by the
+// leaderDetector config. Status and transfer messages are broadcast on outB,
+// and received on channels registered with dmx.
+func NewLeaderDetector(cfg config.Config, gm grp.GroupManager, fd *Fd,
+	progress ProgressFunc, outB chan<- interface{}, dmx Registrar,
+	stopCheckIn *sync.WaitGroup) LeaderDetector {
+	var mld *MonarchicalLD
+	ldType := cfg.GetString("leaderDetector", config.DefLeaderDetector)
+	switch strings.TrimSpace(strings.ToLower(ldType)) {
+	case "uptodate":
+		mld = NewUpToDateLD(gm, fd, progress,
+			uint64(cfg.GetInt("ldMaxLag", config.DefLdMaxLag)),
+			cfg.GetDuration("ldStatusInterval", config.DefLdStatusInterval),
+			stopCheckIn)
+	case "preferred":
+		preferred, err := parsePaxosIDs(
+			cfg.GetString("ldPreferredLeaders", config.DefLdPreferredLeaders))
+		if err != nil {
+			glog.Fatalln("ldPreferredLeaders:", err)
+		}
+		mld = NewPreferredLD(gm, fd, preferred, stopCheckIn)
+	case "monarchical":
+		mld = NewMonarchicalLD(gm, fd, stopCheckIn)
+	default:
+		glog.Warningf("unknown leaderDetector %q, using Monarchical", ldType)
+		mld = NewMonarchicalLD(gm, fd, stopCheckIn)
+	}
+	mld.enableTransfer(progress, outB, dmx,
+		cfg.GetDuration("ldTransferTimeout", config.DefLdTransferTimeout))
+	return mld
+}
+
+// parsePaxosIDs parses a comma separated list of Paxos ids.
+func parsePaxosIDs(list string) ([]grp.PaxosID, error) {
+	var ids []grp.PaxosID
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, grp.PaxosID(id))
+	}
+	return ids, nil
 }
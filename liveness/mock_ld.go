@@ -29,3 +29,13 @@ func (mld *MockLD) PaxosLeader() grp.ID {
 func (mld *MockLD) ReplacementLeader() grp.ID {
 	return grp.ID{}
 }
+
+func (mld *MockLD) TransferLeadership(to grp.ID) error {
+	return ErrTransferUnavailable
+}
+
+func (mld *MockLD) LatestTransfer() LeaderTransfer {
+	return LeaderTransfer{To: grp.UndefinedID()}
+}
+
+func (mld *MockLD) SetLatestTransfer(t LeaderTransfer) {}
@@ -2,39 +2,119 @@ package liveness
 
 import (
 	"sync"
+	"time"
 
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
 
 // The state of the leader detector (Ld)
 type MonarchicalLD struct {
-	pleader        grp.ID
-	rleader        grp.ID
-	fd             *Fd
-	grpmgr         grp.GroupManager
-	grpSubscriber  grp.Subscriber
-	suspected      map[grp.ID]bool
-	fdChan         <-chan FdMsg
-	pldSubscribers map[string]chan grp.ID
-	rldSubscribers map[string]chan grp.ID
-	checkLeaders   func()
-	stop           chan bool
-	stopCheckIn    *sync.WaitGroup
-}
-
-// Construct a new leader detector.
+	pleader         grp.ID
+	rleader         grp.ID
+	fd              *Fd
+	grpmgr          grp.GroupManager
+	grpSubscriber   grp.Subscriber
+	suspected       map[grp.ID]bool
+	fdChan          <-chan FdMsg
+	pldSubscribers  map[string]chan grp.ID
+	rldSubscribers  map[string]chan grp.ID
+	checkLeaders    func()
+	choose          func(candidates []grp.ID) grp.ID // Picks the Paxos leader
+	progress        ProgressFunc
+	outB            chan<- interface{}
+	dmx             Registrar
+	statusInterval  time.Duration
+	statusTicker    *time.Ticker
+	adus            map[grp.ID]uint64 // Latest progress reported by each replica
+	statusChan      chan LeaderStatus
+	transfer        *transferState
+	latest          latestTransfer
+	transferChan    chan LeaderTransfer
+	transferReqs    chan transferRequest
+	transferTimeout time.Duration
+	stop            chan bool
+	stopCheckIn     *sync.WaitGroup
+}
+
+// Construct a new leader detector, which trusts the non-suspected proposer
+// with the highest Paxos id.
 func NewMonarchicalLD(gm grp.GroupManager, fd *Fd, stopCheckIn *sync.WaitGroup) *MonarchicalLD {
+	ld := newLD(gm, fd, highestPaxosID, stopCheckIn)
+	ld.checkLeaders()
+	return ld
+}
+
+// Construct a new leader detector, which trusts the first non-suspected
+// proposer in preferred. If every replica in preferred is suspected, the
+// non-suspected proposer with the highest Paxos id is trusted.
+func NewPreferredLD(gm grp.GroupManager, fd *Fd, preferred []grp.PaxosID,
+	stopCheckIn *sync.WaitGroup) *MonarchicalLD {
+	choose := func(candidates []grp.ID) grp.ID {
+		for _, pid := range preferred {
+			for _, id := range candidates {
+				if id.PaxosID == pid {
+					return id
+				}
+			}
+		}
+		return highestPaxosID(candidates)
+	}
+	ld := newLD(gm, fd, choose, stopCheckIn)
+	ld.checkLeaders()
+	return ld
+}
+
+// Construct a new leader detector, which only trusts a proposer that is at
+// most maxLag slots behind the most up-to-date non-suspected proposer. Among
+// those, the one with the highest Paxos id is trusted. Every replica
+// broadcasts its progress each statusInterval. As with the monarchical leader
+// detector, a new leader is only chosen when the suspicions change, so a
+// trusted leader that falls behind is not replaced.
+func NewUpToDateLD(gm grp.GroupManager, fd *Fd, progress ProgressFunc, maxLag uint64,
+	statusInterval time.Duration, stopCheckIn *sync.WaitGroup) *MonarchicalLD {
+	var ld *MonarchicalLD
+	choose := func(candidates []grp.ID) grp.ID {
+		var max uint64
+		for _, id := range candidates {
+			if adu := ld.aduOf(id); adu > max {
+				max = adu
+			}
+		}
+		var upToDate []grp.ID
+		for _, id := range candidates {
+			if ld.aduOf(id)+maxLag >= max {
+				upToDate = append(upToDate, id)
+			}
+		}
+		return highestPaxosID(upToDate)
+	}
+	ld = newLD(gm, fd, choose, stopCheckIn)
+	ld.progress = progress
+	ld.statusInterval = statusInterval
+	ld.checkLeaders()
+	return ld
+}
+
+func newLD(gm grp.GroupManager, fd *Fd, choose func([]grp.ID) grp.ID,
+	stopCheckIn *sync.WaitGroup) *MonarchicalLD {
 	ld := &MonarchicalLD{
 		fd:             fd,
 		grpmgr:         gm,
 		suspected:      make(map[grp.ID]bool),
 		pldSubscribers: make(map[string]chan grp.ID),
 		rldSubscribers: make(map[string]chan grp.ID),
+		choose:         choose,
+		adus:           make(map[grp.ID]uint64),
+		statusChan:     make(chan LeaderStatus, 64),
+		transferChan:   make(chan LeaderTransfer, 8),
+		transferReqs:   make(chan transferRequest),
 		stop:           make(chan bool),
 		stopCheckIn:    stopCheckIn,
 	}
+	ld.latest.To = grp.UndefinedID()
 
 	if !ld.grpmgr.LrEnabled() && !ld.grpmgr.ArEnabled() {
 		ld.checkLeaders = ld.checkPaxosLeader
@@ -42,8 +122,6 @@ func NewMonarchicalLD(gm grp.GroupManager, fd *Fd, stopCheckIn *sync.WaitGroup)
 		ld.checkLeaders = ld.checkLeadersLrAr
 	}
 
-	ld.checkLeaders()
-
 	return ld
 }
 
@@ -53,13 +131,31 @@ func (mld *MonarchicalLD) Start() {
 	glog.V(1).Info("starting")
 	mld.grpSubscriber = mld.grpmgr.SubscribeToHold("ld")
 	mld.fdChan = mld.fd.SubscribeToFdMsgs("ld")
+	if mld.dmx != nil {
+		mld.dmx.RegisterChannel(mld.statusChan)
+		mld.dmx.RegisterChannel(mld.transferChan)
+	}
 	go func() {
 		defer mld.stopCheckIn.Done()
+		if mld.statusInterval > 0 && mld.outB != nil {
+			mld.statusTicker = time.NewTicker(mld.statusInterval)
+			defer mld.statusTicker.Stop()
+		}
 		mld.checkLeaders()
 		for {
 			select {
 			case fdmsg := <-mld.fdChan:
 				mld.handleFdMsg(fdmsg)
+			case <-tickerChan(mld.statusTicker):
+				mld.outB <- LeaderStatus{ID: mld.grpmgr.GetID(), Adu: mld.progress()}
+			case status := <-mld.statusChan:
+				mld.adus[status.ID] = status.Adu
+			case req := <-mld.transferReqs:
+				req.err <- mld.handleTransferRequest(req.to)
+			case msg := <-mld.transferChan:
+				mld.handleTransfer(msg)
+			case <-tickerChan(mld.transferTicker()):
+				mld.handleTransferTick()
 			case grpPrepare := <-mld.grpSubscriber.PrepareChan():
 				mld.handleGrpHold(grpPrepare)
 			case <-mld.stop:
@@ -132,6 +228,7 @@ func (mld *MonarchicalLD) handleFdMsg(fdmsg FdMsg) {
 	case Suspect:
 		glog.V(2).Infoln("received", fdmsg)
 		mld.suspected[fdmsg.ID] = true
+		mld.abortTransferTo(fdmsg.ID)
 		mld.checkLeaders()
 	case Restore:
 		glog.V(2).Infoln("received", fdmsg)
@@ -143,36 +240,74 @@ func (mld *MonarchicalLD) handleFdMsg(fdmsg FdMsg) {
 }
 
 func (mld *MonarchicalLD) publishPaxosTrust(id grp.ID) {
+	trace.RecordTrust(trace.PaxosTrust, id)
 	for _, sub := range mld.pldSubscribers {
 		sub <- id
 	}
 }
 
 func (mld *MonarchicalLD) publishReplacementTrust(id grp.ID) {
+	trace.RecordTrust(trace.ReplacementTrust, id)
 	for _, sub := range mld.rldSubscribers {
 		sub <- id
 	}
 }
 
 func (mld *MonarchicalLD) maxRank() grp.ID {
-	// Paxos leader: node with the highest paxos id
-	paxosLeader := grp.MinID()
-
 	if mld.grpmgr.NodeMap().Len() == 0 {
 		glog.Fatal("no nodes to rank")
 	}
 
+	var candidates []grp.ID
 	for _, id := range mld.grpmgr.NodeMap().ProposerIDs() {
-		if _, suspected := mld.suspected[id]; id.PaxosID > paxosLeader.PaxosID && !suspected {
-			paxosLeader = id
+		if _, suspected := mld.suspected[id]; !suspected {
+			candidates = append(candidates, id)
 		}
 	}
+	paxosLeader := mld.choosePaxosLeader(candidates)
 
 	glog.V(2).Infoln("highest paxos rank was", paxosLeader)
 
 	return paxosLeader
 }
 
+// choosePaxosLeader returns the target of a completed leadership transfer if
+// it is a candidate, and otherwise lets the ranking of the leader detector
+// choose. Returns grp.MinID() if there are no candidates.
+func (mld *MonarchicalLD) choosePaxosLeader(candidates []grp.ID) grp.ID {
+	if len(candidates) == 0 {
+		return grp.MinID()
+	}
+	if t := mld.transfer; t != nil && t.done {
+		for _, id := range candidates {
+			if id == t.to {
+				return id
+			}
+		}
+	}
+	return mld.choose(candidates)
+}
+
+// highestPaxosID returns the candidate with the highest Paxos id, or
+// grp.MinID() if there are no candidates.
+func highestPaxosID(candidates []grp.ID) grp.ID {
+	leader := grp.MinID()
+	for _, id := range candidates {
+		if id.PaxosID > leader.PaxosID {
+			leader = id
+		}
+	}
+	return leader
+}
+
+// aduOf returns the latest known progress of id.
+func (mld *MonarchicalLD) aduOf(id grp.ID) uint64 {
+	if id == mld.grpmgr.GetID() && mld.progress != nil {
+		return mld.progress()
+	}
+	return mld.adus[id]
+}
+
 func (mld *MonarchicalLD) maxRankLr() (paxosLeader, replacementLeader grp.ID) {
 	// Paxos leader: node with lowest epoch and highest paxos id.
 	paxosLeader = grp.MinID()
@@ -200,15 +335,17 @@ func (mld *MonarchicalLD) maxRankLr() (paxosLeader, replacementLeader grp.ID) {
 		}
 	}
 
-	// Find highest and lowest paxos id
+	// Find paxos leader and lowest paxos id
+	var candidates []grp.ID
 	for id := range nodesWithMinEpoch {
-		if _, suspected := mld.suspected[id]; id.PaxosID > paxosLeader.PaxosID && !suspected {
-			paxosLeader = id
+		if _, suspected := mld.suspected[id]; !suspected {
+			candidates = append(candidates, id)
 		}
 		if _, suspected := mld.suspected[id]; id.PaxosID < replacementLeader.PaxosID && !suspected {
 			replacementLeader = id
 		}
 	}
+	paxosLeader = mld.choosePaxosLeader(candidates)
 
 	glog.V(2).Infof("node ids %v, epochs %v",
 		mld.grpmgr.NodeMap().IDs(), mld.grpmgr.NodeMap().Epochs())
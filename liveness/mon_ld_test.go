@@ -2,6 +2,7 @@ package liveness
 
 import (
 	"testing"
+	"time"
 
 	"github.com/relab/goxos/grp"
 )
@@ -50,3 +51,110 @@ func TestMaxRank(t *testing.T) {
 		t.Errorf("maxRank: want %v, got %v", expectedID, actualID)
 	}
 }
+
+func TestPreferredRank(t *testing.T) {
+	grpmgr := grp.NewGrpMgr(id, nmPaxos, false, false, nil)
+	ld := NewPreferredLD(grpmgr, nil, []grp.PaxosID{2, 1}, nil)
+
+	actualID, expectedID := ld.maxRank(), grp.NewIDFromInt(2, 0)
+	if actualID != expectedID {
+		t.Errorf("maxRank: want %v, got %v", expectedID, actualID)
+	}
+
+	ld.suspected[grp.NewIDFromInt(2, 0)] = true
+	ld.suspected[grp.NewIDFromInt(1, 0)] = true
+
+	actualID, expectedID = ld.maxRank(), grp.NewIDFromInt(4, 0)
+	if actualID != expectedID {
+		t.Errorf("maxRank: want %v, got %v", expectedID, actualID)
+	}
+}
+
+func TestUpToDateRank(t *testing.T) {
+	grpmgr := grp.NewGrpMgr(id, nmPaxos, false, false, nil)
+	progress := func() uint64 { return 100 }
+	ld := NewUpToDateLD(grpmgr, nil, progress, 10, 0, nil)
+	ld.adus[grp.NewIDFromInt(1, 0)] = 95
+	ld.adus[grp.NewIDFromInt(2, 0)] = 120
+	ld.adus[grp.NewIDFromInt(3, 0)] = 50
+	ld.adus[grp.NewIDFromInt(4, 0)] = 80
+
+	actualID, expectedID := ld.maxRank(), grp.NewIDFromInt(2, 0)
+	if actualID != expectedID {
+		t.Errorf("maxRank: want %v, got %v", expectedID, actualID)
+	}
+
+	// Suspect {2,0}, leaving {0,0} and {1,0} within the lag of 100
+	ld.suspected[grp.NewIDFromInt(2, 0)] = true
+
+	actualID, expectedID = ld.maxRank(), grp.NewIDFromInt(1, 0)
+	if actualID != expectedID {
+		t.Errorf("maxRank: want %v, got %v", expectedID, actualID)
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	grpmgr := grp.NewGrpMgr(id, nmPaxos, false, false, nil)
+	adu := uint64(10)
+	ld := NewMonarchicalLD(grpmgr, nil, nil)
+	ld.enableTransfer(func() uint64 { return adu }, nil, nil, time.Minute)
+
+	// Transfer to another replica takes effect at once
+	ld.handleTransfer(LeaderTransfer{From: grp.NewIDFromInt(4, 0), To: grp.NewIDFromInt(1, 0), Adu: 20, Epoch: 1})
+	if ld.PaxosLeader() != grp.NewIDFromInt(1, 0) {
+		t.Errorf("leader after transfer: want %v, got %v", grp.NewIDFromInt(1, 0), ld.PaxosLeader())
+	}
+
+	// Transfer to ourselves waits until we have caught up
+	ld.handleTransfer(LeaderTransfer{From: grp.NewIDFromInt(1, 0), To: id, Adu: 20, Epoch: 2})
+	ld.handleTransferTick()
+	if ld.PaxosLeader() == id {
+		t.Fatalf("took over leadership before catching up")
+	}
+	adu = 20
+	ld.handleTransferTick()
+	if ld.PaxosLeader() != id {
+		t.Errorf("leader after catching up: want %v, got %v", id, ld.PaxosLeader())
+	}
+
+	// The transfer is forgotten when the target is suspected, and
+	// announcements of it are ignored
+	ld.handleFdMsg(FdMsg{Suspect, id})
+	if ld.PaxosLeader() != grp.NewIDFromInt(4, 0) {
+		t.Errorf("leader after suspecting target: want %v, got %v", grp.NewIDFromInt(4, 0), ld.PaxosLeader())
+	}
+	ld.handleFdMsg(FdMsg{Restore, id})
+	ld.handleTransfer(LeaderTransfer{From: id, To: id, Epoch: 2})
+	if ld.PaxosLeader() == id {
+		t.Errorf("announcement of aborted transfer was accepted")
+	}
+}
+
+func TestTransferEpochs(t *testing.T) {
+	grpmgr := grp.NewGrpMgr(id, nmPaxos, false, false, nil)
+	ld := NewMonarchicalLD(grpmgr, nil, nil)
+	ld.enableTransfer(func() uint64 { return 0 }, nil, nil, time.Minute)
+	one, three := grp.NewIDFromInt(1, 0), grp.NewIDFromInt(3, 0)
+
+	// A replica joining later learns the latest transfer by state transfer
+	ld.SetLatestTransfer(LeaderTransfer{To: one, Epoch: 5})
+	ld.handleTransfer(<-ld.transferChan)
+	if ld.PaxosLeader() != one {
+		t.Fatalf("leader after state transfer: want %v, got %v", one, ld.PaxosLeader())
+	}
+
+	// Earlier transfers are ignored, and of transfers of the same epoch the
+	// one to the highest Paxos id wins
+	ld.handleTransfer(LeaderTransfer{To: three, Epoch: 4})
+	if ld.PaxosLeader() != one {
+		t.Errorf("earlier transfer to %v was accepted", three)
+	}
+	ld.handleTransfer(LeaderTransfer{To: three, Epoch: 5})
+	ld.handleTransfer(LeaderTransfer{To: one, Epoch: 5})
+	if ld.PaxosLeader() != three {
+		t.Errorf("leader after concurrent transfers: want %v, got %v", three, ld.PaxosLeader())
+	}
+	if latest := ld.LatestTransfer(); latest.To != three || latest.Epoch != 5 {
+		t.Errorf("latest transfer is %v", latest)
+	}
+}
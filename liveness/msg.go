@@ -8,6 +8,11 @@ import (
 
 func init() {
 	gob.Register(Heartbeat{})
+	gob.Register(ProbeRequest{})
+	gob.Register(ProbeResponse{})
+	gob.Register(Suspicion{})
+	gob.Register(LeaderStatus{})
+	gob.Register(LeaderTransfer{})
 }
 
 // A Heartbeat message is used by replicas to indicate they are alive in
@@ -15,3 +20,50 @@ func init() {
 type Heartbeat struct {
 	ID grp.ID
 }
+
+// A ProbeRequest asks the replicas in Helpers whether they still hear from
+// Target. It is broadcast, and ignored by replicas not in Helpers.
+type ProbeRequest struct {
+	From    grp.ID
+	Target  grp.ID
+	Seq     uint64
+	Helpers []grp.ID
+}
+
+// A ProbeResponse tells the replica To whether the replica From has
+// recently received a heartbeat from Target. It is broadcast, and ignored by
+// all replicas but To.
+type ProbeResponse struct {
+	From   grp.ID
+	To     grp.ID
+	Target grp.ID
+	Seq    uint64
+	Alive  bool
+}
+
+// A Suspicion tells the other replicas which replicas From suspects. It is
+// sent whenever that changes, and on every timeout of the failure detector
+// so that a lost Suspicion is made up for. Only sent if quorum confirmation
+// is enabled.
+type Suspicion struct {
+	From      grp.ID
+	Suspected []grp.ID
+}
+
+// A LeaderStatus is periodically broadcast by the UpToDate leader detector,
+// so that the other replicas know how far it has come in executing the log.
+type LeaderStatus struct {
+	ID  grp.ID
+	Adu uint64
+}
+
+// A LeaderTransfer asks every replica to trust To as Paxos leader. Adu is the
+// progress of the sender, which To catches up to before it takes over. A
+// replica only accepts a transfer of a later Epoch than the latest it has
+// accepted; To announces it again once it has taken over.
+type LeaderTransfer struct {
+	From  grp.ID
+	To    grp.ID
+	Adu   uint64
+	Epoch uint64
+}
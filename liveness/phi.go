@@ -0,0 +1,135 @@
+package liveness
+
+import (
+	"math"
+	"time"
+
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+)
+
+// A phiDetector holds the state of the phi accrual failure detector. Instead
+// of a binary alive/suspected output per timeout, it computes a suspicion
+// level, phi, from the time since the last heartbeat of a node and the
+// distribution of its recent heartbeat inter-arrival times. See Hayashibara
+// et al., "The φ Accrual Failure Detector".
+type phiDetector struct {
+	threshold  float64
+	windowSize int
+	minStdDev  time.Duration
+	bootstrap  time.Duration
+	windows    map[grp.ID]*arrivalWindow
+}
+
+func newPhiDetector(cfg config.Config, bootstrap time.Duration) *phiDetector {
+	return &phiDetector{
+		threshold:  cfg.GetFloat("fdPhiThreshold", config.DefFdPhiThreshold),
+		windowSize: cfg.GetInt("fdPhiWindowSize", config.DefFdPhiWindowSize),
+		minStdDev:  cfg.GetDuration("fdPhiMinStdDev", config.DefFdPhiMinStdDev),
+		bootstrap:  bootstrap,
+		windows:    make(map[grp.ID]*arrivalWindow),
+	}
+}
+
+// window returns the arrival window of id, creating it if needed. A new window
+// starts at now, with a single inter-arrival time equal to the bootstrap
+// estimate, so that a node that never sends a heartbeat is eventually
+// suspected.
+func (pd *phiDetector) window(id grp.ID, now time.Time) *arrivalWindow {
+	w, found := pd.windows[id]
+	if !found {
+		w = newArrivalWindow(pd.windowSize, now)
+		w.addInterval(pd.bootstrap)
+		pd.windows[id] = w
+	}
+	return w
+}
+
+// heartbeat records the arrival of a heartbeat from id at now.
+func (pd *phiDetector) heartbeat(id grp.ID, now time.Time) {
+	w, found := pd.windows[id]
+	if !found {
+		pd.window(id, now)
+		return
+	}
+	w.add(now)
+}
+
+// suspect returns true if the phi value of id at now is at or above the
+// threshold.
+func (pd *phiDetector) suspect(id grp.ID, now time.Time) bool {
+	return pd.window(id, now).phi(now, pd.minStdDev) >= pd.threshold
+}
+
+// retain drops the arrival windows of nodes not in ids.
+func (pd *phiDetector) retain(ids []grp.ID) {
+	keep := make(map[grp.ID]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	for id := range pd.windows {
+		if !keep[id] {
+			delete(pd.windows, id)
+		}
+	}
+}
+
+// An arrivalWindow is a sliding window of the most recent heartbeat
+// inter-arrival times of a node.
+type arrivalWindow struct {
+	last      time.Time
+	intervals []float64 // Ring buffer, in nanoseconds
+	next      int
+	sum       float64
+	sumSq     float64
+}
+
+func newArrivalWindow(size int, now time.Time) *arrivalWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &arrivalWindow{
+		last:      now,
+		intervals: make([]float64, 0, size),
+	}
+}
+
+// add records a heartbeat arriving at now.
+func (w *arrivalWindow) add(now time.Time) {
+	w.addInterval(now.Sub(w.last))
+	w.last = now
+}
+
+func (w *arrivalWindow) addInterval(d time.Duration) {
+	x := float64(d)
+	if len(w.intervals) < cap(w.intervals) {
+		w.intervals = append(w.intervals, x)
+	} else {
+		old := w.intervals[w.next]
+		w.sum -= old
+		w.sumSq -= old * old
+		w.intervals[w.next] = x
+		w.next = (w.next + 1) % len(w.intervals)
+	}
+	w.sum += x
+	w.sumSq += x * x
+}
+
+// phi returns the suspicion level of the node at now, given that the
+// inter-arrival times are normally distributed with at least minStdDev
+// standard deviation.
+func (w *arrivalWindow) phi(now time.Time, minStdDev time.Duration) float64 {
+	n := float64(len(w.intervals))
+	mean := w.sum / n
+	stdDev := math.Sqrt(math.Max(w.sumSq/n-mean*mean, 0))
+	stdDev = math.Max(stdDev, float64(minStdDev))
+	elapsed := float64(now.Sub(w.last))
+
+	// Logistic approximation of the cumulative normal distribution
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
@@ -0,0 +1,36 @@
+package liveness
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArrivalWindowPhi(t *testing.T) {
+	start := time.Unix(0, 0)
+	interval := 100 * time.Millisecond
+	w := newArrivalWindow(10, start)
+	now := start
+	for i := 0; i < 20; i++ {
+		now = now.Add(interval)
+		w.add(now)
+	}
+	if len(w.intervals) != 10 {
+		t.Fatalf("window holds %d intervals, want 10", len(w.intervals))
+	}
+
+	minStdDev := 10 * time.Millisecond
+	if phi := w.phi(now.Add(interval), minStdDev); phi > 1 {
+		t.Errorf("phi on time: got %v, want at most 1", phi)
+	}
+	if phi := w.phi(now.Add(5*interval), minStdDev); phi < 8 {
+		t.Errorf("phi after missing heartbeats: got %v, want at least 8", phi)
+	}
+	prev := 0.0
+	for d := interval; d < 3*interval; d += 10 * time.Millisecond {
+		phi := w.phi(now.Add(d), minStdDev)
+		if phi < prev {
+			t.Fatalf("phi decreased from %v to %v at %v", prev, phi, d)
+		}
+		prev = phi
+	}
+}
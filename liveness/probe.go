@@ -0,0 +1,283 @@
+package liveness
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/elog"
+	e "github.com/relab/goxos/elog/event"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// A Registrar registers channels for receiving messages from other replicas,
+// such as net.Demuxer.
+type Registrar interface {
+	RegisterChannel(ch interface{})
+}
+
+// A prober holds the state of indirect probing. Before suspecting a replica,
+// the failure detector asks a few other replicas, the helpers, whether they
+// still hear from it. The replica is only suspected if no helper does within
+// the probe timeout. Since every replica already receives periodic heartbeats
+// from all others, a helper answers from its own recent heartbeats instead of
+// pinging the target.
+type prober struct {
+	helpers int
+	timeout time.Duration
+	seq     uint64
+	pending map[grp.ID]*probe
+}
+
+// A probe is an ongoing indirect probe of a replica.
+type probe struct {
+	seq      uint64
+	helpers  []grp.ID
+	replies  int
+	deadline time.Time
+	timer    *time.Timer // Nil in replay mode
+}
+
+// A probeTimeout tells the failure detector that the deadline of a probe
+// has passed.
+type probeTimeout struct {
+	target grp.ID
+	seq    uint64
+}
+
+func newProber(helpers int, timeout time.Duration) *prober {
+	return &prober{
+		helpers: helpers,
+		timeout: timeout,
+		pending: make(map[grp.ID]*probe),
+	}
+}
+
+// trySuspect suspects id, unless probing is enabled, in which case an
+// indirect probe of id is started, or resolved if its deadline has passed.
+// The deadline is also resolved by a timer of its own, except in replay
+// mode, so that it does not wait for the next timeout of the detector.
+func (fd *Fd) trySuspect(id grp.ID, now time.Time) {
+	if fd.prober == nil {
+		fd.suspect(id)
+		return
+	}
+
+	if p, found := fd.prober.pending[id]; found {
+		if now.After(p.deadline) {
+			fd.confirmProbe(id)
+		}
+		return
+	}
+
+	helpers := fd.chooseHelpers(id)
+	if len(helpers) == 0 {
+		fd.suspect(id)
+		return
+	}
+	fd.prober.seq++
+	p := &probe{
+		seq:      fd.prober.seq,
+		helpers:  helpers,
+		deadline: now.Add(fd.prober.timeout),
+	}
+	if !fd.replay {
+		timeout := probeTimeout{id, p.seq}
+		p.timer = time.AfterFunc(fd.prober.timeout, func() {
+			select {
+			case fd.probeTimeoutChan <- timeout:
+			default: // Resolved on the next timeout of the detector instead
+			}
+		})
+	}
+	fd.prober.pending[id] = p
+	glog.V(2).Infof("probing %v through %v", id, helpers)
+	elog.Log(e.NewEventWithMetric(e.FailureHandlingProbeStart, uint64(id.PaxosID)))
+	fd.outB <- ProbeRequest{
+		From:    fd.grpmgr.GetID(),
+		Target:  id,
+		Seq:     fd.prober.seq,
+		Helpers: helpers,
+	}
+}
+
+// cancelProbe stops any ongoing probe of id, since we heard from it again.
+func (fd *Fd) cancelProbe(id grp.ID) {
+	if fd.prober != nil {
+		fd.endProbe(id)
+	}
+}
+
+// endProbe forgets the probe of id and stops its timer.
+func (fd *Fd) endProbe(id grp.ID) {
+	if p, found := fd.prober.pending[id]; found {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(fd.prober.pending, id)
+	}
+}
+
+// handleProbeTimeout confirms the suspicion of the target of a probe whose
+// deadline has passed, unless the probe has ended since.
+func (fd *Fd) handleProbeTimeout(timeout probeTimeout) {
+	if fd.prober == nil {
+		return
+	}
+	if p, found := fd.prober.pending[timeout.target]; found && p.seq == timeout.seq {
+		fd.confirmProbe(timeout.target)
+	}
+}
+
+// chooseHelpers returns up to prober.helpers random replicas, other than
+// ourselves and target, that we do not suspect.
+func (fd *Fd) chooseHelpers(target grp.ID) []grp.ID {
+	self := fd.grpmgr.GetID()
+	var candidates []grp.ID
+	for _, id := range fd.grpmgr.NodeMap().IDs() {
+		if id != self && id != target && !fd.suspected[id] {
+			candidates = append(candidates, id)
+		}
+	}
+	for i := range candidates {
+		j := i + rand.Intn(len(candidates)-i)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	if len(candidates) > fd.prober.helpers {
+		candidates = candidates[:fd.prober.helpers]
+	}
+	return candidates
+}
+
+func (fd *Fd) confirmProbe(id grp.ID) {
+	fd.endProbe(id)
+	glog.V(2).Infof("probe confirmed suspicion of %v", id)
+	elog.Log(e.NewEventWithMetric(e.FailureHandlingProbeConfirmed, uint64(id.PaxosID)))
+	fd.suspect(id)
+}
+
+func (fd *Fd) handleProbeRequest(req ProbeRequest) {
+	self := fd.grpmgr.GetID()
+	if req.From == self {
+		return
+	}
+	for _, helper := range req.Helpers {
+		if helper != self {
+			continue
+		}
+		last, heard := fd.lastHeard[req.Target]
+		fd.outB <- ProbeResponse{
+			From:   self,
+			To:     req.From,
+			Target: req.Target,
+			Seq:    req.Seq,
+			Alive:  heard && time.Since(last) < fd.timeout,
+		}
+		return
+	}
+}
+
+func (fd *Fd) handleProbeResponse(resp ProbeResponse) {
+	if fd.prober == nil || resp.To != fd.grpmgr.GetID() {
+		return
+	}
+	p, found := fd.prober.pending[resp.Target]
+	if !found || p.seq != resp.Seq {
+		return
+	}
+	if resp.Alive {
+		fd.endProbe(resp.Target)
+		glog.V(2).Infof("%v still hears from %v, not suspecting", resp.From, resp.Target)
+		elog.Log(e.NewEventWithMetric(e.FailureHandlingProbeRefuted, uint64(resp.Target.PaxosID)))
+		return
+	}
+	p.replies++
+	if p.replies == len(p.helpers) {
+		fd.confirmProbe(resp.Target)
+	}
+}
+
+// announceSuspicion lets the other replicas know which replicas we suspect,
+// if quorum confirmation is enabled.
+func (fd *Fd) announceSuspicion() {
+	if !fd.quorumConfirm {
+		return
+	}
+	var suspected []grp.ID
+	for _, id := range fd.grpmgr.NodeMap().IDs() {
+		if fd.suspected[id] {
+			suspected = append(suspected, id)
+		}
+	}
+	fd.outB <- Suspicion{From: fd.grpmgr.GetID(), Suspected: suspected}
+}
+
+// resyncSuspicions forgets the suspicions by and of replicas that have left
+// the group, and announces ours again.
+func (fd *Fd) resyncSuspicions() {
+	if !fd.quorumConfirm {
+		return
+	}
+	nm := fd.grpmgr.NodeMap()
+	for target, by := range fd.suspectedBy {
+		if _, found := nm.LookupNode(target); !found {
+			delete(fd.suspectedBy, target)
+			delete(fd.confirmed, target)
+			continue
+		}
+		for from := range by {
+			if _, found := nm.LookupNode(from); !found {
+				delete(by, from)
+			}
+		}
+	}
+	for _, target := range nm.IDs() {
+		fd.checkSuspicionQuorum(target)
+	}
+	fd.announceSuspicion()
+}
+
+// handleSuspicion records which replicas the sender suspects, and publishes
+// a confirmed Suspect or Restore message when the number of replicas
+// suspecting a target reaches or falls below a quorum.
+func (fd *Fd) handleSuspicion(s Suspicion) {
+	suspects := make(map[grp.ID]bool)
+	for _, target := range s.Suspected {
+		suspects[target] = true
+		if fd.suspectedBy[target] == nil {
+			fd.suspectedBy[target] = make(map[grp.ID]bool)
+		}
+	}
+	for target, by := range fd.suspectedBy {
+		if suspects[target] {
+			by[s.From] = true
+		} else {
+			delete(by, s.From)
+		}
+	}
+	for _, target := range fd.grpmgr.NodeMap().IDs() {
+		fd.checkSuspicionQuorum(target)
+	}
+}
+
+func (fd *Fd) checkSuspicionQuorum(target grp.ID) {
+	by := fd.suspectedBy[target]
+	quorum := uint(len(by)) >= fd.grpmgr.Quorum()
+	if quorum && !fd.confirmed[target] {
+		fd.confirmed[target] = true
+		glog.V(2).Infof("suspicion of %v confirmed by %d replicas", target, len(by))
+		elog.Log(e.NewEventWithMetric(e.FailureHandlingQuorumSuspect, uint64(target.PaxosID)))
+		fd.publishConfirmedFdMsg(FdMsg{Suspect, target})
+	} else if !quorum && fd.confirmed[target] {
+		delete(fd.confirmed, target)
+		fd.publishConfirmedFdMsg(FdMsg{Restore, target})
+	}
+}
+
+func (fd *Fd) publishConfirmedFdMsg(msg FdMsg) {
+	for _, sub := range fd.confirmedSubscribers {
+		sub <- msg
+	}
+}
@@ -0,0 +1,126 @@
+package liveness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+)
+
+func newTestFd(t *testing.T, values map[string]string) (*Fd, chan interface{}) {
+	cfg := config.NewConfig()
+	for k, v := range values {
+		cfg.Set(k, v)
+	}
+	outB := make(chan interface{}, 16)
+	grpmgr := grp.NewGrpMgr(id, nmPaxos, false, false, nil)
+	return NewFd(id, grpmgr, *cfg, nil, outB, nil, nil), outB
+}
+
+func TestProbeRefuted(t *testing.T) {
+	fd, outB := newTestFd(t, map[string]string{"fdProbeHelpers": "2"})
+	target := grp.NewIDFromInt(4, 0)
+	now := time.Now()
+
+	fd.trySuspect(target, now)
+	req, ok := (<-outB).(ProbeRequest)
+	if !ok || req.Target != target || len(req.Helpers) != 2 {
+		t.Fatalf("got probe request %v, want one for %v with 2 helpers", req, target)
+	}
+	if fd.suspected[target] {
+		t.Fatalf("%v suspected before probe completed", target)
+	}
+
+	fd.handleProbeResponse(ProbeResponse{
+		From: req.Helpers[0], To: id, Target: target, Seq: req.Seq, Alive: true,
+	})
+	if len(fd.prober.pending) != 0 || fd.suspected[target] {
+		t.Errorf("%v suspected or still probed after helper heard from it", target)
+	}
+}
+
+func TestProbeConfirmed(t *testing.T) {
+	fd, outB := newTestFd(t, map[string]string{"fdProbeHelpers": "2"})
+	target := grp.NewIDFromInt(4, 0)
+	now := time.Now()
+
+	fd.trySuspect(target, now)
+	req := (<-outB).(ProbeRequest)
+	fd.handleProbeResponse(ProbeResponse{From: req.Helpers[0], To: id, Target: target, Seq: req.Seq})
+	if fd.suspected[target] {
+		t.Fatalf("%v suspected before all helpers replied", target)
+	}
+	fd.handleProbeResponse(ProbeResponse{From: req.Helpers[1], To: id, Target: target, Seq: req.Seq})
+	if !fd.suspected[target] {
+		t.Fatalf("%v not suspected after all helpers replied", target)
+	}
+
+	// No replies before the deadline also confirms the suspicion.
+	other := grp.NewIDFromInt(3, 0)
+	fd.trySuspect(other, now)
+	<-outB
+	fd.trySuspect(other, now.Add(time.Second))
+	if !fd.suspected[other] {
+		t.Errorf("%v not suspected after probe timeout", other)
+	}
+}
+
+func TestProbeTimer(t *testing.T) {
+	fd, outB := newTestFd(t, map[string]string{"fdProbeHelpers": "2", "fdProbeTimeout": "10ms"})
+	target := grp.NewIDFromInt(4, 0)
+
+	fd.trySuspect(target, time.Now())
+	<-outB
+	select {
+	case timeout := <-fd.probeTimeoutChan:
+		fd.handleProbeTimeout(timeout)
+	case <-time.After(time.Second):
+		t.Fatal("probe deadline not resolved by its timer")
+	}
+	if !fd.suspected[target] || len(fd.prober.pending) != 0 {
+		t.Errorf("%v not suspected after probe deadline", target)
+	}
+}
+
+func TestQuorumConfirm(t *testing.T) {
+	fd, _ := newTestFd(t, map[string]string{"fdQuorumConfirm": "true"})
+	sub := fd.SubscribeToConfirmedFdMsgs("test")
+	target := grp.NewIDFromInt(4, 0)
+
+	for i := 0; i < 3; i++ {
+		if len(sub) != 0 {
+			t.Fatalf("confirmed suspicion after %d of 3 replicas", i)
+		}
+		fd.handleSuspicion(Suspicion{From: grp.NewIDFromInt(int8(i), 0), Suspected: []grp.ID{target}})
+	}
+	if msg := <-sub; msg.Event != Suspect || msg.ID != target {
+		t.Fatalf("got %v, want suspect of %v", msg, target)
+	}
+
+	fd.handleSuspicion(Suspicion{From: id})
+	if msg := <-sub; msg.Event != Restore || msg.ID != target {
+		t.Errorf("got %v, want restore of %v", msg, target)
+	}
+}
+
+func TestSuspicionResync(t *testing.T) {
+	fd, outB := newTestFd(t, map[string]string{"fdQuorumConfirm": "true"})
+	target, gone := grp.NewIDFromInt(4, 0), grp.NewIDFromInt(4, 1)
+	fd.suspected[target] = true
+	fd.handleSuspicion(Suspicion{From: grp.NewIDFromInt(1, 0), Suspected: []grp.ID{target, gone}})
+	fd.handleSuspicion(Suspicion{From: gone, Suspected: []grp.ID{target}})
+
+	// Our suspicions are announced again on every timeout, even if
+	// nothing changed.
+	fd.resyncSuspicions()
+	if s, ok := (<-outB).(Suspicion); !ok || s.From != id || len(s.Suspected) != 1 || s.Suspected[0] != target {
+		t.Errorf("got %v, want a suspicion of %v", s, target)
+	}
+	if _, found := fd.suspectedBy[gone]; found {
+		t.Errorf("suspicions of %v kept after it left the group", gone)
+	}
+	if fd.suspectedBy[target][gone] {
+		t.Errorf("suspicion by %v kept after it left the group", gone)
+	}
+}
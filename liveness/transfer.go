@@ -0,0 +1,211 @@
+package liveness
+
+import (
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// How often the target of a leadership transfer checks whether it has
+// caught up with the old leader.
+const transferPollInterval = 10 * time.Millisecond
+
+// How often the target of a completed leadership transfer announces it
+// again, for the replicas that missed it.
+const transferAnnounceInterval = time.Second
+
+// transferState is the state of the latest leadership transfer. While the
+// target is catching up, done is false and ticker polls its progress. Once
+// done, the ticker of the target announces the transfer.
+type transferState struct {
+	to       grp.ID
+	epoch    uint64
+	adu      uint64
+	deadline time.Time
+	done     bool
+	ticker   *time.Ticker
+}
+
+// latestTransfer is the latest leadership transfer a replica has accepted,
+// read by other goroutines for state transfer. To is undefined once the
+// target has been suspected.
+type latestTransfer struct {
+	sync.Mutex
+	LeaderTransfer
+}
+
+type transferRequest struct {
+	to  grp.ID
+	err chan error
+}
+
+// enableTransfer allows the leader detector to send and receive leadership
+// transfers. The target of a transfer waits at most timeout to catch up with
+// the old leader before it takes over.
+func (mld *MonarchicalLD) enableTransfer(progress ProgressFunc, outB chan<- interface{},
+	dmx Registrar, timeout time.Duration) {
+	if mld.progress == nil {
+		mld.progress = progress
+	}
+	mld.outB = outB
+	mld.dmx = dmx
+	mld.transferTimeout = timeout
+}
+
+// TransferLeadership moves Paxos leadership to the replica to. Every replica
+// starts trusting to, so that the old leader stops proposing, except to
+// itself, which first catches up with the progress of the replica that
+// initiated the transfer. It then starts Phase 1 at once. The transfer holds
+// until to is suspected. Transfers are ordered by epoch, so that replicas
+// receiving the announcements of the target, or the latest transfer by
+// state transfer, agree on the latest one.
+func (mld *MonarchicalLD) TransferLeadership(to grp.ID) error {
+	req := transferRequest{to: to, err: make(chan error, 1)}
+	mld.transferReqs <- req
+	return <-req.err
+}
+
+func (mld *MonarchicalLD) handleTransferRequest(to grp.ID) error {
+	if mld.outB == nil {
+		return ErrTransferUnavailable
+	}
+	if mld.suspected[to] || !mld.isProposer(to) {
+		return ErrTransferTarget
+	}
+	glog.V(1).Infof("transferring leadership to %v", to)
+	mld.outB <- LeaderTransfer{
+		From:  mld.grpmgr.GetID(),
+		To:    to,
+		Adu:   mld.progress(),
+		Epoch: mld.LatestTransfer().Epoch + 1,
+	}
+	return nil
+}
+
+// LatestTransfer returns the latest leadership transfer accepted by the
+// replica. Its To is undefined if there is none, or if its target has been
+// suspected.
+func (mld *MonarchicalLD) LatestTransfer() LeaderTransfer {
+	mld.latest.Lock()
+	defer mld.latest.Unlock()
+	return mld.latest.LeaderTransfer
+}
+
+// SetLatestTransfer hands a leadership transfer received by state transfer
+// to the leader detector, which accepts it unless it has seen a later one.
+func (mld *MonarchicalLD) SetLatestTransfer(t LeaderTransfer) {
+	t.From, t.Adu = grp.UndefinedID(), 0
+	mld.transferChan <- t
+}
+
+// supersedes reports whether t is later than the latest transfer accepted.
+// Transfers of the same epoch, started at once by different replicas, are
+// ordered by the Paxos id of their target.
+func (mld *MonarchicalLD) supersedes(t LeaderTransfer) bool {
+	latest := mld.LatestTransfer()
+	if t.Epoch != latest.Epoch {
+		return t.Epoch > latest.Epoch
+	}
+	return t.To != grp.UndefinedID() && latest.To != grp.UndefinedID() &&
+		t.To.PaxosID > latest.To.PaxosID
+}
+
+func (mld *MonarchicalLD) setLatestTransfer(t LeaderTransfer) {
+	mld.latest.Lock()
+	mld.latest.LeaderTransfer = t
+	mld.latest.Unlock()
+}
+
+func (mld *MonarchicalLD) isProposer(id grp.ID) bool {
+	for _, pid := range mld.grpmgr.NodeMap().ProposerIDs() {
+		if pid == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (mld *MonarchicalLD) handleTransfer(msg LeaderTransfer) {
+	if !mld.supersedes(msg) {
+		return
+	}
+	mld.stopTransferTicker()
+	mld.transfer = nil
+	mld.setLatestTransfer(msg)
+	if msg.To == grp.UndefinedID() || mld.suspected[msg.To] {
+		mld.checkLeaders()
+		return
+	}
+	glog.V(2).Infof("%v transfers leadership to %v in epoch %d", msg.From, msg.To, msg.Epoch)
+	mld.transfer = &transferState{to: msg.To, epoch: msg.Epoch, adu: msg.Adu}
+	if msg.To == mld.grpmgr.GetID() && mld.progress() < msg.Adu {
+		glog.V(2).Infof("catching up to slot %d before taking over", msg.Adu)
+		mld.transfer.deadline = time.Now().Add(mld.transferTimeout)
+		mld.transfer.ticker = time.NewTicker(transferPollInterval)
+		return
+	}
+	mld.completeTransfer()
+}
+
+// handleTransferTick polls the progress of the target of a transfer while
+// it catches up, and announces the transfer once it has taken over.
+func (mld *MonarchicalLD) handleTransferTick() {
+	t := mld.transfer
+	if t.done {
+		mld.outB <- LeaderTransfer{From: t.to, To: t.to, Epoch: t.epoch}
+		return
+	}
+	if mld.progress() < t.adu && time.Now().Before(t.deadline) {
+		return
+	}
+	if mld.progress() < t.adu {
+		glog.Warningf("taking over leadership before catching up to slot %d", t.adu)
+	}
+	mld.stopTransferTicker()
+	mld.completeTransfer()
+}
+
+func (mld *MonarchicalLD) completeTransfer() {
+	mld.transfer.done = true
+	if mld.transfer.to == mld.grpmgr.GetID() && mld.outB != nil {
+		mld.transfer.ticker = time.NewTicker(transferAnnounceInterval)
+	}
+	mld.checkLeaders()
+}
+
+// abortTransferTo forgets the latest leadership transfer if its target is id.
+// Its epoch is kept, so that announcements of it are ignored.
+func (mld *MonarchicalLD) abortTransferTo(id grp.ID) {
+	if mld.transfer != nil && mld.transfer.to == id {
+		mld.stopTransferTicker()
+		mld.transfer = nil
+		t := mld.LatestTransfer()
+		t.To = grp.UndefinedID()
+		mld.setLatestTransfer(t)
+	}
+}
+
+func (mld *MonarchicalLD) transferTicker() *time.Ticker {
+	if mld.transfer == nil {
+		return nil
+	}
+	return mld.transfer.ticker
+}
+
+func (mld *MonarchicalLD) stopTransferTicker() {
+	if t := mld.transferTicker(); t != nil {
+		t.Stop()
+		mld.transfer.ticker = nil
+	}
+}
+
+// tickerChan returns the channel of t, or nil if t is nil.
+func tickerChan(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
@@ -0,0 +1,51 @@
+package net
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	backoffFactor = 2
+	backoffJitter = 0.2
+)
+
+// A backoff computes exponentially increasing waits between connection
+// attempts. Each wait is randomized by up to backoffJitter in either
+// direction, so that replicas that lost their connections at the same time
+// do not redial in lockstep.
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+	rnd      *rand.Rand
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if max < min {
+		max = min
+	}
+	return &backoff{
+		min: min,
+		max: max,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the wait before the next connection attempt.
+func (b *backoff) next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else {
+		b.cur *= backoffFactor
+		if b.cur > b.max {
+			b.cur = b.max
+		}
+	}
+	jitter := (b.rnd.Float64()*2 - 1) * backoffJitter * float64(b.cur)
+	return b.cur + time.Duration(jitter)
+}
+
+// reset makes the next wait start at the minimum again.
+func (b *backoff) reset() {
+	b.cur = 0
+}
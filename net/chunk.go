@@ -0,0 +1,71 @@
+package net
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+var errChunkOutOfOrder = errors.New("chunk received out of order")
+
+// chunkSize is the maximum number of payload bytes in a Chunk.
+var chunkSize = 64 * 1024
+
+// SetChunkSize sets the maximum number of bytes of a bulk message that is
+// sent as a single chunk.
+func SetChunkSize(size int) {
+	if size > 0 {
+		chunkSize = size
+	}
+}
+
+// splitMsg gob encodes msg and splits the encoding into chunks of at most
+// chunkSize bytes.
+func splitMsg(msg interface{}) ([][]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&msg); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	chunks := make([][]byte, 0, len(data)/chunkSize+1)
+	for len(data) > chunkSize {
+		chunks = append(chunks, data[:chunkSize])
+		data = data[chunkSize:]
+	}
+	return append(chunks, data), nil
+}
+
+// An assembler reassembles bulk messages from the chunks received on a
+// connection. The chunks of a message are sent back to back on the
+// connection, so only one message is assembled at a time.
+type assembler struct {
+	msgID uint64
+	index uint32
+	buf   bytes.Buffer
+}
+
+// add adds a chunk to the message being assembled. The message is returned
+// once its last chunk has been added, otherwise nil is returned.
+func (a *assembler) add(c Chunk) (interface{}, error) {
+	if c.Index == 0 {
+		a.msgID = c.MsgID
+		a.index = 0
+		a.buf.Reset()
+	} else if c.MsgID != a.msgID || c.Index != a.index+1 {
+		a.buf.Reset()
+		return nil, errChunkOutOfOrder
+	}
+	a.index = c.Index
+	a.buf.Write(c.Data)
+	if !c.Last {
+		return nil, nil
+	}
+
+	var msg interface{}
+	err := gob.NewDecoder(&a.buf).Decode(&msg)
+	a.buf.Reset()
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
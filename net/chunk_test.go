@@ -0,0 +1,60 @@
+package net
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	defer SetChunkSize(chunkSize)
+	SetChunkSize(16)
+
+	msg := Chunk{MsgID: 7, Data: bytes.Repeat([]byte("goxos"), 20)}
+	parts, err := splitMsg(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 2 {
+		t.Fatalf("got %d chunks, want several", len(parts))
+	}
+
+	var a assembler
+	for i, data := range parts {
+		got, err := a.add(Chunk{MsgID: 1, Index: uint32(i), Last: i == len(parts)-1, Data: data})
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+		if i < len(parts)-1 {
+			if got != nil {
+				t.Fatalf("chunk %d: message returned before last chunk", i)
+			}
+			continue
+		}
+		c, ok := got.(Chunk)
+		if !ok || c.MsgID != msg.MsgID || !bytes.Equal(c.Data, msg.Data) {
+			t.Errorf("reassembled %v, want %v", got, msg)
+		}
+	}
+}
+
+func TestChunkOutOfOrder(t *testing.T) {
+	var a assembler
+	if _, err := a.add(Chunk{MsgID: 1, Index: 0, Data: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.add(Chunk{MsgID: 1, Index: 2, Data: []byte{2}}); err != errChunkOutOfOrder {
+		t.Errorf("got error %v, want %v", err, errChunkOutOfOrder)
+	}
+}
+
+func TestClassOf(t *testing.T) {
+	if c := ClassOf(Packet{}); c != ControlClass {
+		t.Errorf("got class %v for unclassified message, want %v", c, ControlClass)
+	}
+	SetClass(IDResponse{}, BulkClass)
+	defer delete(classes, reflect.TypeOf(IDResponse{}))
+	if c := ClassOf(IDResponse{}); c != BulkClass {
+		t.Errorf("got class %v, want %v", c, BulkClass)
+	}
+}
@@ -0,0 +1,60 @@
+package net
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/relab/goxos/liveness"
+)
+
+// MsgClass decides how a message is queued for sending to another replica.
+// Every connection has one queue per class. Liveness messages are always
+// sent first, then control messages. Bulk messages are split into chunks,
+// and queued liveness and control messages are sent between the chunks.
+// Bulk messages may therefore be overtaken by messages sent after them.
+type MsgClass uint8
+
+const (
+	ControlClass MsgClass = iota
+	LivenessClass
+	BulkClass
+)
+
+var msgClasses = [...]string{
+	"control",
+	"liveness",
+	"bulk",
+}
+
+func (mc MsgClass) String() string {
+	if int(mc) < len(msgClasses) {
+		return msgClasses[mc]
+	}
+	return fmt.Sprintf("MsgClass(%d)", mc)
+}
+
+// Classes for message types other than ControlClass. Only written from
+// package init functions, like the gob type registry.
+var classes = make(map[reflect.Type]MsgClass)
+
+func init() {
+	SetClass(liveness.Heartbeat{}, LivenessClass)
+	SetClass(liveness.ProbeRequest{}, LivenessClass)
+	SetClass(liveness.ProbeResponse{}, LivenessClass)
+	SetClass(liveness.Suspicion{}, LivenessClass)
+	SetClass(liveness.LeaderStatus{}, LivenessClass)
+	SetClass(liveness.LeaderTransfer{}, LivenessClass)
+}
+
+// SetClass sets the class of every message with the same type as msg. It
+// should be called from the init function of the package that defines the
+// message type.
+func SetClass(msg interface{}, class MsgClass) {
+	classes[reflect.TypeOf(msg)] = class
+}
+
+// ClassOf returns the class of msg. Messages of types without a class set
+// are ControlClass.
+func ClassOf(msg interface{}) MsgClass {
+	return classes[reflect.TypeOf(msg)]
+}
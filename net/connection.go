@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
@@ -63,6 +65,25 @@ func (c *Connection) sendID(id grp.ID) error {
 	return nil
 }
 
+// handshake identifies us to the replica at the other end of the connection
+// and waits for it to accept our id.
+func (c *Connection) handshake(callerID grp.ID) error {
+	if err := c.sendID(callerID); err != nil {
+		return err
+	}
+
+	idresp, err := c.waitForIDResp()
+	if err != nil {
+		return err
+	}
+
+	if !idresp.Accepted {
+		return fmt.Errorf("id rejected: %v", idresp.Error)
+	}
+
+	return nil
+}
+
 func (c *Connection) waitForID() (grp.ID, error) {
 	var idexch IDExchange
 	if err := c.Dec.Decode(&idexch); err != nil {
@@ -101,8 +122,12 @@ type GxConnection struct {
 	*Connection
 	id            grp.ID
 	dmx           Demuxer
-	outgoing      chan interface{}
+	liveness      chan interface{} // Outgoing LivenessClass messages
+	control       chan interface{} // Outgoing ControlClass messages
+	bulk          chan interface{} // Outgoing BulkClass messages
 	heartbeatChan chan<- grp.ID
+	done          chan struct{} // Closed when the connection is closed
+	closeOnce     sync.Once
 }
 
 // Create a new GxConnection. The low-level connection as well as the Goxos id and Demuxer
@@ -112,19 +137,44 @@ func NewGxConnection(conn *Connection, id grp.ID, dmx Demuxer) *GxConnection {
 		Connection:    conn,
 		id:            id,
 		dmx:           dmx,
-		outgoing:      make(chan interface{}, 128),
+		liveness:      make(chan interface{}, 16),
+		control:       make(chan interface{}, 128),
+		bulk:          make(chan interface{}, 16),
 		heartbeatChan: heartbeatChan,
+		done:          make(chan struct{}),
 	}
 }
 
+// Close the connection. Closing an already closed GxConnection has no
+// effect.
+func (gc *GxConnection) Close() (err error) {
+	gc.closeOnce.Do(func() {
+		if gc.Connection != nil {
+			err = gc.Connection.Close()
+		}
+		close(gc.done)
+	})
+	return err
+}
+
 func (gc *GxConnection) handleIn() {
 	glog.V(2).Infof("%v: starting to handle incomming", gc)
 	var err error
 	var msg interface{}
+	var chunks assembler
 	defer gc.Close()
 	for {
 		if err = gc.Dec.Decode(&msg); err == nil {
-			gc.dmx.HandleMessage(msg)
+			if chunk, isChunk := msg.(Chunk); isChunk {
+				var cerr error
+				if msg, cerr = chunks.add(chunk); cerr != nil {
+					glog.Errorf("%v: dropping bulk message: %v", gc, cerr)
+				}
+			}
+			if msg != nil {
+				gc.dmx.HandleMessage(msg)
+			}
+			trace.RecordAlive(gc.id)
 			gc.heartbeatChan <- gc.id
 		}
 		if err == io.EOF {
@@ -138,34 +188,108 @@ func (gc *GxConnection) handleIn() {
 
 func (gc *GxConnection) handleOut() {
 	glog.V(2).Infof("%v: starting to handle outgoing", gc)
-	var err error
-	var msg interface{}
+	var chunks [][]byte // Remaining chunks of the bulk message being sent
+	var msgID uint64
+	var index uint32
 	for {
-		select {
-		case msg = <-gc.outgoing:
-			if err = gc.Write(msg); err == nil {
-				continue
-			}
-			if err == io.EOF {
-				glog.V(2).Infof("%v: connection closed")
-				return
-			}
-			if ne, ok := err.(net.Error); ok && ne.Temporary() {
-				glog.V(2).Infof("%v: tmp error: %v", ne)
-				continue
+		msg, open := gc.nextOut(len(chunks) > 0)
+		if !open {
+			return
+		}
+		if msg == nil {
+			msg = Chunk{MsgID: msgID, Index: index, Last: len(chunks) == 1, Data: chunks[0]}
+			chunks = chunks[1:]
+			index++
+		} else if ClassOf(msg) == BulkClass {
+			var err error
+			if chunks, err = splitMsg(msg); err != nil {
+				glog.Errorf("%v: dropping %T: %v", gc, msg, err)
 			}
-			glog.Errorf("%v: closing due to: %v", gc, err)
+			msgID++
+			index = 0
+			continue
+		}
+		if !gc.write(msg) {
 			return
 		}
 	}
 }
 
-func (gc *GxConnection) Outgoing() chan<- interface{} {
-	return gc.outgoing
+// nextOut returns the next message to send, by priority. If sendingBulk is
+// true and no liveness or control messages are queued, nil is returned so
+// that the next chunk of the current bulk message is sent. Returns false if
+// the connection is closed.
+func (gc *GxConnection) nextOut(sendingBulk bool) (interface{}, bool) {
+	select {
+	case msg := <-gc.liveness:
+		return msg, true
+	default:
+	}
+	select {
+	case msg := <-gc.liveness:
+		return msg, true
+	case msg := <-gc.control:
+		return msg, true
+	default:
+	}
+	if sendingBulk {
+		return nil, true
+	}
+	select {
+	case msg := <-gc.liveness:
+		return msg, true
+	case msg := <-gc.control:
+		return msg, true
+	case msg := <-gc.bulk:
+		return msg, true
+	case <-gc.done:
+		return nil, false
+	}
+}
+
+// write writes msg to the connection. Returns false if the connection was
+// closed as a result.
+func (gc *GxConnection) write(msg interface{}) bool {
+	err := gc.Write(msg)
+	if err == nil {
+		return true
+	}
+	if err == io.EOF {
+		glog.V(2).Infof("%v: connection closed")
+		gc.Close()
+		return false
+	}
+	if ne, ok := err.(net.Error); ok && ne.Temporary() {
+		glog.V(2).Infof("%v: tmp error: %v", ne)
+		return true
+	}
+	glog.Errorf("%v: closing due to: %v", gc, err)
+	gc.Close()
+	return false
+}
+
+// Enqueue queues msg for sending on the queue of its class. Returns false,
+// and drops the message, if the queue is full.
+func (gc *GxConnection) Enqueue(msg interface{}) bool {
+	var queue chan interface{}
+	switch ClassOf(msg) {
+	case LivenessClass:
+		queue = gc.liveness
+	case BulkClass:
+		queue = gc.bulk
+	default:
+		queue = gc.control
+	}
+	select {
+	case queue <- msg:
+		return true
+	default:
+		return false
+	}
 }
 
 // Returns a string-based representation of the GxConnection.
-func (gc GxConnection) String() string {
+func (gc *GxConnection) String() string {
 	return fmt.Sprintf("connection %v (%v)", gc.id, gc.addr)
 }
 
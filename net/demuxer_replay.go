@@ -0,0 +1,44 @@
+package net
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// A ReplayDemuxer delivers messages to registered channels like the
+// TcpDemuxer, but does not listen for connections from other replicas. It is
+// used when a replica is fed the messages of a recorded trace.
+type ReplayDemuxer struct {
+	channels    map[msgtype][]reflect.Value
+	stopCheckIn *sync.WaitGroup
+}
+
+// NewReplayDemuxer creates a new ReplayDemuxer.
+func NewReplayDemuxer(stopCheckIn *sync.WaitGroup) *ReplayDemuxer {
+	return &ReplayDemuxer{
+		channels:    make(map[msgtype][]reflect.Value),
+		stopCheckIn: stopCheckIn,
+	}
+}
+
+// Start the ReplayDemuxer. There are no connections to handle.
+func (dmx *ReplayDemuxer) Start() {
+	glog.V(1).Info("starting replay demuxer")
+}
+
+// Stop the ReplayDemuxer.
+func (dmx *ReplayDemuxer) Stop() {
+	dmx.stopCheckIn.Done()
+}
+
+// Register channel for receiving messages of the type defined by the channel
+func (dmx *ReplayDemuxer) RegisterChannel(ch interface{}) {
+	registerChannel(dmx.channels, ch)
+}
+
+// Delegate handling of a replayed message to a priori registered channels.
+func (dmx *ReplayDemuxer) HandleMessage(msg interface{}) {
+	dispatch(dmx.channels, msg)
+}
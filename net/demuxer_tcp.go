@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
@@ -113,36 +114,41 @@ func (dmx *TcpDemuxer) Stop() {
 
 // Register channel for receiving messages of the type defined by the channel
 func (dmx *TcpDemuxer) RegisterChannel(ch interface{}) {
-	chVal := reflect.ValueOf(ch)
+	registerChannel(dmx.channels, ch)
+}
+
+// Delegate handling of specific messages to a priori registered channels
+func (dmx *TcpDemuxer) HandleMessage(msg interface{}) {
+	trace.RecordMessage(msg)
+	dispatch(dmx.channels, msg)
+}
 
+func registerChannel(channels map[msgtype][]reflect.Value, ch interface{}) {
+	chVal := reflect.ValueOf(ch)
 	// TODO: This should return an error instead?
 	if chVal.Kind() != reflect.Chan {
 		glog.Fatal("argument 'ch' to RegisterChannel must be a channel")
 	}
-
 	// Extract the message type supported by the provided channel
 	chType := chVal.Type().Elem()
-	if _, present := dmx.channels[chType]; !present {
+	if _, present := channels[chType]; !present {
 		//log.Panicf("demuxer: multiple receivers registered for message type: %s", chType)
-		dmx.channels[chType] = make([]reflect.Value, 0)
+		channels[chType] = make([]reflect.Value, 0)
 	}
-
 	// The message/channel type is used as map key for channels
-	dmx.channels[chType] = append(dmx.channels[chType], chVal)
+	channels[chType] = append(channels[chType], chVal)
 	glog.V(2).Infof("registered channel for: %s", chType)
 }
 
-// Delegate handling of specific messages to a priori registered channels
-func (dmx *TcpDemuxer) HandleMessage(msg interface{}) {
+func dispatch(channels map[msgtype][]reflect.Value, msg interface{}) {
 	// Inspect type of msg
 	mx := reflect.ValueOf(msg)
 	msgType := mx.Type()
 	if glog.V(4) {
 		glog.Infof("received message of type: %s", msgType)
 	}
-
 	// Lookup channel on which to send the given message type
-	if chVals, ok := dmx.channels[msgType]; ok {
+	if chVals, ok := channels[msgType]; ok {
 		for _, ch := range chVals {
 			ch.Send(mx)
 		}
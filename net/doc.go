@@ -24,5 +24,12 @@ NB: The channels should all be registered before the networking subsystem is sta
 channels should be registered after. This is due to the fact that the GxConnection goroutines pass
 messages to the Demuxer, and if channels are registered after network start-up, bad things could
 happen.
+
+The Sender runs a supervisor for each replica with a higher paxos id than its own. The supervisor
+redials the replica with exponential backoff and jitter whenever the connection is lost, so
+replicas can be started in any order and recover from partitions without being restarted.
+Messages to a replica we are not connected to are dropped or queued, as decided by the
+`reconnectQueuePolicy` config value. The state of every peer connection is available through
+PeerStates.
 */
 package net
@@ -1,9 +1,15 @@
 package net
 
 import (
+	"encoding/gob"
+
 	"github.com/relab/goxos/grp"
 )
 
+func init() {
+	gob.Register(Chunk{})
+}
+
 // A Packet contains a message, as well as the destination id of the replica. Used
 // to send a unicast message to a replica.
 type Packet struct {
@@ -21,3 +27,12 @@ type IDResponse struct {
 	Accepted bool
 	Error    string
 }
+
+// A Chunk carries part of the gob encoding of a bulk message. The chunks of a
+// message are numbered from zero, and the last one has Last set.
+type Chunk struct {
+	MsgID uint64
+	Index uint32
+	Last  bool
+	Data  []byte
+}
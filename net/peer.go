@@ -0,0 +1,156 @@
+package net
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// PeerState describes the state of the connection to another replica.
+type PeerState uint8
+
+const (
+	PeerDisconnected PeerState = iota
+	PeerConnecting
+	PeerConnected
+)
+
+var peerStates = [...]string{
+	"disconnected",
+	"connecting",
+	"connected",
+}
+
+func (ps PeerState) String() string {
+	if int(ps) < len(peerStates) {
+		return peerStates[ps]
+	}
+	return fmt.Sprintf("PeerState(%d)", ps)
+}
+
+// QueuePolicy decides what the Sender does with messages to a replica it
+// is not connected to.
+type QueuePolicy uint8
+
+const (
+	// Drop messages to disconnected replicas.
+	DropWhileDisconnected QueuePolicy = iota
+	// Queue messages to disconnected replicas, and send them when the
+	// connection is reestablished.
+	QueueWhileDisconnected
+)
+
+// PeerStatus is a snapshot of the connection state of another replica.
+type PeerStatus struct {
+	ID        grp.ID    // Id of the connection, or last known id
+	State     PeerState // Current state
+	Since     time.Time // Time of the last state change
+	Attempts  uint      // Failed connection attempts since last connected
+	Queued    int       // Messages waiting to be sent
+	LastError error     // Error of the last failed connection attempt
+}
+
+func (ps PeerStatus) String() string {
+	return fmt.Sprintf("%v %v since %v (attempts: %d, queued: %d, last error: %v)",
+		ps.ID, ps.State, ps.Since.Format("15:04:05.000"), ps.Attempts, ps.Queued, ps.LastError)
+}
+
+// A peer holds the connection state of another replica. Peers are only
+// accessed while holding connMu.
+type peer struct {
+	status  PeerStatus
+	pending []interface{}
+}
+
+// getPeer returns the peer with the given paxos id, creating it if needed.
+// Must be called with connMu held.
+func getPeer(pid grp.PaxosID) *peer {
+	p, found := peers[pid]
+	if !found {
+		p = &peer{status: PeerStatus{
+			ID:    grp.ID{PaxosID: pid},
+			Since: time.Now(),
+		}}
+		peers[pid] = p
+	}
+	return p
+}
+
+// setState records a state change for the peer. Must be called with connMu
+// held.
+func (p *peer) setState(id grp.ID, state PeerState) {
+	p.status.ID = id
+	if p.status.State == state {
+		return
+	}
+	glog.V(1).Infof("peer %v: %v -> %v", id, p.status.State, state)
+	p.status.State = state
+	p.status.Since = time.Now()
+	if state == PeerConnected {
+		p.status.Attempts = 0
+		p.status.LastError = nil
+	}
+}
+
+// failed records a failed connection attempt. Must be called with connMu
+// held.
+func (p *peer) failed(err error) {
+	p.status.Attempts++
+	p.status.LastError = err
+}
+
+// enqueue queues a message for the peer, dropping the oldest queued message
+// if the queue is full. Must be called with connMu held.
+func (p *peer) enqueue(msg interface{}, max int) {
+	if max <= 0 {
+		return
+	}
+	if len(p.pending) >= max {
+		if glog.V(4) {
+			glog.Infof("queue for peer %v full, dropping oldest message", p.status.ID)
+		}
+		p.pending = p.pending[1:]
+	}
+	p.pending = append(p.pending, msg)
+}
+
+// flush hands every queued message to the connection. Messages that do not
+// fit in the outgoing queues of the connection are dropped. Must be called
+// with connMu held.
+func (p *peer) flush(gc *GxConnection) {
+	dropped := 0
+	for _, msg := range p.pending {
+		if !gc.Enqueue(msg) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		glog.Warningf("%v: outgoing queues full, dropped %d queued messages", gc, dropped)
+	}
+	p.pending = nil
+}
+
+// PeerStates returns the connection state of every replica we have been, or
+// have tried to be, connected to, ordered by paxos id.
+func PeerStates() []PeerStatus {
+	connMu.Lock()
+	defer connMu.Unlock()
+	states := make([]PeerStatus, 0, len(peers))
+	for _, p := range peers {
+		status := p.status
+		status.Queued = len(p.pending)
+		states = append(states, status)
+	}
+	sort.Sort(byPaxosID(states))
+	return states
+}
+
+type byPaxosID []PeerStatus
+
+func (s byPaxosID) Len() int           { return len(s) }
+func (s byPaxosID) Less(i, j int) bool { return s[i].ID.PaxosID < s[j].ID.PaxosID }
+func (s byPaxosID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,68 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/grp"
+)
+
+func TestBackoff(t *testing.T) {
+	min, max := 100*time.Millisecond, time.Second
+	b := newBackoff(min, max)
+	prev := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		wait := b.next()
+		if wait < time.Duration(float64(min)*(1-backoffJitter)) ||
+			wait > time.Duration(float64(max)*(1+backoffJitter)) {
+			t.Fatalf("attempt %d: wait %v out of bounds", i, wait)
+		}
+		if b.cur < prev {
+			t.Fatalf("attempt %d: backoff decreased from %v to %v", i, prev, b.cur)
+		}
+		prev = b.cur
+	}
+	if b.cur != max {
+		t.Errorf("backoff did not reach max, got %v", b.cur)
+	}
+	b.reset()
+	if b.next(); b.cur != min {
+		t.Errorf("backoff not reset to min, got %v", b.cur)
+	}
+}
+
+func TestPeerQueue(t *testing.T) {
+	id := grp.NewIDFromInt(5, 0)
+	gc := NewGxConnection(nil, id, dmx)
+
+	connMu.Lock()
+	p := getPeer(id.PaxosID)
+	for i := 0; i < 5; i++ {
+		p.enqueue(i, 3)
+	}
+	p.setState(id, PeerConnected)
+	p.flush(gc)
+	connMu.Unlock()
+
+	for want := 2; want < 5; want++ {
+		select {
+		case got := <-gc.control:
+			if got != want {
+				t.Errorf("got queued message %v, want %v", got, want)
+			}
+		default:
+			t.Fatalf("queued message %v not flushed", want)
+		}
+	}
+
+	for _, status := range PeerStates() {
+		if status.ID.PaxosID != id.PaxosID {
+			continue
+		}
+		if status.State != PeerConnected || status.Queued != 0 {
+			t.Errorf("got peer status %v, want connected with empty queue", status)
+		}
+		return
+	}
+	t.Errorf("peer %v missing from peer states", id)
+}
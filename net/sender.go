@@ -2,9 +2,12 @@ package net
 
 import (
 	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
 	"github.com/relab/goxos/liveness"
 
@@ -25,6 +28,7 @@ var (
 // network.
 type Sender struct {
 	id            grp.ID
+	idMu          sync.Mutex // Guards id, which is read by the supervisors
 	connected     bool
 	grpmgr        grp.GroupManager
 	grpSubscriber grp.Subscriber
@@ -37,13 +41,33 @@ type Sender struct {
 	outU          <-chan Packet      // Unicast channel
 	dmx           Demuxer
 	stopCheckIn   *sync.WaitGroup
+	halt          chan struct{} // Closed to stop the supervisors
+	supervised    map[grp.PaxosID]bool
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	policy        QueuePolicy
+	queueSize     int
+	drop          atomic.Value // Holds a dropFilter
 }
 
+// A dropFilter returns true for the messages the sender should drop.
+type dropFilter func(msg interface{}) bool
+
 // Create a new Sender for the given replica id. Also passed in are channels which the sender receives
 // messages from.
-func NewSender(id grp.ID, gm grp.GroupManager, outU <-chan Packet,
+func NewSender(cfg config.Config, id grp.ID, gm grp.GroupManager, outU <-chan Packet,
 	outB, outP, outA, outL <-chan interface{},
 	dmx Demuxer, stopCheckIn *sync.WaitGroup) (snd *Sender) {
+	policy := DropWhileDisconnected
+	switch strings.ToLower(cfg.GetString("reconnectQueuePolicy", config.DefReconnectQueuePolicy)) {
+	case "drop":
+	case "queue":
+		policy = QueueWhileDisconnected
+	default:
+		glog.Warningln("unknown reconnect queue policy, using",
+			config.DefReconnectQueuePolicy)
+	}
+
 	return &Sender{
 		id:          id,
 		connected:   false,
@@ -57,35 +81,26 @@ func NewSender(id grp.ID, gm grp.GroupManager, outU <-chan Packet,
 		outU:        outU,
 		dmx:         dmx,
 		stopCheckIn: stopCheckIn,
+		halt:        make(chan struct{}),
+		supervised:  make(map[grp.PaxosID]bool),
+		minBackoff:  cfg.GetDuration("reconnectMinBackoff", config.DefReconnectMinBackoff),
+		maxBackoff:  cfg.GetDuration("reconnectMaxBackoff", config.DefReconnectMaxBackoff),
+		policy:      policy,
+		queueSize:   cfg.GetInt("reconnectQueueSize", config.DefReconnectQueueSize),
 	}
 }
 
-// Start the initial connection phase, where the sender tries to connect to all other replicas in the system
-// with a higher id than ours. We wait for connections from all other replicas with ids less than or equal to
-// our own.
+// Start the initial connection phase, where the sender starts supervising the connections to all other
+// replicas in the system with a higher id than ours. We wait for connections from all other replicas with
+// ids less than or equal to our own.
 //
 // This function blocks until we establish connections to all replicas in the Goxos configuration.
+// Replicas that are not yet running are redialed until they are.
 func (snd *Sender) InitialConnect() {
 	glog.V(1).Info("starting initial-connect procedure")
-	for _, id := range snd.grpmgr.NodeMap().IDs() {
-		if id.PaxosID > snd.id.PaxosID {
-			node, found := snd.grpmgr.NodeMap().LookupNode(id)
-			if !found {
-				glog.Fatalln("initial-connect failed", errNodeNotFound)
-			}
-
-			conn, err := GxConnectTo(node, snd.id, id, snd.dmx)
-			if err != nil {
-				glog.Fatalln("initial-connect failed:", err)
-			}
-
-			connections[id.PaxosID] = conn
-			go conn.handleIn()
-			go conn.handleOut()
-		}
-	}
+	snd.superviseHigherPeers()
 
-	glog.V(2).Infoln("connected to the nodes that we should initiate connections to",
+	glog.V(2).Infoln("supervising the nodes that we should initiate connections to,",
 		"checking if we are fully connected")
 	for !snd.areWeFullyConnected() {
 		glog.V(2).Infoln("we are not fully connected, waiting", fullyConnectedWait)
@@ -99,6 +114,7 @@ func (snd *Sender) InitialConnect() {
 func (snd *Sender) Start() {
 	glog.V(1).Info("starting")
 	snd.grpSubscriber = snd.grpmgr.SubscribeToHold("sender")
+	snd.superviseHigherPeers()
 
 	go func() {
 		defer snd.stopCheckIn.Done()
@@ -127,6 +143,7 @@ func (snd *Sender) Start() {
 
 // Stop the Sender goroutine.
 func (snd *Sender) Stop() {
+	close(snd.halt)
 	snd.stop <- true
 }
 
@@ -134,7 +151,17 @@ func (snd *Sender) ResetChan() <-chan bool {
 	return snd.resetch
 }
 
+// SetDropFilter makes the sender drop every message for which drop returns
+// true, e.g. the votes of a replica that must no longer take part in
+// agreement. It may be called from any goroutine.
+func (snd *Sender) SetDropFilter(drop func(msg interface{}) bool) {
+	snd.drop.Store(dropFilter(drop))
+}
+
 func (snd *Sender) unicast(msg interface{}, id grp.ID) {
+	if drop, _ := snd.drop.Load().(dropFilter); drop != nil && drop(msg) {
+		return
+	}
 	if id == snd.id {
 		switch msg.(type) {
 		case liveness.Heartbeat:
@@ -147,16 +174,13 @@ func (snd *Sender) unicast(msg interface{}, id grp.ID) {
 
 	c, err := snd.getConnection(id.PaxosID)
 	if err != nil {
-		glog.Error(err)
+		snd.undeliverable(msg, id)
 		return
 	}
 
-	select {
-	case c.Outgoing() <- msg:
-		// Send was non-blocking
-	default:
+	if !c.Enqueue(msg) {
 		if glog.V(4) {
-			glog.Infof("Send on %v was blocking, dropping message", c)
+			glog.Infof("%v queue of %v full, dropping message", ClassOf(msg), c)
 		}
 	}
 }
@@ -189,7 +213,24 @@ func (snd *Sender) broadcastLearners(msg interface{}) {
 	snd.broadcast(msg, snd.grpmgr.NodeMap().LearnerIDs())
 }
 
+// undeliverable handles a message to a replica we are not connected to,
+// according to the queue policy. Heartbeats are never queued, since they
+// are stale by the time the connection is reestablished.
+func (snd *Sender) undeliverable(msg interface{}, id grp.ID) {
+	if _, isHb := msg.(liveness.Heartbeat); isHb || snd.policy == DropWhileDisconnected {
+		if glog.V(4) {
+			glog.Infof("not connected to %v, dropping message", id)
+		}
+		return
+	}
+	connMu.Lock()
+	getPeer(id.PaxosID).enqueue(msg, snd.queueSize)
+	connMu.Unlock()
+}
+
 func (snd *Sender) getConnection(pid grp.PaxosID) (*GxConnection, error) {
+	connMu.Lock()
+	defer connMu.Unlock()
 	c, found := connections[pid]
 	if !found {
 		return nil, errConnNotFound
@@ -198,6 +239,8 @@ func (snd *Sender) getConnection(pid grp.PaxosID) (*GxConnection, error) {
 }
 
 func (snd *Sender) areWeFullyConnected() bool {
+	connMu.Lock()
+	defer connMu.Unlock()
 	for _, id := range snd.grpmgr.NodeMap().IDs() {
 		if _, connFound := connections[id.PaxosID]; !connFound {
 			if id == snd.id {
@@ -215,5 +258,8 @@ func (snd *Sender) handleGrpHold(gp *sync.WaitGroup) {
 	gp.Done()
 	<-snd.grpSubscriber.ReleaseChan()
 	glog.V(2).Info("grpmgr release")
+	snd.idMu.Lock()
 	snd.id = snd.grpmgr.GetID()
+	snd.idMu.Unlock()
+	snd.superviseHigherPeers()
 }
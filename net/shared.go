@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/relab/goxos/grp"
@@ -12,12 +13,16 @@ import (
 )
 
 const (
-	reconnectWait   = 500 * time.Millisecond
-	maxConnAttempts = 120
+	dialTimeout       = 500 * time.Millisecond
+	connectMinBackoff = 100 * time.Millisecond
+	connectMaxBackoff = 2 * time.Second
+	maxConnWait       = 60 * time.Second
 )
 
 var (
+	connMu        sync.Mutex // Guards connections and peers
 	connections   = make(map[grp.PaxosID]*GxConnection)
+	peers         = make(map[grp.PaxosID]*peer)
 	heartbeatChan chan<- grp.ID
 )
 
@@ -27,33 +32,42 @@ func SetHeartbeatChan(hbChan chan<- grp.ID) {
 
 // Add a GxConnection to the connection map.
 func AddToConnections(gc *GxConnection, lrArEnabled bool) error {
+	connMu.Lock()
+	defer connMu.Unlock()
 	existingConn, found := connections[gc.id.PaxosID]
-	if !lrArEnabled {
-		connections[gc.id.PaxosID] = gc
-		go gc.handleIn()
-		go gc.handleOut()
-		if found {
-			existingConn.Close()
-		}
-		return nil
-	}
-
-	// LR/Arec enabled
-	if found {
+	if lrArEnabled && found {
 		comparison := gc.id.CompareTo(existingConn.id)
 		if comparison < 0 {
 			return errors.New("id for connection is lower than already present")
 		}
-		existingConn.Close()
 	}
 
 	connections[gc.id.PaxosID] = gc
 	go gc.handleIn()
 	go gc.handleOut()
+	go watchConnection(gc)
+	if found {
+		existingConn.Close()
+	}
+
+	p := getPeer(gc.id.PaxosID)
+	p.setState(gc.id, PeerConnected)
+	p.flush(gc)
 
 	return nil
 }
 
+// watchConnection removes gc from the connection map when it is closed.
+func watchConnection(gc *GxConnection) {
+	<-gc.done
+	connMu.Lock()
+	defer connMu.Unlock()
+	if connections[gc.id.PaxosID] == gc {
+		delete(connections, gc.id.PaxosID)
+		getPeer(gc.id.PaxosID).setState(gc.id, PeerDisconnected)
+	}
+}
+
 // Connect to another replica, and verify the ids are correct. Returns a GxConnection.
 func GxConnectTo(node grp.Node, callerID, calledID grp.ID,
 	dmx Demuxer) (*GxConnection, error) {
@@ -62,18 +76,27 @@ func GxConnectTo(node grp.Node, callerID, calledID grp.ID,
 		return nil, err
 	}
 
-	if err = conn.sendID(callerID); err != nil {
+	if err = conn.handshake(callerID); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	idresp, err := conn.waitForIDResp()
+	return NewGxConnection(conn, calledID, dmx), nil
+}
+
+// gxDial makes a single attempt at connecting to another replica, and
+// verifies the ids are correct. Returns a GxConnection.
+func gxDial(node grp.Node, callerID, calledID grp.ID,
+	dmx Demuxer) (*GxConnection, error) {
+	c, err := net.DialTimeout("tcp", node.PaxosAddr(), dialTimeout)
 	if err != nil {
 		return nil, err
 	}
 
-	if !idresp.Accepted {
-		errs := fmt.Sprintf("id rejected: %v", idresp.Error)
-		return nil, errors.New(errs)
+	conn := NewConnection(c)
+	if err = conn.handshake(callerID); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
 	return NewGxConnection(conn, calledID, dmx), nil
@@ -86,54 +109,46 @@ func ConnectToNode(node grp.Node) (*Connection, error) {
 }
 
 // Connect to another replica based on the address of the replica in the form
-// hostname:port. Returns a Connection.
+// hostname:port. Failed attempts are retried with exponential backoff for up
+// to maxConnWait. Returns a Connection.
 func ConnectToAddr(addr string) (*Connection, error) {
 	glog.V(2).Infoln("attempting to connect to", addr)
-	conn, err := net.Dial("tcp", addr)
-	for i := 0; err != nil; i++ {
+	b := newBackoff(connectMinBackoff, connectMaxBackoff)
+	deadline := time.Now().Add(maxConnWait)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, dialTimeout)
 		if err == nil {
-			break
+			return NewConnection(conn), nil
 		}
-		if i >= maxConnAttempts {
-			errmsg := fmt.Sprintf("network: unable to connect to addr %v"+
-				"(tried %v times)", addr, maxConnAttempts)
-			return nil, errors.New(errmsg)
+		wait := b.next()
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("network: unable to connect to addr %v "+
+				"(tried for %v): %v", addr, maxConnWait, err)
 		}
 		glog.Warningln("error on connecting to addr", addr, ":", err,
-			"waiting", reconnectWait, "before trying again")
-		time.Sleep(reconnectWait)
-		conn, err = net.Dial("tcp", addr)
+			"waiting", wait, "before trying again")
+		time.Sleep(wait)
 	}
-
-	return NewConnection(conn), nil
 }
 
 func GxConnectEphemeral(node grp.Node, callerID grp.ID) (*Connection, error) {
-	c, err := net.DialTimeout("tcp", node.PaxosAddr(), 500*time.Millisecond)
+	c, err := net.DialTimeout("tcp", node.PaxosAddr(), dialTimeout)
 	if err != nil {
 		return nil, err
 	}
 
 	conn := NewConnection(c)
-
-	if err = conn.sendID(callerID); err != nil {
+	if err = conn.handshake(callerID); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	idresp, err := conn.waitForIDResp()
-	if err != nil {
-		return nil, err
-	}
-
-	if !idresp.Accepted {
-		errs := fmt.Sprintf("id rejected: %v", idresp.Error)
-		return nil, errors.New(errs)
-	}
-
 	return conn, nil
 }
 
 func CheckConnections(conf []grp.ID) (notconn []grp.ID) {
+	connMu.Lock()
+	defer connMu.Unlock()
 	notconn = make([]grp.ID, 0)
 	for _, id := range conf {
 		if gc, ok := connections[id.PaxosID]; ok {
@@ -148,6 +163,8 @@ func CheckConnections(conf []grp.ID) (notconn []grp.ID) {
 }
 
 func UpdateConnID(oldID grp.ID, newEpoch grp.Epoch) bool {
+	connMu.Lock()
+	defer connMu.Unlock()
 	if gc, ok := connections[oldID.PaxosID]; ok {
 		if gc.id == oldID {
 			gc.id.Epoch = newEpoch
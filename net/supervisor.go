@@ -0,0 +1,125 @@
+package net
+
+import (
+	"time"
+
+	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// superviseHigherPeers starts a connection supervisor for every replica in
+// the current node map with a higher paxos id than ours, unless one is
+// already running. Replicas with lower paxos ids are responsible for
+// connecting to us.
+func (snd *Sender) superviseHigherPeers() {
+	myPid := snd.getID().PaxosID
+	for _, id := range snd.grpmgr.NodeMap().IDs() {
+		if id.PaxosID <= myPid || snd.supervised[id.PaxosID] {
+			continue
+		}
+		snd.supervised[id.PaxosID] = true
+		go snd.supervise(id.PaxosID)
+	}
+}
+
+// supervise keeps us connected to the replica with the given paxos id until
+// the Sender is stopped. Whenever we are not connected, the replica is
+// redialed with exponential backoff. A connection established by the other
+// replica is used instead of dialing, if present.
+func (snd *Sender) supervise(pid grp.PaxosID) {
+	glog.V(2).Infoln("supervising connection to paxos id", pid)
+	b := newBackoff(snd.minBackoff, snd.maxBackoff)
+	for {
+		gc, found := openConnection(pid)
+		if !found {
+			var err error
+			if gc, err = snd.dialPeer(pid); err != nil {
+				wait := b.next()
+				glog.Warningf("connecting to paxos id %v failed: %v, retrying in %v",
+					pid, err, wait)
+				select {
+				case <-time.After(wait):
+					continue
+				case <-snd.halt:
+					return
+				}
+			}
+		}
+
+		b.reset()
+		select {
+		case <-gc.done:
+			glog.Warningf("lost %v", gc)
+		case <-snd.halt:
+			return
+		}
+	}
+}
+
+// dialPeer connects to the replica with the given paxos id, as found in the
+// current node map, and adds the connection to the connection map.
+func (snd *Sender) dialPeer(pid grp.PaxosID) (*GxConnection, error) {
+	id, node, found := snd.lookupPeer(pid)
+	if !found {
+		return nil, errNodeNotFound
+	}
+
+	connMu.Lock()
+	getPeer(pid).setState(id, PeerConnecting)
+	connMu.Unlock()
+
+	gc, err := gxDial(node, snd.getID(), id, snd.dmx)
+	if err == nil {
+		err = AddToConnections(gc, snd.grpmgr.LrEnabled() || snd.grpmgr.ArEnabled())
+		if err != nil {
+			gc.Close()
+		}
+	}
+	if err != nil {
+		connMu.Lock()
+		p := getPeer(pid)
+		p.failed(err)
+		if _, connected := connections[pid]; !connected {
+			p.setState(id, PeerDisconnected)
+		}
+		connMu.Unlock()
+		return nil, err
+	}
+
+	return gc, nil
+}
+
+func (snd *Sender) lookupPeer(pid grp.PaxosID) (grp.ID, grp.Node, bool) {
+	nodeMap := snd.grpmgr.NodeMap()
+	for _, id := range nodeMap.IDs() {
+		if id.PaxosID == pid {
+			node, found := nodeMap.LookupNode(id)
+			return id, node, found
+		}
+	}
+	return grp.ID{}, grp.Node{}, false
+}
+
+func (snd *Sender) getID() grp.ID {
+	snd.idMu.Lock()
+	defer snd.idMu.Unlock()
+	return snd.id
+}
+
+// openConnection returns the connection to the replica with the given paxos
+// id, if we have one that is not closed.
+func openConnection(pid grp.PaxosID) (*GxConnection, bool) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	gc, found := connections[pid]
+	if !found {
+		return nil, false
+	}
+	select {
+	case <-gc.done:
+		return nil, false
+	default:
+		return gc, true
+	}
+}
@@ -54,6 +54,9 @@ type InitData struct {
 	Config           config.TransferWrapper
 	AppState         app.State
 	ReplacedNode     grp.Node
+	Streamed         bool
+	StateSize        int64
+	StateSum         []byte
 	applyStateResult chan error
 }
 
@@ -66,6 +69,7 @@ type InitListener struct {
 	listener           net.Listener
 	initState          initState
 	configAndStateChan chan InitData
+	spool              *spool // Only used by the connection transfering
 }
 
 func NewInitListener(ip string) *InitListener {
@@ -165,6 +169,15 @@ func (sl *InitListener) handleConnection(conn net.Conn) {
 		}
 	}
 
+	if initData.Streamed {
+		glog.V(2).Infof("receiving streamed state of %d bytes", initData.StateSize)
+		if err = sl.receiveState(connection, &initData); err != nil {
+			glog.Errorln("receiving streamed state from", connection, "failed:", err)
+			sl.setState(Listening)
+			return
+		}
+	}
+
 	glog.V(2).Info("sending init data to goxos")
 	initData.applyStateResult = make(chan error)
 	sl.configAndStateChan <- initData
@@ -3,6 +3,8 @@ package nodeinit
 import (
 	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/relab/goxos/app"
 	"github.com/relab/goxos/config"
@@ -23,55 +25,102 @@ const (
 	AReconfNode
 )
 
+// InitNode transfers the configuration and application state to the new
+// node rn. A state streamed to a file is sent in chunks, and its transfer is
+// resumed if the connection fails.
 func InitNode(nt NodeType, rn grp.Node, id grp.ID,
 	config config.TransferWrapper, appState app.State) error {
 
 	elog.Log(e.NewEvent(e.FailureHandlingInitStart))
 
+	treq := TransferRequest{ID: id, Config: config, AppState: appState}
+	if appState.File != "" {
+		size, sum, err := fileSum(appState.File)
+		if err != nil {
+			return err
+		}
+		treq.AppState.File = ""
+		treq.Streamed, treq.StateSize, treq.StateSum = true, size, sum
+	}
+
+	for attempt := 1; ; attempt++ {
+		resumable, err := initNode(rn, treq, appState.File)
+		if err == nil {
+			break
+		}
+		if !resumable || attempt == transferAttempts {
+			return err
+		}
+		glog.Warningf("state transfer to %v failed, resuming: %v", rn.IP, err)
+		time.Sleep(transferBackoff)
+	}
+
+	glog.V(2).Info("node responded with init success")
+	elog.Log(e.NewEvent(e.FailureHandlingInitDone))
+
+	return nil
+}
+
+// initNode makes one attempt at initializing rn. It reports whether a failed
+// attempt may be resumed.
+func initNode(rn grp.Node, treq TransferRequest, stateFile string) (bool, error) {
 	conn, err := net.ConnectToAddr(rn.IP + ":" + defaultActivationPort)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	defer conn.Close()
 
 	glog.V(2).Info("init request")
 	if err = conn.Enc.Encode(InitRequest{}); err != nil {
-		return err
+		return false, err
 	}
 
 	glog.V(2).Info("reading init response")
 	var iresp InitResponse
 	if err := conn.Dec.Decode(&iresp); err != nil {
-		return err
+		return false, err
 	}
 
 	if iresp.Ack {
 		glog.V(2).Info("replacer node responded available for init")
 	} else {
-		return fmt.Errorf("node could not be started due to state (%v)", iresp.State)
+		return false, fmt.Errorf("node could not be started due to state (%v)", iresp.State)
 	}
 
-	glog.V(2).Info("generating transfer request")
-	treq := TransferRequest{ID: id, Config: config, AppState: appState}
-
 	glog.V(2).Info("sending transfer request")
 	if err = conn.Enc.Encode(treq); err != nil {
-		return err
+		return false, err
+	}
+
+	if treq.Streamed {
+		var resume StreamResume
+		if err = conn.Dec.Decode(&resume); err != nil {
+			return true, err
+		}
+		glog.V(2).Infof("streaming state from offset %d of %d bytes", resume.Offset, treq.StateSize)
+		f, err := os.Open(stateFile)
+		if err != nil {
+			return false, err
+		}
+		err = sendState(conn, f, resume.Offset, treq.StateSize)
+		f.Close()
+		if err != nil {
+			return true, err
+		}
 	}
 
 	glog.V(2).Info("reading transfer response")
 	var tresp TransferResponse
 	if err := conn.Dec.Decode(&tresp); err != nil {
-		return err
+		// The whole state has been sent, so the node may have
+		// started and left Listening: a new attempt would be
+		// refused even if it succeeded.
+		return false, err
 	}
 
 	if !tresp.Success {
-		return errors.New(tresp.ErrorString)
+		return false, errors.New(tresp.ErrorString)
 	}
-
-	glog.V(2).Info("node responded with init success")
-	elog.Log(e.NewEvent(e.FailureHandlingInitDone))
-
-	return nil
+	return false, nil
 }
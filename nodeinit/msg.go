@@ -15,10 +15,16 @@ type InitResponse struct {
 	State state
 }
 
+// A TransferRequest carries the configuration and application state of a
+// new node. If Streamed is set, the state is not in AppState, but follows in
+// StateChunks of StateSize bytes in total, with SHA-256 StateSum.
 type TransferRequest struct {
-	ID       grp.ID
-	Config   config.TransferWrapper
-	AppState app.State
+	ID        grp.ID
+	Config    config.TransferWrapper
+	AppState  app.State
+	Streamed  bool
+	StateSize int64
+	StateSum  []byte
 }
 
 type TransferResponse struct {
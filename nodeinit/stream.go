@@ -0,0 +1,196 @@
+package nodeinit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/relab/goxos/app"
+	gnet "github.com/relab/goxos/net"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// A streamed state is sent in chunks of chunkSize bytes.
+const chunkSize = 1 << 20
+
+// InitNode makes up to transferAttempts attempts at transferring a streamed
+// state, each resuming where the previous one stopped.
+const (
+	transferAttempts = 3
+	transferBackoff  = 500 * time.Millisecond
+)
+
+// A StreamResume is the reply of a new node to a TransferRequest with a
+// streamed state. Offset is how much of the state the node already has from
+// an earlier, interrupted transfer.
+type StreamResume struct {
+	Offset int64
+}
+
+// A StateChunk is the part of a streamed state starting at Offset. CRC is
+// the CRC-32 (IEEE) of Data.
+type StateChunk struct {
+	Offset int64
+	Data   []byte
+	CRC    uint32
+}
+
+// A spool is the local file a streamed state is received into. It is kept
+// after an interrupted transfer, so that the transfer can be resumed.
+type spool struct {
+	path string
+	sum  []byte // SHA-256 of the complete state
+}
+
+// fileSum returns the size and SHA-256 of the file at path.
+func fileSum(path string) (int64, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, h.Sum(nil), nil
+}
+
+// sendState sends the state in f from offset to size in chunks.
+func sendState(conn *gnet.Connection, f io.ReadSeeker, offset, size int64) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for offset < size {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := io.ReadFull(f, buf[:n]); err != nil {
+			return err
+		}
+		chunk := StateChunk{Offset: offset, Data: buf[:n], CRC: crc32.ChecksumIEEE(buf[:n])}
+		if err := conn.Enc.Encode(chunk); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+// receiveState receives the streamed state described by initData into the
+// spool, and sets the file of the application state to it. If the
+// connection fails, the spool is kept for the next attempt.
+func (sl *InitListener) receiveState(conn *gnet.Connection, initData *InitData) error {
+	f, offset, err := sl.openSpool(initData.StateSize, initData.StateSum)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if offset > 0 {
+		glog.V(2).Infof("resuming state transfer at %d of %d bytes", offset, initData.StateSize)
+	}
+	if err = conn.Enc.Encode(StreamResume{Offset: offset}); err != nil {
+		return err
+	}
+
+	for offset < initData.StateSize {
+		var chunk StateChunk
+		if err = conn.Dec.Decode(&chunk); err != nil {
+			return err
+		}
+		switch {
+		case chunk.Offset != offset:
+			return fmt.Errorf("expected state chunk at offset %d, got %d", offset, chunk.Offset)
+		case len(chunk.Data) == 0 || offset+int64(len(chunk.Data)) > initData.StateSize:
+			return fmt.Errorf("state chunk at offset %d has invalid size %d", offset, len(chunk.Data))
+		case crc32.ChecksumIEEE(chunk.Data) != chunk.CRC:
+			return fmt.Errorf("state chunk at offset %d is corrupt", offset)
+		}
+		if _, err = f.Write(chunk.Data); err != nil {
+			return err
+		}
+		offset += int64(len(chunk.Data))
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return err
+	}
+	path := sl.spool.path
+	sl.spool = nil
+	if !bytes.Equal(h.Sum(nil), initData.StateSum) {
+		os.Remove(path)
+		return errors.New("checksum of received state does not match")
+	}
+	initData.AppState.File = path
+	return nil
+}
+
+// openSpool opens the spool for a state of the given size and checksum, and
+// returns the offset to resume the transfer from. A spool left by the
+// transfer of another state is removed.
+func (sl *InitListener) openSpool(size int64, sum []byte) (*os.File, int64, error) {
+	if sl.spool != nil && bytes.Equal(sl.spool.sum, sum) {
+		f, err := os.OpenFile(sl.spool.path, os.O_RDWR, 0)
+		if err == nil {
+			offset, err := f.Seek(0, io.SeekEnd)
+			if err == nil && offset <= size {
+				return f, offset, nil
+			}
+			f.Close()
+		}
+	}
+	if sl.spool != nil {
+		os.Remove(sl.spool.path)
+		sl.spool = nil
+	}
+	f, err := ioutil.TempFile("", "goxos-state-")
+	if err != nil {
+		return nil, 0, err
+	}
+	sl.spool = &spool{path: f.Name(), sum: sum}
+	return f, 0, nil
+}
+
+// SetAppState restores the application state of the init data in ah. A
+// streamed state is read from its file, which is removed afterwards. If ah
+// is an app.Versions, the version that wrote the state is selected; the
+// state is refused if ah lacks that version.
+func (id *InitData) SetAppState(ah app.Handler) error {
+	if versions, ok := ah.(*app.Versions); ok {
+		if err := versions.Select(id.AppState.Version); err != nil {
+			return err
+		}
+		_, ah = versions.Current()
+	} else if id.AppState.Version != 0 {
+		return app.UnknownVersionError(id.AppState.Version)
+	}
+	if id.AppState.File == "" {
+		return ah.SetState(id.AppState.State)
+	}
+	defer id.AppState.Remove()
+	streamer, ok := ah.(app.Streamer)
+	if !ok {
+		return errors.New("application cannot read a streamed state")
+	}
+	f, err := os.Open(id.AppState.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return streamer.ReadState(bufio.NewReader(f))
+}
@@ -0,0 +1,113 @@
+package nodeinit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+
+	gnet "github.com/relab/goxos/net"
+)
+
+func TestStreamStateResume(t *testing.T) {
+	state := make([]byte, 2*chunkSize+chunkSize/2)
+	rand.New(rand.NewSource(1)).Read(state)
+	sum := sha256.Sum256(state)
+	sl := NewInitListener("127.0.0.1")
+	newInitData := func() *InitData {
+		return &InitData{Streamed: true, StateSize: int64(len(state)), StateSum: sum[:]}
+	}
+
+	// The connection fails after the first chunk
+	initData := newInitData()
+	received := receive(sl, initData)
+	resume, err := sendUntil(received.conn, state, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume != 0 {
+		t.Fatalf("first attempt resumed at %d, want 0", resume)
+	}
+	if err := <-received.err; err == nil {
+		t.Fatal("interrupted transfer succeeded")
+	}
+	if sl.spool == nil {
+		t.Fatal("spool of interrupted transfer was removed")
+	}
+
+	// The second attempt resumes after the first chunk
+	initData = newInitData()
+	received = receive(sl, initData)
+	resume, err = sendUntil(received.conn, state, int64(len(state)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resume != chunkSize {
+		t.Fatalf("second attempt resumed at %d, want %d", resume, chunkSize)
+	}
+	if err := <-received.err; err != nil {
+		t.Fatal(err)
+	}
+	defer initData.AppState.Remove()
+	got, err := ioutil.ReadFile(initData.AppState.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, state) {
+		t.Fatal("received state differs from the state sent")
+	}
+}
+
+func TestStreamStateCorrupt(t *testing.T) {
+	state := []byte("state")
+	sum := sha256.Sum256(state)
+	sl := NewInitListener("127.0.0.1")
+	initData := &InitData{Streamed: true, StateSize: int64(len(state)), StateSum: sum[:]}
+	received := receive(sl, initData)
+	conn := gnet.NewConnection(received.conn)
+	var resume StreamResume
+	if err := conn.Dec.Decode(&resume); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Enc.Encode(StateChunk{Data: state, CRC: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-received.err; err == nil {
+		t.Fatal("corrupt chunk was accepted")
+	}
+	received.conn.Close()
+	if sl.spool != nil {
+		os.Remove(sl.spool.path)
+	}
+}
+
+type receiving struct {
+	conn net.Conn // The sender's end
+	err  chan error
+}
+
+// receive starts receiving a streamed state at sl.
+func receive(sl *InitListener, initData *InitData) receiving {
+	sconn, rconn := net.Pipe()
+	r := receiving{conn: sconn, err: make(chan error, 1)}
+	go func() {
+		defer rconn.Close()
+		r.err <- sl.receiveState(gnet.NewConnection(rconn), initData)
+	}()
+	return r
+}
+
+// sendUntil sends state up to end on conn, then closes it. It returns the
+// offset the receiver resumed at.
+func sendUntil(c net.Conn, state []byte, end int64) (int64, error) {
+	defer c.Close()
+	conn := gnet.NewConnection(c)
+	var resume StreamResume
+	if err := conn.Dec.Decode(&resume); err != nil {
+		return 0, err
+	}
+	return resume.Offset, sendState(conn, bytes.NewReader(state[:end]), resume.Offset, end)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 
 	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/net"
 )
 
 func init() {
@@ -13,6 +14,8 @@ func init() {
 	gob.Register(Learn{})
 	gob.Register(CatchUpRequest{})
 	gob.Register(CatchUpResponse{})
+
+	net.SetClass(CatchUpResponse{}, net.BulkClass)
 }
 
 type Prepare struct {
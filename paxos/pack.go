@@ -3,6 +3,7 @@ package paxos
 import (
 	"sync"
 
+	"github.com/relab/goxos/client"
 	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
 	"github.com/relab/goxos/liveness"
@@ -47,8 +48,9 @@ type Pack struct {
 	BcastA chan<- interface{}
 	BcastL chan<- interface{}
 
-	PropChan        <-chan *Value // Proposal values
-	DcdChan         chan<- *Value // Decided values
+	PropChan        <-chan *Value          // Proposal values
+	DcdChan         chan<- *Value          // Decided values
+	RejectChan      chan<- *client.Request // Requests that cannot be ordered, only used by BatchPaxos
 	NewDcdChan      chan bool
 	DcdSlotIDToProp chan SlotID
 
@@ -1,6 +1,7 @@
 package paxos
 
 import (
+	"encoding/binary"
 	"hash/fnv"
 
 	"github.com/relab/goxos/client"
@@ -12,22 +13,32 @@ const (
 	Noop ValueType = iota
 	App
 	Reconfig
+	Upgrade
 )
 
 var valueTypes = [...]string{
 	"No-op command",
 	"Application command",
 	"Reconfiguration command",
+	"Upgrade command",
 }
 
 func (vt ValueType) String() string {
 	return valueTypes[vt]
 }
 
+// A Value is proposed for a slot. Time and Seed are stamped by the proposer
+// of an App value, so that every replica executes its commands with the same
+// time and randomness. Time is in Unix nanoseconds; both are zero if the
+// value was not stamped. Version is the application version an Upgrade
+// value switches to.
 type Value struct {
-	Vt ValueType
-	Cr []*client.Request
-	Rc *ReconfigCmd
+	Vt      ValueType
+	Cr      []*client.Request
+	Rc      *ReconfigCmd
+	Time    int64
+	Seed    int64
+	Version uint32
 }
 
 func (v *Value) Equal(o Value) bool {
@@ -39,7 +50,7 @@ func (v *Value) Equal(o Value) bool {
 	case Noop:
 		return true
 	case App:
-		if len(v.Cr) != len(o.Cr) {
+		if len(v.Cr) != len(o.Cr) || v.Time != o.Time || v.Seed != o.Seed {
 			return false
 		}
 
@@ -49,6 +60,8 @@ func (v *Value) Equal(o Value) bool {
 			}
 		}
 		return true
+	case Upgrade:
+		return v.Version == o.Version
 	case Reconfig:
 		// TODO: Implement equality for reconfig commands
 	}
@@ -56,6 +69,8 @@ func (v *Value) Equal(o Value) bool {
 	return false
 }
 
+// Hash returns a hash of the commands of an App value and its stamps, so
+// that values differing only in Time or Seed do not hash alike.
 func (v *Value) Hash() uint32 {
 	if v.Vt != App {
 		panic("cannot hash non-app value")
@@ -65,5 +80,6 @@ func (v *Value) Hash() uint32 {
 	for i := range v.Cr {
 		h.Write(v.Cr[i].GetVal())
 	}
+	binary.Write(h, binary.BigEndian, [2]int64{v.Time, v.Seed})
 	return h.Sum32()
 }
@@ -35,6 +35,7 @@ func (rh *ReconfigHandler) prepareReconfig(newNodeID grp.ID) (
 
 	// Request application state
 	appState := rh.getAppState()
+	defer appState.Remove()
 
 	// Contact and initalize node
 	if err = nodeinit.InitNode(
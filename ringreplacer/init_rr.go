@@ -12,6 +12,7 @@ import (
 func reconfSetupGlobal(newNodes map[grp.Node]config.TransferWrapper,
 	appState app.State) error {
 	glog.V(2).Infoln("Initializing new Nodes")
+	defer appState.Remove()
 
 	//TODO: Do this in a goroutine?
 	for nd, conf := range newNodes {
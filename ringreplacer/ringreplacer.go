@@ -83,7 +83,7 @@ func (rr *RingReplacer) Stop() {
 }
 
 func (rr *RingReplacer) registerAndSubscribe() {
-	rr.fdChan = rr.fd.SubscribeToFdMsgs("reconf")
+	rr.fdChan = rr.fd.SubscribeToConfirmedFdMsgs("reconf")
 	//rr.pxLdChan = rr.ld.SubscribeToPaxosLdMsgs("reconf")
 }
 
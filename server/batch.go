@@ -0,0 +1,73 @@
+package server
+
+import (
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// A cmdKey identifies a command of a client.
+type cmdKey struct {
+	id  string
+	seq uint32
+}
+
+// executeBatch executes the requests of a decided value, passing each run of
+// consecutive new commands to the application in one ExecuteBatch call. A
+// run ends at any other request, which is handled after the run has been
+// executed, so that it sees the session table as it would if the commands
+// had been executed one by one.
+func (s *Server) executeBatch(bh app.BatchHandler, val *paxos.Value, now time.Time) {
+	var run []*client.Request
+	pending := make(map[cmdKey]bool)
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		cmds := make([][]byte, len(run))
+		for i, req := range run {
+			cmds[i] = req.GetVal()
+		}
+		resps := bh.ExecuteBatch(cmds)
+		if len(resps) != len(cmds) {
+			glog.Fatalf("application returned %d responses to a batch of %d commands",
+				len(resps), len(cmds))
+		}
+		if glog.V(3) {
+			glog.Infof("application executed a batch of %d commands", len(cmds))
+		}
+		for i, req := range run {
+			s.sessions.record(req, resps[i])
+			s.respond(genRespForReq(req, resps[i]))
+			s.localAru.Increment()
+		}
+		run = run[:0]
+		pending = make(map[cmdKey]bool)
+	}
+
+	for i, req := range val.Cr {
+		if req.GetType() == client.Request_EXPIRE {
+			flush()
+			s.handleExpire(req)
+			s.localAru.Increment()
+			continue
+		}
+		key := cmdKey{req.GetId(), req.GetSeq()}
+		if !pending[key] {
+			if _, status := s.sessions.lookup(req); status == cmdNew {
+				run = append(run, req)
+				pending[key] = true
+				continue
+			}
+		}
+		// A duplicate, or a command the session table answers
+		flush()
+		s.respond(s.execute(req, execCtx(val, now, i)))
+		s.localAru.Increment()
+	}
+	flush()
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+)
+
+// batchApp echoes its commands, and records the size of every batch.
+type batchApp struct {
+	batches []int
+}
+
+func (a *batchApp) Execute(req []byte) []byte       { return req }
+func (a *batchApp) GetState(sm uint) (uint, []byte) { return sm, nil }
+func (a *batchApp) SetState(state []byte) error     { return nil }
+func (a *batchApp) ExecuteBatch(reqs [][]byte) (resps [][]byte) {
+	a.batches = append(a.batches, len(reqs))
+	return reqs
+}
+
+type recordingHandler struct {
+	client.ClientHandlerMock
+	resps []*client.Response
+}
+
+func (rh *recordingHandler) ForwardResponse(resp *client.Response) {
+	rh.resps = append(rh.resps, resp)
+}
+
+func TestExecuteBatch(t *testing.T) {
+	a := &batchApp{}
+	rh := &recordingHandler{}
+	s := execServer(a, rh)
+	cmd := func(id string, seq uint32) *client.Request {
+		req := sessionReq(id, seq, 0)
+		req.Val = []byte(id)
+		return req
+	}
+	s.sessions.record(cmd("c", 0), []byte("cached"))
+
+	reqs := []*client.Request{
+		cmd("a", 0),
+		cmd("b", 0),
+		cmd("a", 0), // Duplicate within the value
+		cmd("a", 1),
+		cmd("c", 0), // Executed before
+		cmd("b", 1),
+	}
+	s.handleDecidedVal(&paxos.Value{Vt: paxos.App, Cr: reqs}, false)
+
+	if want := []int{2, 1, 1}; !equalInts(a.batches, want) {
+		t.Errorf("got batches of %v, want %v", a.batches, want)
+	}
+	if got := s.localAru.Value(); got != paxos.SlotID(len(reqs)) {
+		t.Errorf("local aru is %v, want %d", got, len(reqs))
+	}
+	want := []string{"a", "b", "a", "a", "cached", "b"}
+	if len(rh.resps) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(rh.resps), len(want))
+	}
+	for i, resp := range rh.resps {
+		if resp.GetId() != reqs[i].GetId() || resp.GetSeq() != reqs[i].GetSeq() ||
+			!bytes.Equal(resp.GetVal(), []byte(want[i])) {
+			t.Errorf("response %d is %v, want %q to %s/%d",
+				i, resp, want[i], reqs[i].GetId(), reqs[i].GetSeq())
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+	"github.com/relab/goxos/trace"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// newAppValue returns a value for proposing reqs, stamped with our time and
+// a random seed. When replaying, the recorded stamp is used instead.
+func (s *Server) newAppValue(reqs []*client.Request) *paxos.Value {
+	val := &paxos.Value{Vt: paxos.App, Cr: reqs}
+	if s.replaying {
+		stamp := <-s.replayStamps
+		val.Time, val.Seed = stamp.Time, stamp.Seed
+		return val
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		glog.Errorln("reading random seed failed:", err)
+	}
+	val.Time, val.Seed = time.Now().UnixNano(), int64(binary.LittleEndian.Uint64(b[:]))
+	trace.RecordStamp(val.Time, val.Seed)
+	return val
+}
+
+// tick advances the execution clock to the time val was stamped with, and
+// returns it. The clock never goes backwards, even if the clock of a new
+// leader is behind that of the old one.
+func (s *Server) tick(val *paxos.Value) time.Time {
+	if val.Time > s.clock {
+		s.clock = val.Time
+	}
+	return time.Unix(0, s.clock)
+}
+
+// execCtx returns the context of the i'th command of val.
+func execCtx(val *paxos.Value, now time.Time, i int) app.ExecCtx {
+	seed := val.Seed
+	if seed == 0 {
+		// Not stamped
+		seed = int64(val.Hash())
+	}
+	return app.ExecCtx{Time: now, Seed: int64(uint64(seed) + uint64(i+1)*0x9e3779b97f4a7c15)}
+}
+
+// executeApp passes cmd to the application, with ctx if it wants it.
+func executeApp(ah app.Handler, ctx app.ExecCtx, cmd []byte) []byte {
+	if ch, ok := ah.(app.ContextHandler); ok {
+		return ch.ExecuteWithContext(ctx, cmd)
+	}
+	return ah.Execute(cmd)
+}
+
+// SetClock sets the execution clock received together with the application
+// state from another replica.
+func (s *Server) SetClock(clock int64) {
+	s.clock = clock
+}
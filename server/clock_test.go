@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+)
+
+// ctxApp records the contexts its commands are executed in.
+type ctxApp struct {
+	ctxs []app.ExecCtx
+}
+
+func (a *ctxApp) Execute(req []byte) []byte       { panic("Execute called on a ContextHandler") }
+func (a *ctxApp) GetState(sm uint) (uint, []byte) { return sm, nil }
+func (a *ctxApp) SetState(state []byte) error     { return nil }
+
+func (a *ctxApp) ExecuteWithContext(ctx app.ExecCtx, req []byte) []byte {
+	a.ctxs = append(a.ctxs, ctx)
+	return nil
+}
+
+func TestExecCtx(t *testing.T) {
+	a := &ctxApp{}
+	s := execServer(a, &client.ClientHandlerMock{})
+	decide := func(stamp int64, ids ...string) {
+		var reqs []*client.Request
+		for _, id := range ids {
+			reqs = append(reqs, sessionReq(id, 0, 0))
+		}
+		s.handleDecidedVal(&paxos.Value{Vt: paxos.App, Cr: reqs, Time: stamp, Seed: 7}, false)
+	}
+	decide(100, "a", "b")
+	decide(50, "c") // Proposed by a leader whose clock is behind
+	decide(200, "d")
+
+	want := []int64{100, 100, 100, 200}
+	if len(a.ctxs) != len(want) {
+		t.Fatalf("got %d commands executed, want %d", len(a.ctxs), len(want))
+	}
+	for i, ctx := range a.ctxs {
+		if !ctx.Time.Equal(time.Unix(0, want[i])) {
+			t.Errorf("command %d executed at %v, want %v", i, ctx.Time.UnixNano(), want[i])
+		}
+	}
+	if a.ctxs[0].Seed == a.ctxs[1].Seed {
+		t.Error("commands of the same slot got the same seed")
+	}
+	if a.ctxs[0].Seed != a.ctxs[2].Seed {
+		t.Error("first commands of values with the same seed got different seeds")
+	}
+}
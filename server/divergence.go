@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strings"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/batchpaxos"
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
+
+	"github.com/relab/goxos/elog"
+	e "github.com/relab/goxos/elog/event"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// How many checkpoints of digests are kept for comparison.
+const keptDigests = 8
+
+func init() {
+	gob.Register(StateDigest{})
+}
+
+// A StateDigest is the hash of the application state of replica ID, taken
+// after the first decided value that brought its local aru to a multiple of
+// the stateHashInterval. Slot is the local aru at that point, which is the
+// same at every replica.
+type StateDigest struct {
+	ID   grp.ID
+	Slot paxos.SlotID
+	Hash []byte
+}
+
+// hashCheck compares the state of the replicas at regular slots.
+type hashCheck struct {
+	hasher     app.Hasher // nil if off
+	interval   paxos.SlotID
+	quarantine bool
+	digestChan chan StateDigest
+	next       paxos.SlotID                       // Slot of the next digest
+	own        map[paxos.SlotID][]byte            // Our digests
+	peers      map[paxos.SlotID]map[grp.ID][]byte // Digests of the other replicas
+	mismatched map[paxos.SlotID]map[grp.ID]bool   // Reported replicas, including us
+}
+
+func (s *Server) initStateHash() {
+	hasher, ok := s.ah.(app.Hasher)
+	interval := s.config.GetInt("stateHashInterval", config.DefStateHashInterval)
+	if !ok || interval <= 0 {
+		return
+	}
+	quarantine := s.config.GetBool("stateHashQuarantine", config.DefStateHashQuarantine)
+	protocol := s.config.GetString("protocol", config.DefProtocol)
+	switch strings.TrimSpace(strings.ToLower(protocol)) {
+	case "authenticatedbc", "reliablebc":
+		if quarantine {
+			glog.Warningf("stateHashQuarantine is not supported by %s, "+
+				"diverged replicas are only reported", protocol)
+			quarantine = false
+		}
+	}
+	s.hashes = hashCheck{
+		hasher:     hasher,
+		interval:   paxos.SlotID(interval),
+		quarantine: quarantine,
+		digestChan: make(chan StateDigest, 16),
+		own:        make(map[paxos.SlotID][]byte),
+		peers:      make(map[paxos.SlotID]map[grp.ID][]byte),
+		mismatched: make(map[paxos.SlotID]map[grp.ID]bool),
+	}
+	s.dmx.RegisterChannel(s.hashes.digestChan)
+}
+
+// checkStateHash takes and broadcasts a digest of the application state if
+// the last decided value brought us to the next multiple of the interval.
+func (s *Server) checkStateHash() {
+	hc := &s.hashes
+	if hc.hasher == nil {
+		return
+	}
+	if hc.next == 0 {
+		hc.next = (s.localAru.Value()/hc.interval + 1) * hc.interval
+	}
+	if s.executedThrough() < hc.next {
+		return
+	}
+	s.drainExecution()
+	slot := s.localAru.Value()
+	hash := hc.hasher.StateHash()
+	hc.next = (slot/hc.interval + 1) * hc.interval
+	hc.own[slot] = hash
+	if glog.V(2) {
+		glog.Infof("state digest at slot %v is %x", slot, hash)
+	}
+	s.outBroadcast <- StateDigest{ID: s.id, Slot: slot, Hash: hash}
+	s.compareDigests(slot)
+
+	for old := range hc.own {
+		if old+keptDigests*hc.interval <= slot {
+			delete(hc.own, old)
+			delete(hc.peers, old)
+			delete(hc.mismatched, old)
+		}
+	}
+}
+
+func (s *Server) handleStateDigest(d StateDigest) {
+	hc := &s.hashes
+	if d.ID == s.id || hc.hasher == nil {
+		return
+	}
+	if d.Slot+keptDigests*hc.interval <= s.localAru.Value() {
+		return
+	}
+	if hc.peers[d.Slot] == nil {
+		hc.peers[d.Slot] = make(map[grp.ID][]byte)
+	}
+	hc.peers[d.Slot][d.ID] = d.Hash
+	if _, found := hc.own[d.Slot]; found {
+		s.compareDigests(d.Slot)
+	}
+}
+
+// compareDigests reports the replicas whose digest at slot differs from
+// ours. If a majority of the replicas agree on another digest, it is our
+// state that has diverged, and we are quarantined if so configured.
+func (s *Server) compareDigests(slot paxos.SlotID) {
+	hc := &s.hashes
+	own := hc.own[slot]
+	votes := make(map[string]int)
+	for id, hash := range hc.peers[slot] {
+		votes[string(hash)]++
+		if bytes.Equal(hash, own) || hc.mismatched[slot][id] {
+			continue
+		}
+		if hc.mismatched[slot] == nil {
+			hc.mismatched[slot] = make(map[grp.ID]bool)
+		}
+		hc.mismatched[slot][id] = true
+		glog.Errorf("state of %v differs from ours at slot %v: %x, ours is %x", id, slot, hash, own)
+		elog.Log(e.NewEventWithMetric(e.StateHashMismatch, uint64(id.PaxosID)))
+		s.diverged(id)
+	}
+
+	if hc.mismatched[slot][s.id] {
+		return
+	}
+	quorum := int(s.grpmgr.NodeMap().NrOfNodes()/2 + 1)
+	for hash, n := range votes {
+		if n >= quorum && hash != string(own) {
+			// Marks us as reported
+			hc.mismatched[slot][s.id] = true
+			glog.Errorf("our state has diverged from that of a majority at slot %v", slot)
+			if hc.quarantine && !s.quarantined() {
+				s.quarantine()
+			}
+			return
+		}
+	}
+}
+
+// quarantine stops us from serving reads, answering clients and taking part
+// in agreement, so that our diverged state does not reach clients or decide
+// values. If we are the leader, leadership is handed to another proposer.
+func (s *Server) quarantine() {
+	glog.Errorln("quarantining replica; it no longer serves clients, proposes or votes")
+	elog.Log(e.NewEvent(e.StateQuarantined))
+	s.status.Lock()
+	s.status.status.Quarantined = true
+	s.status.Unlock()
+	if s.snd != nil {
+		s.snd.SetDropFilter(isAgreementMsg)
+	}
+	s.giveUpLeadership()
+}
+
+func (s *Server) quarantined() bool {
+	s.status.Lock()
+	defer s.status.Unlock()
+	return s.status.status.Quarantined
+}
+
+// giveUpLeadership transfers leadership to the first other proposer that
+// accepts it, if we are the leader. The transfer runs in a goroutine of its
+// own, since the leader detector may be waiting for us to take its trust
+// messages.
+func (s *Server) giveUpLeadership() {
+	if s.pxLeader != s.id || s.ld == nil {
+		return
+	}
+	ld := s.ld
+	candidates := s.grpmgr.NodeMap().ProposerIDs()
+	go func() {
+		for _, id := range candidates {
+			if id == s.id {
+				continue
+			}
+			if err := ld.TransferLeadership(id); err == nil {
+				glog.V(1).Infof("quarantined replica transferred leadership to %v", id)
+				return
+			}
+		}
+		glog.Errorln("quarantined replica found no proposer to transfer leadership to")
+	}()
+}
+
+// isAgreementMsg returns true for the messages of a proposer or an acceptor
+// of MultiPaxos, ParallelPaxos and BatchPaxos. Catch-up responses carry
+// decided values, not our state, and are still sent.
+func isAgreementMsg(msg interface{}) bool {
+	switch msg.(type) {
+	case paxos.Prepare, *paxos.Prepare, paxos.Promise, *paxos.Promise,
+		paxos.Accept, *paxos.Accept, paxos.Learn, *paxos.Learn,
+		batchpaxos.BatchLearnMsg, *batchpaxos.BatchLearnMsg,
+		batchpaxos.BatchCommitMsg, *batchpaxos.BatchCommitMsg:
+		return true
+	}
+	return false
+}
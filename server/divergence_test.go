@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/batchpaxos"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/liveness"
+	"github.com/relab/goxos/net"
+	"github.com/relab/goxos/paxos"
+)
+
+// hashApp has a state digest set by the test.
+type hashApp struct {
+	hash []byte
+}
+
+func (a *hashApp) Execute(req []byte) []byte       { return nil }
+func (a *hashApp) GetState(sm uint) (uint, []byte) { return sm, nil }
+func (a *hashApp) SetState(state []byte) error     { return nil }
+func (a *hashApp) StateHash() []byte               { return a.hash }
+
+// transferLD records the replicas leadership is transferred to.
+type transferLD struct {
+	liveness.MockLD
+	to chan grp.ID
+}
+
+func (ld *transferLD) TransferLeadership(to grp.ID) error {
+	ld.to <- to
+	return nil
+}
+
+func TestStateDigests(t *testing.T) {
+	id0, id1, id2 := grp.NewID(0, 0), grp.NewID(1, 0), grp.NewID(2, 0)
+	a := &hashApp{hash: []byte("x")}
+	ld := &transferLD{to: make(chan grp.ID, 1)}
+	rh := &recordingHandler{}
+	s := execServer(a, rh)
+	s.id, s.pxLeader, s.ld = id0, id0, ld
+	s.grpmgr = grp.NewGrpMgr(id0, grp.NewNodeMap(statusNodes(id0, id1, id2)), false, false, new(sync.WaitGroup))
+	s.outBroadcast = make(chan interface{}, 8)
+	s.status = statusState{status: Status{ID: id0, Leader: grp.UndefinedID()}}
+	s.hashes = hashCheck{
+		hasher:     a,
+		interval:   2,
+		quarantine: true,
+		own:        make(map[paxos.SlotID][]byte),
+		peers:      make(map[paxos.SlotID]map[grp.ID][]byte),
+		mismatched: make(map[paxos.SlotID]map[grp.ID]bool),
+	}
+	events := s.SubscribeToEvents("test")
+	decide := func(n int) {
+		var reqs []*client.Request
+		for i := 0; i < n; i++ {
+			reqs = append(reqs, sessionReq("c", uint32(s.localAru.Value())+uint32(i), 0))
+		}
+		s.handleDecidedVal(&paxos.Value{Vt: paxos.App, Cr: reqs}, false)
+	}
+
+	decide(1)
+	if len(s.outBroadcast) != 0 {
+		t.Fatal("digest taken before the interval")
+	}
+	decide(2)
+	d, ok := (<-s.outBroadcast).(StateDigest)
+	if !ok || d.Slot != 3 || string(d.Hash) != "x" {
+		t.Fatalf("got digest %v, want x at slot 3", d)
+	}
+
+	// A digest that arrives before ours is compared when ours is taken
+	s.handleStateDigest(StateDigest{ID: id1, Slot: 5, Hash: []byte("z")})
+	s.handleStateDigest(StateDigest{ID: id2, Slot: 3, Hash: []byte("y")})
+	if ev := <-events; ev.Type != Diverged || ev.ID != id2 {
+		t.Errorf("got event %v, want %v of %v", ev, Diverged, id2)
+	}
+	if st := s.Status(); st.Divergences != 1 || st.Quarantined {
+		t.Errorf("got %d divergences, quarantined %v; want 1, false", st.Divergences, st.Quarantined)
+	}
+
+	a.hash = []byte("z")
+	decide(2)
+	<-s.outBroadcast
+	if len(events) != 0 {
+		t.Errorf("got event %v for matching digest", <-events)
+	}
+
+	// The other replicas agree on a state different from ours
+	decide(2)
+	<-s.outBroadcast
+	s.handleStateDigest(StateDigest{ID: id1, Slot: 7, Hash: []byte("w")})
+	if s.Status().Quarantined {
+		t.Error("replica was quarantined by a minority")
+	}
+	s.handleStateDigest(StateDigest{ID: id2, Slot: 7, Hash: []byte("w")})
+	if !s.Status().Quarantined {
+		t.Error("diverged replica was not quarantined")
+	}
+
+	if to := <-ld.to; to == id0 {
+		t.Error("quarantined leader transferred leadership to itself")
+	}
+
+	// Responses from the diverged state do not reach clients
+	n := len(rh.resps)
+	decide(1)
+	if len(rh.resps) != n {
+		t.Errorf("quarantined replica sent %d responses", len(rh.resps)-n)
+	}
+
+	// Nor the embedded application, whose requests go to the new leader
+	s.local = newLocalClient("app/0", time.Hour)
+	s.localRoute = routeDirect
+	s.pxLeader = id1
+	s.outUnicast = make(chan net.Packet, 8)
+	s.propChan = make(chan *paxos.Value, 8)
+	s.batchMaxSize = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := s.Propose(ctx, []byte("x"))
+	req := <-s.local.reqChan
+	s.respond(genRespForReq(req, nil))
+	select {
+	case <-p.Done():
+		t.Error("quarantined replica completed a local proposal")
+	default:
+	}
+	s.handleLocalRequest(req)
+	if pkt := <-s.outUnicast; pkt.DestID != id1 {
+		t.Errorf("local request forwarded to %v, want %v", pkt.DestID, id1)
+	}
+	s.handleForwardedRequest(ForwardedRequest{Req: req})
+	if len(s.propChan) != 0 {
+		t.Error("quarantined replica proposed a local request")
+	}
+}
+
+func TestIsAgreementMsg(t *testing.T) {
+	for _, msg := range []interface{}{
+		paxos.Prepare{}, &paxos.Promise{}, paxos.Accept{}, paxos.Learn{},
+		batchpaxos.BatchLearnMsg{}, &batchpaxos.BatchCommitMsg{},
+	} {
+		if !isAgreementMsg(msg) {
+			t.Errorf("%T is not dropped by a quarantined replica", msg)
+		}
+	}
+	for _, msg := range []interface{}{paxos.CatchUpResponse{}, StateDigest{}} {
+		if isAgreementMsg(msg) {
+			t.Errorf("%T is dropped by a quarantined replica", msg)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/paxos"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// An executor runs the new commands of an app.KeyedHandler on a pool of
+// workers. A command starts once every earlier command it conflicts with
+// has finished. The results are applied in decided order by the server
+// goroutine: recorded in the session table, sent to the client and counted
+// in the local aru. The state and the responses are therefore the same as
+// with sequential execution.
+type executor struct {
+	ah         app.Handler
+	kh         app.KeyedHandler
+	workers    chan struct{} // Holds a token for every busy worker
+	maxPending int
+
+	queue    []*execTask            // Not yet applied, in decided order
+	writers  map[string]*execTask   // Last queued writer of each key
+	readers  map[string][]*execTask // Queued readers since the last writer
+	clients  map[string]uint32      // Highest queued seq of each client
+	finished chan struct{}          // Signalled when a command has run
+}
+
+// An execTask is the execution of a request, or a slot without a command to
+// run, e.g. a no-op or a request answered from the session table.
+type execTask struct {
+	req    *client.Request // The command to run, or nil
+	ctx    app.ExecCtx
+	reply  *client.Response // The response, if req is nil
+	reads  []string
+	writes []string
+	done   chan struct{}
+	resp   []byte
+}
+
+func (s *Server) initExecutor() {
+	kh, ok := s.ah.(app.KeyedHandler)
+	if !ok {
+		return
+	}
+	workers := s.config.GetInt("executionWorkers", config.DefExecutionWorkers)
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers <= 1 {
+		return
+	}
+	glog.V(2).Infof("executing non-conflicting commands on %d workers", workers)
+	s.exec = newExecutor(s.ah, kh, workers,
+		s.config.GetInt("maxPendingExecutions", config.DefMaxPendingExecutions))
+}
+
+func newExecutor(ah app.Handler, kh app.KeyedHandler, workers, maxPending int) *executor {
+	return &executor{
+		ah:         ah,
+		kh:         kh,
+		workers:    make(chan struct{}, workers),
+		maxPending: maxPending,
+		writers:    make(map[string]*execTask),
+		readers:    make(map[string][]*execTask),
+		clients:    make(map[string]uint32),
+		finished:   make(chan struct{}, 1),
+	}
+}
+
+// finishedChan returns a channel that is signalled when queued commands may
+// be ready to be applied, or nil if there is no executor.
+func (s *Server) finishedChan() <-chan struct{} {
+	if s.exec == nil {
+		return nil
+	}
+	return s.exec.finished
+}
+
+// executeParallel executes the requests of a decided value. New commands are
+// queued for the workers; other requests are answered in turn.
+func (s *Server) executeParallel(val *paxos.Value, now time.Time) {
+	e := s.exec
+	for i, req := range val.Cr {
+		ctx := execCtx(val, now, i)
+		if req.GetType() == client.Request_EXPIRE {
+			// Ends sessions that queued commands may belong to
+			s.drainExecution()
+			s.handleExpire(req)
+			s.localAru.Increment()
+			continue
+		}
+		last, queued := e.clients[req.GetId()]
+		if !queued || req.GetSeq() > last {
+			if _, status := s.sessions.lookup(req); status == cmdNew {
+				s.submit(req, ctx)
+				continue
+			}
+			if !queued {
+				s.advance(s.execute(req, ctx))
+				continue
+			}
+		}
+		// The session table must show the queued commands of the
+		// client before it can answer this one
+		s.drainExecution()
+		s.respond(s.execute(req, ctx))
+		s.localAru.Increment()
+	}
+}
+
+// advance applies a slot that has no command to run, after the slots queued
+// before it. reply may be nil.
+func (s *Server) advance(reply *client.Response) {
+	if s.exec == nil || len(s.exec.queue) == 0 {
+		if reply != nil {
+			s.respond(reply)
+		}
+		s.localAru.Increment()
+		return
+	}
+	t := &execTask{reply: reply, done: make(chan struct{})}
+	close(t.done)
+	s.exec.queue = append(s.exec.queue, t)
+}
+
+// submit queues req to run once the commands it conflicts with have run.
+func (s *Server) submit(req *client.Request, ctx app.ExecCtx) {
+	e := s.exec
+	t := &execTask{req: req, ctx: ctx, done: make(chan struct{})}
+	t.reads, t.writes = e.kh.Keys(req.GetVal())
+	var deps []*execTask
+	for _, k := range t.reads {
+		if w := e.writers[k]; w != nil {
+			deps = append(deps, w)
+		}
+	}
+	for _, k := range t.writes {
+		if w := e.writers[k]; w != nil {
+			deps = append(deps, w)
+		}
+		deps = append(deps, e.readers[k]...)
+	}
+	for _, k := range t.writes {
+		e.writers[k] = t
+		delete(e.readers, k)
+	}
+	for _, k := range t.reads {
+		if e.writers[k] != t {
+			e.readers[k] = append(e.readers[k], t)
+		}
+	}
+	e.clients[req.GetId()] = req.GetSeq()
+	e.queue = append(e.queue, t)
+	go e.run(t, deps)
+
+	s.applyExecuted()
+	for len(e.queue) > e.maxPending {
+		<-e.queue[0].done
+		s.applyExecuted()
+	}
+}
+
+func (e *executor) run(t *execTask, deps []*execTask) {
+	for _, dep := range deps {
+		<-dep.done
+	}
+	e.workers <- struct{}{}
+	t.resp = executeApp(e.ah, t.ctx, t.req.GetVal())
+	<-e.workers
+	close(t.done)
+	select {
+	case e.finished <- struct{}{}:
+	default:
+	}
+}
+
+// applyExecuted applies the queued slots that have run, in decided order.
+func (s *Server) applyExecuted() {
+	e := s.exec
+	for len(e.queue) > 0 {
+		t := e.queue[0]
+		select {
+		case <-t.done:
+		default:
+			return
+		}
+		e.queue[0] = nil
+		e.queue = e.queue[1:]
+		if t.req == nil {
+			if t.reply != nil {
+				s.respond(t.reply)
+			}
+			s.localAru.Increment()
+			continue
+		}
+		e.forget(t)
+		s.sessions.record(t.req, t.resp)
+		s.respond(genRespForReq(t.req, t.resp))
+		s.localAru.Increment()
+	}
+}
+
+// forget removes t from the key and client tables. Tasks are applied in
+// the order they were queued, so t is the first reader of its keys.
+func (e *executor) forget(t *execTask) {
+	for _, k := range t.writes {
+		if e.writers[k] == t {
+			delete(e.writers, k)
+		}
+	}
+	for _, k := range t.reads {
+		if rs := e.readers[k]; len(rs) > 0 && rs[0] == t {
+			if len(rs) == 1 {
+				delete(e.readers, k)
+			} else {
+				e.readers[k] = rs[1:]
+			}
+		}
+	}
+	if e.clients[t.req.GetId()] == t.req.GetSeq() {
+		delete(e.clients, t.req.GetId())
+	}
+}
+
+// executedThrough returns the local aru once the queued slots are applied.
+func (s *Server) executedThrough() paxos.SlotID {
+	if s.exec == nil {
+		return s.localAru.Value()
+	}
+	return s.localAru.Value() + paxos.SlotID(len(s.exec.queue))
+}
+
+// drainExecution waits for every queued command to run, and applies them.
+// It must be called before the application state is used other than by
+// Execute, or the session table is changed.
+func (s *Server) drainExecution() {
+	if s.exec == nil {
+		return
+	}
+	for len(s.exec.queue) > 0 {
+		<-s.exec.queue[0].done
+		s.applyExecuted()
+	}
+}
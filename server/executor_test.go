@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+)
+
+// counterApp holds counters. The command "w k" increments counter k and
+// returns its new value, and "r k" returns the value of k.
+type counterApp struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (a *counterApp) Execute(req []byte) []byte {
+	op, k := splitCmd(req)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if op == "w" {
+		a.counters[k]++
+	}
+	return []byte(fmt.Sprint(a.counters[k]))
+}
+
+func (a *counterApp) GetState(sm uint) (uint, []byte) { return sm, nil }
+func (a *counterApp) SetState(state []byte) error     { return nil }
+
+func (a *counterApp) Keys(req []byte) (reads, writes []string) {
+	op, k := splitCmd(req)
+	if op == "w" {
+		return nil, []string{k}
+	}
+	return []string{k}, nil
+}
+
+func splitCmd(req []byte) (op, k string) {
+	f := strings.Fields(string(req))
+	return f[0], f[1]
+}
+
+func TestExecuteParallel(t *testing.T) {
+	newServer := func(parallel bool) (*Server, *counterApp, *recordingHandler) {
+		a := &counterApp{counters: make(map[string]int)}
+		rh := &recordingHandler{}
+		s := execServer(a, rh)
+		if parallel {
+			s.exec = newExecutor(a, a, 4, 8)
+		}
+		return s, a, rh
+	}
+	seqServer, seqApp, seqResps := newServer(false)
+	parServer, parApp, parResps := newServer(true)
+
+	rnd := rand.New(rand.NewSource(1))
+	seqs := make(map[string]uint32)
+	slots := 0
+	for i := 0; i < 200; i++ {
+		val := &paxos.Value{Vt: paxos.App}
+		if rnd.Intn(10) == 0 {
+			val = &paxos.Value{Vt: paxos.Noop}
+			slots++
+		}
+		for j := rnd.Intn(8); val.Vt == paxos.App && j >= 0; j-- {
+			id := fmt.Sprintf("c%d", rnd.Intn(5))
+			seq := seqs[id]
+			if rnd.Intn(10) > 0 || seq == 0 {
+				seqs[id]++
+			} else {
+				seq-- // Resent
+			}
+			op := []string{"r", "w"}[rnd.Intn(2)]
+			req := sessionReq(id, seq, 0)
+			req.Val = []byte(fmt.Sprintf("%s k%d", op, rnd.Intn(4)))
+			val.Cr = append(val.Cr, req)
+			slots++
+		}
+		seqServer.handleDecidedVal(val, false)
+		parServer.handleDecidedVal(val, false)
+		if i%50 == 0 {
+			parServer.applyExecuted()
+		}
+	}
+	parServer.drainExecution()
+
+	if got := parServer.localAru.Value(); got != paxos.SlotID(slots) {
+		t.Errorf("local aru is %v, want %d", got, slots)
+	}
+	if len(parResps.resps) != len(seqResps.resps) {
+		t.Fatalf("got %d responses, want %d", len(parResps.resps), len(seqResps.resps))
+	}
+	for i, want := range seqResps.resps {
+		got := parResps.resps[i]
+		if got.GetId() != want.GetId() || got.GetSeq() != want.GetSeq() ||
+			got.GetErrorCode() != want.GetErrorCode() || !bytes.Equal(got.GetVal(), want.GetVal()) {
+			t.Errorf("response %d is %v, want %v", i, got, want)
+		}
+	}
+	for k, v := range seqApp.counters {
+		if parApp.counters[k] != v {
+			t.Errorf("counter %s is %d, want %d", k, parApp.counters[k], v)
+		}
+	}
+}
+
+func TestExecuteParallelNoop(t *testing.T) {
+	a := &counterApp{counters: make(map[string]int)}
+	s := execServer(a, &recordingHandler{})
+	s.exec = newExecutor(a, a, 2, 8)
+	req := sessionReq("c", 0, 0)
+	req.Val = []byte("w k")
+	s.handleDecidedVal(&paxos.Value{Vt: paxos.App, Cr: []*client.Request{req}}, false)
+	s.handleDecidedVal(&paxos.Value{Vt: paxos.Noop}, false)
+	s.drainExecution()
+	if got := s.localAru.Value(); got != 2 {
+		t.Errorf("local aru is %v, want 2", got)
+	}
+}
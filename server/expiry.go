@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/gob"
+	"sort"
+	"time"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/trace"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+func init() {
+	gob.Register(SessionExpiry{})
+}
+
+// A SessionExpiry carries an EXPIRE request from the leader to the other
+// replicas when running BatchPaxos.
+type SessionExpiry struct {
+	Req *client.Request
+}
+
+// expireIdleSessions proposes to end the sessions of the clients that the
+// leader has not heard from during the last sessionTimeout. The expiry takes
+// effect when the EXPIRE request is executed, so that every replica ends the
+// same sessions at the same slot.
+func (s *Server) expireIdleSessions() {
+	if s.pxLeader != s.id {
+		return
+	}
+	now := time.Now()
+	var idle []string
+	for id := range s.sessions.sessions {
+		last, found := s.lastActive[id]
+		if !found {
+			s.lastActive[id] = now
+			continue
+		}
+		if now.Sub(last) > s.sessionTimeout {
+			idle = append(idle, id)
+		}
+	}
+	for id, last := range s.lastActive {
+		if _, found := s.sessions.sessions[id]; !found && now.Sub(last) > s.sessionTimeout {
+			// Never registered, e.g. only sent keepalives
+			delete(s.lastActive, id)
+		}
+	}
+	if len(idle) == 0 {
+		return
+	}
+
+	sort.Strings(idle)
+	glog.V(2).Infof("proposing expiry of %d idle client sessions", len(idle))
+	req := client.NewExpireRequest(s.sessions.expiries, idle)
+	trace.RecordClientRequest(req)
+	if s.expiryChan != nil {
+		s.outBroadcast <- SessionExpiry{Req: req}
+	}
+	s.handleClientRequest(req)
+}
+
+// handleExpire executes a decided EXPIRE request.
+func (s *Server) handleExpire(req *client.Request) {
+	ids, err := req.ExpiredIDs()
+	if err != nil {
+		glog.Errorln("could not decode expired sessions:", err)
+		return
+	}
+	if !s.sessions.expire(req.GetSeq(), ids) {
+		return
+	}
+	glog.V(2).Infof("expired %d client sessions", len(ids))
+	for _, id := range ids {
+		delete(s.lastActive, id)
+	}
+	s.clientHandler.ExpireSessions(ids)
+}
@@ -132,7 +132,7 @@ func (s *Server) handlePreReconfigAsLeader(firstSlot paxos.SlotID) error {
 			//	return nil
 			//}
 		case req := <-reqChan:
-			s.propChan <- &paxos.Value{Vt: paxos.App, Cr: []*client.Request{req}}
+			s.propChan <- s.newAppValue([]*client.Request{req})
 			nrProposedToFill++
 			glog.V(2).Infof("have proposed %d of %d needed cmds before first slot",
 				nrProposedToFill, cmdsToFill)
@@ -2,6 +2,7 @@ package server
 
 import (
 	"strings"
+	"time"
 
 	"github.com/relab/goxos/arec"
 	"github.com/relab/goxos/authenticatedbc"
@@ -18,6 +19,7 @@ import (
 	"github.com/relab/goxos/reconfig"
 	"github.com/relab/goxos/reliablebc"
 	"github.com/relab/goxos/ringreplacer"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
 )
@@ -28,6 +30,7 @@ func (s *Server) InitModules() {
 	if glog.V(2) {
 		s.logInitInfo()
 	}
+	s.initTrace()
 	s.initGroupManager()
 	s.initNetwork()
 	s.initLiveness()
@@ -35,6 +38,11 @@ func (s *Server) InitModules() {
 	s.initRingReplacer()
 	s.initFailureHandling()
 	s.initClientHandler()
+	s.initSessions()
+	s.initExecutor()
+	s.initStateHash()
+	s.initReads()
+	s.initLocal()
 }
 
 func (s *Server) InitModulesReconfig() {
@@ -42,12 +50,18 @@ func (s *Server) InitModulesReconfig() {
 	if glog.V(2) {
 		s.logInitInfo()
 	}
+	s.initTrace()
 	s.initGroupManager()
 	s.initNetwork()
 	s.initLiveness()
 	s.initRingReplacer()
 	s.initFailureHandling()
 	s.initClientHandler()
+	s.initSessions()
+	s.initExecutor()
+	s.initStateHash()
+	s.initReads()
+	s.initLocal()
 }
 
 func (s *Server) logInitInfo() {
@@ -103,6 +117,18 @@ func (s *Server) initNodeMap() {
 	s.nodes = nodeMap
 }
 
+func (s *Server) initTrace() {
+	dir := s.config.GetString("traceDir", config.DefTraceDir)
+	if dir == "" {
+		return
+	}
+	if err := trace.Open(dir, trace.FileName(s.appID, s.id)); err != nil {
+		glog.Errorln("could not open trace file, inputs will not be recorded:", err)
+		return
+	}
+	glog.V(1).Infoln("recording replica inputs to", dir)
+}
+
 func (s *Server) initGroupManager() {
 	lrEnabled := strings.ToLower(s.config.GetString("failureHandlingType", config.DefFailureHandlingType)) == "livereplacement"
 	arEnabled := strings.ToLower(s.config.GetString("failureHandlingType", config.DefFailureHandlingType)) == "areconfiguration"
@@ -111,8 +137,9 @@ func (s *Server) initGroupManager() {
 
 func (s *Server) initNetwork() {
 	net.SetHeartbeatChan(s.heartbeatChan)
+	net.SetChunkSize(s.config.GetInt("netChunkSize", config.DefNetChunkSize))
 	s.dmx = net.NewTcpDemuxer(s.id, s.grpmgr, s.subModulesStopSync)
-	s.snd = net.NewSender(s.id, s.grpmgr, s.outUnicast, s.outBroadcast,
+	s.snd = net.NewSender(s.config, s.id, s.grpmgr, s.outUnicast, s.outBroadcast,
 		s.outProposer, s.outAcceptor, s.outLearner, s.dmx, s.subModulesStopSync)
 }
 
@@ -122,12 +149,19 @@ func (s *Server) initLiveness() {
 	case "authenticatedbc", "reliablebc":
 		return
 	default:
-		s.hbem = liveness.NewHbEm(s.config, s.id, s.snd.ResetChan(),
+		var resetChan <-chan bool
+		if s.snd != nil {
+			resetChan = s.snd.ResetChan()
+		}
+		s.hbem = liveness.NewHbEm(s.config, s.id, resetChan,
 			s.outBroadcast, s.subModulesStopSync)
 		s.fd = liveness.NewFd(s.id, s.grpmgr, s.config,
-			s.heartbeatChan, s.subModulesStopSync)
-		s.ld = liveness.NewMonarchicalLD(s.grpmgr, s.fd, s.subModulesStopSync)
+			s.heartbeatChan, s.outBroadcast, s.dmx, s.subModulesStopSync)
+		s.ld = liveness.NewLeaderDetector(s.config, s.grpmgr, s.fd,
+			func() uint64 { return uint64(s.localAru.Value()) },
+			s.outBroadcast, s.dmx, s.subModulesStopSync)
 		s.pxLeaderChan = s.ld.SubscribeToPaxosLdMsgs("server")
+		s.fdChan = s.fd.SubscribeToFdMsgs("status")
 	}
 }
 
@@ -137,6 +171,7 @@ func (s *Server) initPaxos() {
 		glog.Fatal("initPaxos: can't find self in nodemap")
 	}
 
+	s.rejectedChan = make(chan *client.Request, 64)
 	pp := &paxos.Pack{
 		ID:              s.id,
 		NrOfNodes:       s.nodes.NrOfNodes(),
@@ -156,6 +191,7 @@ func (s *Server) initPaxos() {
 		BcastL:          s.outLearner,
 		PropChan:        s.propChan,
 		DcdChan:         s.decidedChan,
+		RejectChan:      s.rejectedChan,
 		NewDcdChan:      s.propDcdChan,
 		DcdSlotIDToProp: make(chan paxos.SlotID, 32),
 		LocalAdu:        s.localAru,
@@ -217,6 +253,7 @@ func (s *Server) initClientHandler() {
 		s.clientHandler = client.NewClientHandlerTCP(
 			s.id,
 			protocol,
+			&s.config,
 			s.grpmgr,
 			s.ld,
 			s.clientReqChan,
@@ -225,6 +262,18 @@ func (s *Server) initClientHandler() {
 	}
 }
 
+func (s *Server) initSessions() {
+	s.sessionTimeout = s.config.GetDuration("sessionTimeout", config.DefSessionTimeout)
+	s.lastActive = make(map[string]time.Time)
+	protocol := s.config.GetString("protocol", config.DefProtocol)
+	if strings.TrimSpace(strings.ToLower(protocol)) == "batchpaxos" {
+		// BatchPaxos only decides requests received by a quorum of
+		// acceptors, so EXPIRE requests are sent to every replica.
+		s.expiryChan = make(chan SessionExpiry, 16)
+		s.dmx.RegisterChannel(s.expiryChan)
+	}
+}
+
 func (s *Server) initRingReplacer() {
 	s.ringReplacer = ringreplacer.NewRingReplacer(s.id, &s.config, s.appID, s.grpmgr, s.fd,
 		s.appStateReqChan, s.recMsgChan, s.subModulesStopSync)
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/net"
+	"github.com/relab/goxos/trace"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+var ErrServerStopped = errors.New("server stopped")
+
+func init() {
+	gob.Register(ForwardedRequest{})
+}
+
+// A ForwardedRequest carries a request proposed by an application embedded
+// in a replica that is not the leader. With BatchPaxos, it is sent to every
+// replica instead.
+type ForwardedRequest struct {
+	Req *client.Request
+}
+
+// How a replica gets the requests of an embedded application decided.
+type localRoute int
+
+const (
+	routeLeader    localRoute = iota // Forward to the leader
+	routeDirect                      // Propose locally
+	routeBroadcast                   // Propose at every replica
+)
+
+// A localClient is the session of the application embedded in a replica.
+// Its requests are passed to the server directly, and its responses are
+// taken from the execution at this replica, so no client connection is
+// needed. The session is renewed with a new id if it expires.
+type localClient struct {
+	reqChan        chan *client.Request
+	resendInterval time.Duration
+	prefix         string // Of ids, unique to this replica
+
+	mu      sync.Mutex // Guards the fields below
+	id      string
+	seq     uint32
+	props   map[uint32]*Proposal
+	stopped bool
+}
+
+// A Proposal is the pending result of a command proposed with
+// Server.Propose.
+type Proposal struct {
+	req    *client.Request
+	once   sync.Once
+	done   chan struct{}
+	resend chan struct{}
+	val    []byte
+	err    error
+}
+
+func (p *Proposal) complete(val []byte, err error) {
+	p.once.Do(func() {
+		p.val, p.err = val, err
+		close(p.done)
+	})
+}
+
+// Done returns a channel that is closed when the result is ready.
+func (p *Proposal) Done() <-chan struct{} {
+	return p.done
+}
+
+// Result waits for the result of the proposal.
+func (p *Proposal) Result() ([]byte, error) {
+	<-p.done
+	return p.val, p.err
+}
+
+func (s *Server) initLocal() {
+	s.local = newLocalClient(fmt.Sprintf("%s/%v", s.appID, s.id),
+		s.config.GetDuration("resendInterval", config.DefResendInterval))
+	switch strings.TrimSpace(strings.ToLower(s.config.GetString("protocol", config.DefProtocol))) {
+	case "fastpaxos":
+		s.localRoute = routeDirect
+	case "batchpaxos":
+		s.localRoute = routeBroadcast
+	default:
+		s.localRoute = routeLeader
+	}
+	s.forwardChan = make(chan ForwardedRequest, 64)
+	s.dmx.RegisterChannel(s.forwardChan)
+}
+
+func newLocalClient(prefix string, resendInterval time.Duration) *localClient {
+	lc := &localClient{
+		reqChan:        make(chan *client.Request, 64),
+		resendInterval: resendInterval,
+		prefix:         prefix,
+		props:          make(map[uint32]*Proposal),
+	}
+	lc.id = lc.newID()
+	return lc
+}
+
+// newID returns a client id for the embedded application that no other
+// replica, incarnation or session uses.
+func (lc *localClient) newID() string {
+	return fmt.Sprintf("%s/%d", lc.prefix, time.Now().UnixNano())
+}
+
+// Propose proposes cmd for execution by the replicated application, without
+// waiting for the result. The command is resent until it has been executed
+// at this replica, or ctx is done.
+func (s *Server) Propose(ctx context.Context, cmd []byte) *Proposal {
+	p := &Proposal{done: make(chan struct{}), resend: make(chan struct{}, 1)}
+	if s.local == nil {
+		p.complete(nil, ErrServerStopped)
+		return p
+	}
+	lc := s.local
+	lc.mu.Lock()
+	if lc.stopped {
+		lc.mu.Unlock()
+		p.complete(nil, ErrServerStopped)
+		return p
+	}
+	seq := lc.seq
+	lc.seq++
+	ack := seq
+	for outstanding := range lc.props {
+		if outstanding < ack {
+			ack = outstanding
+		}
+	}
+	id := lc.id
+	p.req = &client.Request{
+		Type: client.Request_EXEC.Enum(),
+		Id:   &id,
+		Seq:  &seq,
+		Val:  cmd,
+		Ack:  &ack,
+	}
+	lc.props[seq] = p
+	lc.mu.Unlock()
+
+	go s.runProposal(ctx, p)
+	return p
+}
+
+func (s *Server) runProposal(ctx context.Context, p *Proposal) {
+	lc := s.local
+	defer lc.forget(p)
+	resend := time.NewTimer(lc.resendInterval)
+	defer resend.Stop()
+	for {
+		lc.mu.Lock()
+		req := p.req // Replaced if the session is renewed
+		lc.mu.Unlock()
+		select {
+		case lc.reqChan <- req:
+		case <-p.done:
+			return
+		case <-ctx.Done():
+			p.complete(nil, ctx.Err())
+			return
+		}
+		resend.Reset(lc.resendInterval)
+		select {
+		case <-p.done:
+			return
+		case <-ctx.Done():
+			p.complete(nil, ctx.Err())
+			return
+		case <-p.resend:
+		case <-resend.C:
+		}
+	}
+}
+
+// localReqChan returns the channel of requests from the embedded
+// application, or nil if there is none.
+func (s *Server) localReqChan() <-chan *client.Request {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.reqChan
+}
+
+// handleLocalRequest gets a request of the embedded application decided. A
+// quarantined replica forwards the request to the leader instead of
+// proposing it.
+func (s *Server) handleLocalRequest(req *client.Request) {
+	switch {
+	case s.localRoute == routeBroadcast:
+		// Delivered to this replica too, as a ForwardedRequest
+		s.outBroadcast <- ForwardedRequest{Req: req}
+		return
+	case s.localRoute == routeLeader && s.pxLeader != s.id, s.quarantined():
+		if s.pxLeader == s.id {
+			// Resent once leadership has been given up
+			return
+		}
+		if s.pxLeader == grp.UndefinedID() {
+			// Resent when a leader has been elected
+			return
+		}
+		s.outUnicast <- net.Packet{DestID: s.pxLeader, Data: ForwardedRequest{Req: req}}
+		return
+	}
+	trace.RecordClientRequest(req)
+	s.handleClientRequest(req)
+}
+
+// handleForwardedRequest proposes a request from the embedded application
+// of another replica. Requests reaching a replica that is no longer the
+// leader, or that is quarantined, are dropped; the sending replica resends
+// them.
+func (s *Server) handleForwardedRequest(fr ForwardedRequest) {
+	if s.localRoute == routeLeader && s.pxLeader != s.id || s.quarantined() {
+		return
+	}
+	trace.RecordClientRequest(fr.Req)
+	s.handleClientRequest(fr.Req)
+}
+
+// respond delivers the response to an executed request, either to the
+// embedded application or to the client connected to this replica.
+func (s *Server) respond(resp *client.Response) {
+	if s.quarantined() {
+		// The response comes from a diverged state.
+		return
+	}
+	if s.local != nil && s.local.complete(resp) {
+		return
+	}
+	s.clientHandler.ForwardResponse(resp)
+}
+
+// complete completes the proposal resp is the response to. It returns false
+// if resp is for another client.
+func (lc *localClient) complete(resp *client.Response) bool {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if resp.GetId() != lc.id {
+		return false
+	}
+	p, found := lc.props[resp.GetSeq()]
+	if !found {
+		return true
+	}
+	switch resp.GetErrorCode() {
+	case client.Response_NONE:
+		p.complete(resp.GetVal(), nil)
+	case client.Response_OLD_CMD:
+		// Its response was dropped from the session table
+		p.complete(nil, client.ErrOldCommand)
+	case client.Response_SESSION_EXPIRED:
+		lc.renew()
+		return true
+	default:
+		p.complete(nil, &client.ReplicaError{Code: resp.GetErrorCode(), Detail: resp.GetErrorDetail()})
+	}
+	delete(lc.props, resp.GetSeq())
+	return true
+}
+
+// renew starts a new session after ours has expired, and resends the
+// outstanding proposals in it. Must be called with mu held.
+func (lc *localClient) renew() {
+	old := lc.id
+	lc.id = lc.newID()
+	glog.V(2).Infof("local session %s expired, renewing as %s", old, lc.id)
+	props := lc.props
+	lc.props = make(map[uint32]*Proposal)
+	lc.seq = 0
+	for _, p := range props {
+		id, seq, ack := lc.id, lc.seq, uint32(0)
+		lc.seq++
+		p.req = &client.Request{Type: client.Request_EXEC.Enum(), Id: &id, Seq: &seq, Val: p.req.Val, Ack: &ack}
+		lc.props[seq] = p
+		select {
+		case p.resend <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (lc *localClient) forget(p *Proposal) {
+	lc.mu.Lock()
+	if lc.props[p.req.GetSeq()] == p {
+		delete(lc.props, p.req.GetSeq())
+	}
+	lc.mu.Unlock()
+}
+
+// resendAll resends the outstanding proposals, e.g. to a new leader.
+func (lc *localClient) resendAll() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for _, p := range lc.props {
+		select {
+		case p.resend <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stop fails the outstanding proposals.
+func (lc *localClient) stop() {
+	lc.mu.Lock()
+	lc.stopped = true
+	props := lc.props
+	lc.props = make(map[uint32]*Proposal)
+	lc.mu.Unlock()
+	for _, p := range props {
+		p.complete(nil, ErrServerStopped)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/paxos"
+)
+
+func TestLocalPropose(t *testing.T) {
+	s := &Server{local: newLocalClient("app/0", time.Hour)}
+	p := s.Propose(context.Background(), []byte("x"))
+	req := <-s.local.reqChan
+
+	// Responses to other clients are not taken
+	other := genRespForReq(sessionReq("other", req.GetSeq(), 0), nil)
+	if s.local.complete(other) {
+		t.Error("response to other client completed a proposal")
+	}
+
+	if !s.local.complete(genRespForReq(req, []byte("r"))) {
+		t.Fatal("response to proposal was not taken")
+	}
+	resp, err := p.Result()
+	if err != nil || !bytes.Equal(resp, []byte("r")) {
+		t.Errorf("got %q, %v, want %q", resp, err, "r")
+	}
+}
+
+func TestLocalProposeSessionExpired(t *testing.T) {
+	s := &Server{local: newLocalClient("app/0", time.Hour)}
+	p := s.Propose(context.Background(), []byte("x"))
+	req := <-s.local.reqChan
+	id := req.GetId()
+
+	s.local.complete(genErrRespForReq(req, client.Response_SESSION_EXPIRED))
+	var resent *client.Request
+	select {
+	case resent = <-s.local.reqChan:
+	case <-time.After(time.Second):
+		t.Fatal("proposal was not resent after the session expired")
+	}
+	if resent.GetId() == id || resent.GetSeq() != 0 || !bytes.Equal(resent.GetVal(), []byte("x")) {
+		t.Errorf("got resent request %v, want seq 0 in a new session", resent)
+	}
+	s.local.complete(genRespForReq(resent, nil))
+	if _, err := p.Result(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalProposeStopped(t *testing.T) {
+	s := &Server{local: newLocalClient("app/0", time.Hour)}
+	p := s.Propose(context.Background(), []byte("x"))
+	<-s.local.reqChan
+	s.local.stop()
+	if _, err := p.Result(); err != ErrServerStopped {
+		t.Errorf("got error %v, want %v", err, ErrServerStopped)
+	}
+	if _, err := s.Propose(context.Background(), nil).Result(); err != ErrServerStopped {
+		t.Errorf("got error %v after stop, want %v", err, ErrServerStopped)
+	}
+}
+
+func TestLocalRouteBroadcast(t *testing.T) {
+	s := &Server{
+		localRoute:   routeBroadcast,
+		outBroadcast: make(chan interface{}, 8),
+		propChan:     make(chan *paxos.Value, 8),
+		batchMaxSize: 1,
+		sessions:     newSessionTable(0, 0),
+		lastActive:   make(map[string]time.Time),
+	}
+	req := sessionReq("app/0", 0, 0)
+	s.handleLocalRequest(req)
+	if fr, ok := (<-s.outBroadcast).(ForwardedRequest); !ok || fr.Req != req {
+		t.Fatal("request was not broadcast")
+	}
+	// The broadcast reaches this replica too, and is proposed from there
+	if len(s.propChan) != 0 {
+		t.Error("broadcast request was also proposed directly")
+	}
+}
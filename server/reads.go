@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/gob"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+func init() {
+	gob.Register(ReadStatus{})
+}
+
+// A ReadStatus is broadcast periodically by every replica when follower reads
+// are enabled, so that the others learn how far the group has decided.
+// Decided is the highest slot the sender has learned to be decided.
+type ReadStatus struct {
+	ID      grp.ID
+	Decided paxos.SlotID
+}
+
+// How many read status intervals a ReadStatus is used for.
+const readStatusLifetime = 3
+
+// A readReport is the latest ReadStatus of a replica, and when it arrived.
+type readReport struct {
+	decided paxos.SlotID
+	at      time.Time
+}
+
+// A pendingRead is a read waiting for the replica to catch up.
+type pendingRead struct {
+	req      *client.Request
+	deadline time.Time
+}
+
+func (s *Server) initReads() {
+	s.followerReads = s.config.GetBool("followerReads", config.DefFollowerReads)
+	s.readWaitTimeout = s.config.GetDuration("readWaitTimeout", config.DefReadWaitTimeout)
+	s.readStatusInterval = s.config.GetDuration("readStatusInterval", config.DefReadStatusInterval)
+	s.readReports = make(map[grp.ID]readReport)
+	if s.followerReads {
+		s.readStatusChan = make(chan ReadStatus, 64)
+		s.dmx.RegisterChannel(s.readStatusChan)
+	}
+}
+
+// handleRead serves a read-only request from the applied state if this
+// replica is recent enough for the staleness bound of the request. If not,
+// the read waits for at most readWaitTimeout before the client is redirected
+// to the leader. Without follower reads, every read is redirected, and the
+// client sends it through the log instead: the leader cannot tell from its
+// own state whether it has been deposed.
+func (s *Server) handleRead(req *client.Request) {
+	if !s.canRead(req) || !s.followerReads || s.quarantined() {
+		s.redirect(req)
+		return
+	}
+	if s.fresh(req) {
+		s.serveRead(req)
+		return
+	}
+	s.pendingReads = append(s.pendingReads,
+		pendingRead{req: req, deadline: time.Now().Add(s.readWaitTimeout)})
+}
+
+// canRead returns true if the application can answer req without it being
+// ordered.
+func (s *Server) canRead(req *client.Request) bool {
+	switch r := s.ah.(type) {
+	case app.PartialReader:
+		return r.CanRead(req.GetVal())
+	case app.Reader:
+		return true
+	}
+	return false
+}
+
+// fresh returns true if the applied state satisfies the staleness bound of
+// req. A read may lag MaxStaleSlots behind the highest slot known to be
+// decided, or be served if this replica was up to date within the last
+// MaxStaleMs milliseconds. Without a bound, the replica must be up to date.
+// If the highest decided slot is not known, only the time bound applies.
+func (s *Server) fresh(req *client.Request) bool {
+	adu := s.localAru.Value()
+	latest, known := s.latestDecided()
+	if known && adu >= latest {
+		s.upToDateAt = time.Now()
+		return true
+	}
+	if known && req.MaxStaleSlots != nil && adu+paxos.SlotID(req.GetMaxStaleSlots()) >= latest {
+		return true
+	}
+	if req.MaxStaleMs != nil && !s.upToDateAt.IsZero() &&
+		time.Since(s.upToDateAt) <= time.Duration(req.GetMaxStaleMs())*time.Millisecond {
+		return true
+	}
+	return false
+}
+
+func (s *Server) serveRead(req *client.Request) {
+	s.drainExecution()
+	resp := s.ah.(app.Reader).Read(req.GetVal())
+	s.clientHandler.ForwardResponse(genRespForReq(req, resp))
+}
+
+// redirect sends the client of req to the leader. The request is dropped if
+// there is no other replica to send it to.
+func (s *Server) redirect(req *client.Request) {
+	leader, found := s.grpmgr.NodeMap().LookupNode(s.pxLeader)
+	if !found || s.pxLeader == s.id {
+		glog.Warningln("no leader to redirect to,", req.SimpleString())
+		s.clientHandler.DropRequest(req)
+		return
+	}
+	resp := genErrRespForReq(req, client.Response_REDIRECT)
+	addr := leader.ClientAddr()
+	resp.ErrorDetail = &addr
+	s.clientHandler.ForwardResponse(resp)
+}
+
+// servePendingReads serves the waiting reads that have become fresh, and
+// redirects those that have waited too long.
+func (s *Server) servePendingReads() {
+	if len(s.pendingReads) == 0 {
+		return
+	}
+	now := time.Now()
+	waiting := s.pendingReads[:0]
+	for _, pr := range s.pendingReads {
+		switch {
+		case s.fresh(pr.req):
+			s.serveRead(pr.req)
+		case now.After(pr.deadline):
+			s.redirect(pr.req)
+		default:
+			waiting = append(waiting, pr)
+		}
+	}
+	s.pendingReads = waiting
+}
+
+// latestDecided returns the highest slot known to be decided, from the read
+// statuses received within the last readStatusLifetime intervals. It is only
+// known if they come from a quorum, counting ourselves, or from the leader,
+// so that a replica cut off from the others does not take itself to be up to
+// date.
+func (s *Server) latestDecided() (paxos.SlotID, bool) {
+	latest := s.executedThrough()
+	since := time.Now().Add(-readStatusLifetime * s.readStatusInterval)
+	reports, fromLeader := uint(1), false
+	for id, r := range s.readReports {
+		if id == s.id || r.at.Before(since) {
+			continue
+		}
+		reports++
+		fromLeader = fromLeader || id == s.pxLeader
+		if r.decided > latest {
+			latest = r.decided
+		}
+	}
+	return latest, fromLeader || reports >= s.grpmgr.Quorum()
+}
+
+func (s *Server) handleReadStatus(status ReadStatus) {
+	s.readReports[status.ID] = readReport{decided: status.Decided, at: time.Now()}
+	s.servePendingReads()
+}
+
+// broadcastReadStatus tells the other replicas how far we have learned the
+// decided slots.
+func (s *Server) broadcastReadStatus() {
+	s.outBroadcast <- ReadStatus{ID: s.id, Decided: s.executedThrough()}
+	s.servePendingReads()
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/app/mux"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
+)
+
+func readReq(staleSlots uint64, staleMs uint64) *client.Request {
+	req := &client.Request{Type: client.Request_READ.Enum()}
+	if staleSlots > 0 {
+		req.MaxStaleSlots = &staleSlots
+	}
+	if staleMs > 0 {
+		req.MaxStaleMs = &staleMs
+	}
+	return req
+}
+
+func readServer() *Server {
+	id0, id1, id2 := grp.NewID(0, 0), grp.NewID(1, 0), grp.NewID(2, 0)
+	s := &Server{
+		id:                 id0,
+		pxLeader:           id2,
+		grpmgr:             grp.NewGrpMgr(id0, grp.NewNodeMap(statusNodes(id0, id1, id2)), false, false, new(sync.WaitGroup)),
+		localAru:           &paxos.Adu{},
+		readStatusInterval: time.Hour,
+		readReports:        make(map[grp.ID]readReport),
+	}
+	for i := 0; i < 10; i++ {
+		s.localAru.Increment()
+	}
+	return s
+}
+
+// execServer returns a Server executing decided values with a, and sending
+// the responses to ch.
+func execServer(a app.Handler, ch client.ClientHandler) *Server {
+	return &Server{ah: a, sessions: newSessionTable(0, 0), clientHandler: ch, localAru: &paxos.Adu{}}
+}
+
+func TestReadFreshness(t *testing.T) {
+	s := readServer()
+	if s.fresh(readReq(0, 0)) {
+		t.Fatal("replica served read without knowing the latest decided slot")
+	}
+
+	s.handleReadStatus(ReadStatus{ID: grp.NewID(1, 0), Decided: 10})
+	if !s.fresh(readReq(0, 0)) {
+		t.Fatal("up to date replica refused read")
+	}
+
+	s.handleReadStatus(ReadStatus{ID: grp.NewID(1, 0), Decided: 15})
+	if latest, _ := s.latestDecided(); latest != 15 {
+		t.Fatalf("got latest decided slot %d, want 15", latest)
+	}
+	if s.fresh(readReq(0, 0)) {
+		t.Error("lagging replica served read without staleness bound")
+	}
+	if s.fresh(readReq(4, 0)) {
+		t.Error("replica lagging 5 slots served read with bound 4")
+	}
+	if !s.fresh(readReq(5, 0)) {
+		t.Error("replica lagging 5 slots refused read with bound 5")
+	}
+	if !s.fresh(readReq(0, 1000)) {
+		t.Error("replica up to date just now refused read with time bound")
+	}
+	s.upToDateAt = time.Now().Add(-2 * time.Second)
+	if s.fresh(readReq(0, 1000)) {
+		t.Error("replica up to date 2s ago served read with bound 1s")
+	}
+}
+
+func TestReadStatusExpiry(t *testing.T) {
+	s := readServer()
+	s.readStatusInterval = time.Millisecond
+
+	// A status from the leader alone is enough
+	s.handleReadStatus(ReadStatus{ID: s.pxLeader, Decided: 10})
+	if !s.fresh(readReq(0, 0)) {
+		t.Fatal("replica refused read after status from the leader")
+	}
+
+	// A replica that stops hearing from the others no longer knows how
+	// far the group has decided
+	time.Sleep(10 * time.Millisecond)
+	if _, known := s.latestDecided(); known {
+		t.Error("latest decided slot known from expired statuses")
+	}
+	if s.fresh(readReq(5, 0)) {
+		t.Error("cut off replica served read with slot bound")
+	}
+}
+
+// readApp is a batchApp that answers reads with the request.
+type readApp struct {
+	batchApp
+}
+
+func (a *readApp) Read(req []byte) []byte { return req }
+
+func TestReadPartialReader(t *testing.T) {
+	s := readServer()
+	rh := &recordingHandler{}
+	s.clientHandler = rh
+	s.followerReads = true
+	s.ah = mux.New().Add("r", &readApp{}).Add("w", &batchApp{})
+	s.handleReadStatus(ReadStatus{ID: s.pxLeader, Decided: 10})
+
+	for _, c := range []struct {
+		name string
+		code client.Response_Error
+	}{
+		{"r", client.Response_NONE},
+		{"w", client.Response_REDIRECT},
+	} {
+		req := readReq(0, 0)
+		req.Val = mux.Request(c.name, []byte("x"))
+		s.handleRead(req)
+		if len(rh.resps) != 1 {
+			t.Fatalf("read for %q got %d responses, want 1", c.name, len(rh.resps))
+		}
+		if code := rh.resps[0].GetErrorCode(); code != c.code {
+			t.Errorf("read for %q got %v, want %v", c.name, code, c.code)
+		}
+		rh.resps = nil
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/net"
+	"github.com/relab/goxos/trace"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// InitModulesReplay initializes the modules needed to replay a recorded
+// trace. The replica neither listens for nor connects to other replicas and
+// clients, and every message sent to other replicas is discarded. The ring
+// replacer is not run, since it would try to initialize standby nodes.
+func (s *Server) InitModulesReplay() {
+	glog.V(1).Info("initializing submodules for replay")
+	if glog.V(2) {
+		s.logInitInfo()
+	}
+	s.replaying = true
+	s.replayBatchTimeout = make(chan bool)
+	s.replayStamps = make(chan trace.ValueStamp, 16)
+	s.replaySinkStop = make(chan bool)
+	s.initGroupManager()
+	s.dmx = net.NewReplayDemuxer(s.subModulesStopSync)
+	s.initLiveness()
+	if s.fd != nil {
+		s.fd.EnableReplay()
+	}
+	s.initPaxos()
+	s.initFailureHandling()
+	s.clientHandler = &client.ClientHandlerMock{}
+	s.initSessions()
+	s.initExecutor()
+	s.initStateHash()
+}
+
+// Replay starts the submodules initialized by InitModulesReplay and feeds
+// them the entries read from r, in recorded order. Replay returns when every
+// entry has been replayed; the replica keeps running so that its state can
+// be inspected.
+func (s *Server) Replay(r *trace.Reader) error {
+	glog.V(1).Info("starting submodules for replay")
+	s.grpmgrStart()
+	s.subModulesStopSync.Add(1)
+	s.dmx.Start()
+	s.startReplaySink()
+	s.paxosStart()
+	s.failureHandlingStart()
+	s.livenessStart()
+	go s.run()
+
+	var replayed uint64
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			glog.V(1).Infof("replay done, %d entries replayed", replayed)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if glog.V(3) {
+			glog.Infoln("replaying", entry)
+		}
+		if err = s.replayEntry(entry); err != nil {
+			return err
+		}
+		replayed++
+	}
+}
+
+func (s *Server) replayEntry(entry trace.Entry) error {
+	switch entry.Kind {
+	case trace.Message:
+		s.dmx.HandleMessage(entry.Msg)
+	case trace.Alive:
+		s.heartbeatChan <- entry.ID
+	case trace.ClientRequest:
+		req, ok := entry.Msg.(*client.Request)
+		if !ok {
+			return fmt.Errorf("replay: entry %d: unexpected client request type %T",
+				entry.Seq, entry.Msg)
+		}
+		s.clientReqChan <- req
+	case trace.Timer:
+		switch entry.Name {
+		case trace.FdTimer:
+			if s.fd != nil {
+				s.fd.Timeout()
+			}
+		case trace.BatchTimer:
+			s.replayBatchTimeout <- true
+		default:
+			glog.Warningf("replay: entry %d: unknown timer %q", entry.Seq, entry.Name)
+		}
+	case trace.Trust:
+		s.checkReplayedTrust(entry)
+	case trace.Stamp:
+		stamp, ok := entry.Msg.(trace.ValueStamp)
+		if !ok {
+			return fmt.Errorf("replay: entry %d: unexpected stamp type %T",
+				entry.Seq, entry.Msg)
+		}
+		s.replayStamps <- stamp
+	default:
+		glog.Warningf("replay: entry %d: unknown kind %v", entry.Seq, entry.Kind)
+	}
+	return nil
+}
+
+// checkReplayedTrust compares a recorded trust event with the leader
+// currently trusted by the replayed leader detector. The leader detector
+// derives trust from the replayed failure detector inputs, so a difference
+// only means that the replay has not caught up yet or has diverged.
+func (s *Server) checkReplayedTrust(entry trace.Entry) {
+	if s.ld == nil {
+		return
+	}
+	var trusted grp.ID
+	switch entry.Name {
+	case trace.PaxosTrust:
+		trusted = s.ld.PaxosLeader()
+	case trace.ReplacementTrust:
+		trusted = s.ld.ReplacementLeader()
+	default:
+		return
+	}
+	if trusted != entry.ID {
+		glog.V(1).Infof("replay: entry %d: recorded %v leader %v, currently trusting %v",
+			entry.Seq, entry.Name, entry.ID, trusted)
+	}
+}
+
+// startReplaySink discards every message sent to other replicas.
+func (s *Server) startReplaySink() {
+	s.subModulesStopSync.Add(1)
+	go func() {
+		defer s.subModulesStopSync.Done()
+		for {
+			select {
+			case <-s.outUnicast:
+			case <-s.outBroadcast:
+			case <-s.outProposer:
+			case <-s.outAcceptor:
+			case <-s.outLearner:
+			case <-s.replaySinkStop:
+				return
+			}
+		}
+	}()
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/relab/goxos/config"
 	"github.com/relab/goxos/grp"
 	"github.com/relab/goxos/paxos"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/elog"
 	e "github.com/relab/goxos/elog/event"
@@ -30,28 +33,87 @@ func (s *Server) run() {
 	default:
 		s.pxLeader = s.ld.PaxosLeader()
 	}
+	s.status.Lock()
+	s.status.status.Leader = s.pxLeader
+	s.status.Unlock()
+	statusStop := make(chan struct{})
+	defer close(statusStop)
+	go s.watchStatus(statusStop)
+
+	var sessionTick <-chan time.Time
+	if s.sessionTimeout > 0 && !s.replaying {
+		ticker := time.NewTicker(s.sessionTimeout / 4)
+		defer ticker.Stop()
+		sessionTick = ticker.C
+	}
+
+	var readStatusTick <-chan time.Time
+	if s.followerReads && !s.replaying {
+		ticker := time.NewTicker(s.readStatusInterval)
+		defer ticker.Stop()
+		readStatusTick = ticker.C
+	}
 
 	for {
 		select {
 		case pxLeaderID := <-s.pxLeaderChan:
+			if pxLeaderID == s.id && s.pxLeader != s.id {
+				// We do not know when clients of the old leader
+				// were last active, so give them a full timeout.
+				s.lastActive = make(map[string]time.Time)
+			}
 			s.pxLeader = pxLeaderID
+			s.setLeader(pxLeaderID)
+			if s.quarantined() {
+				s.giveUpLeadership()
+			}
+			if s.local != nil {
+				s.local.resendAll()
+			}
 		case req := <-s.clientReqChan:
-			// Shortcut if batching turned off:
-			if s.batchMaxSize == 1 {
-				s.propChan <- &paxos.Value{Vt: paxos.App, Cr: []*client.Request{req}}
-				continue
+			if req.GetType() == client.Request_READ {
+				// Reads do not change the state, so they
+				// are neither traced nor decided.
+				s.handleRead(req)
+				break
 			}
-
-			s.appendToBatch(req)
-			if s.batchNextIndex == s.batchMaxSize {
-				s.sendBatch()
+			if s.quarantined() {
+				s.redirect(req)
+				break
 			}
+			trace.RecordClientRequest(req)
+			s.handleClientRequest(req)
+		case req := <-s.localReqChan():
+			s.handleLocalRequest(req)
+		case fr := <-s.forwardChan:
+			s.handleForwardedRequest(fr)
+		case msg := <-s.expiryChan:
+			s.handleClientRequest(msg.Req)
+		case <-sessionTick:
+			s.expireIdleSessions()
+		case d := <-s.hashes.digestChan:
+			s.handleStateDigest(d)
+		case status := <-s.readStatusChan:
+			s.handleReadStatus(status)
+		case <-readStatusTick:
+			s.broadcastReadStatus()
 		case <-s.batchTimer.C:
+			trace.RecordTimer(trace.BatchTimer)
+			s.sendBatch()
+		case <-s.replayBatchTimeout:
 			s.sendBatch()
 		case reconfigCmd := <-s.reconfigCmdChan:
 			s.propChan <- &paxos.Value{Vt: paxos.Reconfig, Rc: &reconfigCmd}
+		case version := <-s.upgradeChan:
+			s.propChan <- &paxos.Value{Vt: paxos.Upgrade, Version: version}
+		case req := <-s.rejectedChan:
+			s.respond(genErrRespForReq(req, client.Response_SESSION_EXPIRED))
 		case val := <-s.decidedChan:
 			s.handleDecidedVal(val, true)
+			s.servePendingReads()
+		case <-s.finishedChan():
+			s.applyExecuted()
+			s.servePendingReads()
 		case asreq := <-s.appStateReqChan:
 			s.handleAppStateReq(asreq)
 		case <-s.stopChan:
@@ -61,10 +123,39 @@ func (s *Server) run() {
 	}
 }
 
+func (s *Server) handleClientRequest(req *client.Request) {
+	switch req.GetType() {
+	case client.Request_KEEPALIVE:
+		s.lastActive[req.GetId()] = time.Now()
+		return
+	case client.Request_EXEC:
+		if s.sessions.ended(req.GetId()) {
+			// Rejected here rather than when executed, since
+			// BatchPaxos cannot order them.
+			s.respond(genErrRespForReq(req, client.Response_SESSION_EXPIRED))
+			return
+		}
+		s.lastActive[req.GetId()] = time.Now()
+	}
+
+	// Shortcut if batching turned off:
+	if s.batchMaxSize == 1 {
+		s.propChan <- s.newAppValue([]*client.Request{req})
+		return
+	}
+
+	s.appendToBatch(req)
+	if s.batchNextIndex == s.batchMaxSize {
+		s.sendBatch()
+	}
+}
+
 func (s *Server) appendToBatch(req *client.Request) {
 	if s.batchNextIndex == 0 {
 		s.batchBuffer = make([]*client.Request, s.batchMaxSize)
-		s.batchTimer.Reset(s.batchTimeout)
+		if !s.replaying {
+			s.batchTimer.Reset(s.batchTimeout)
+		}
 	}
 	s.batchBuffer[s.batchNextIndex] = req
 	s.batchNextIndex++
@@ -77,7 +168,7 @@ func (s *Server) sendBatch() {
 		return
 	}
 
-	s.propChan <- &paxos.Value{Vt: paxos.App, Cr: s.batchBuffer[0:s.batchNextIndex]}
+	s.propChan <- s.newAppValue(s.batchBuffer[0:s.batchNextIndex])
 	s.batchNextIndex = 0
 }
 
@@ -88,19 +179,29 @@ func (s *Server) handleDecidedVal(val *paxos.Value, informProp bool) {
 
 	switch val.Vt {
 	case paxos.Noop:
-		s.localAru.Increment()
+		s.advance(nil)
 		if informProp {
 			s.propDcdChan <- true
 		}
 	case paxos.App:
-		for i := range val.Cr {
-			appresp := s.ah.Execute(val.Cr[i].GetVal())
-			if glog.V(3) {
-				glog.Info("application generated response")
+		now := s.tick(val)
+		bh, batch := s.ah.(app.BatchHandler)
+		if _, withCtx := s.ah.(app.ContextHandler); withCtx {
+			batch = false
+		}
+		if s.exec != nil {
+			s.executeParallel(val, now)
+		} else if batch {
+			s.executeBatch(bh, val, now)
+		} else {
+			for i := range val.Cr {
+				if val.Cr[i].GetType() == client.Request_EXPIRE {
+					s.handleExpire(val.Cr[i])
+				} else {
+					s.respond(s.execute(val.Cr[i], execCtx(val, now, i)))
+				}
+				s.localAru.Increment()
 			}
-			cresp := genRespForReq(val.Cr[i], appresp)
-			s.clientHandler.ForwardResponse(cresp)
-			s.localAru.Increment()
 		}
 		if informProp {
 			s.propDcdChan <- true
@@ -113,20 +214,67 @@ func (s *Server) handleDecidedVal(val *paxos.Value, informProp bool) {
 		// node only execute a reconfiguration command if
 		// the slot id is larger or equal to its firstSlot.
 		//s.localAru.Increment()
+		s.drainExecution()
 		if s.localAru.Value() >= s.firstSlot-1 {
 			s.handleReconfigCmd(val.Rc)
 			elog.Log(e.NewEvent(e.ReconfigDone))
+			s.updateMembership()
 		}
 		s.localAru.Increment()
+	case paxos.Upgrade:
+		s.drainExecution()
+		s.upgrade(val.Version)
+		s.localAru.Increment()
+		if informProp {
+			s.propDcdChan <- true
+		}
 	default:
 		glog.Fatal("received decided value of unkown type")
 	}
 
+	s.checkStateHash()
+
 	if glog.V(3) {
 		glog.Infoln("localaru incremented to", s.localAru)
 	}
 }
 
+// execute executes req in ctx unless the session table shows that it has
+// been executed before, in which case the cached response is returned.
+func (s *Server) execute(req *client.Request, ctx app.ExecCtx) *client.Response {
+	cached, status := s.sessions.lookup(req)
+	switch status {
+	case cmdOld:
+		if glog.V(3) {
+			glog.Infoln("ignoring old command", req.SimpleString())
+		}
+		return genErrRespForReq(req, client.Response_OLD_CMD)
+	case cmdExpired:
+		if glog.V(3) {
+			glog.Infoln("rejecting command from expired session,", req.SimpleString())
+		}
+		return genErrRespForReq(req, client.Response_SESSION_EXPIRED)
+	case cmdExecuted:
+		if glog.V(3) {
+			glog.Infoln("command already executed, resending response to",
+				req.SimpleString())
+		}
+		return genRespForReq(req, cached)
+	}
+	appresp := executeApp(s.ah, ctx, req.GetVal())
+	if glog.V(3) {
+		glog.Info("application generated response")
+	}
+	s.sessions.record(req, appresp)
+	return genRespForReq(req, appresp)
+}
+
+func genErrRespForReq(req *client.Request, code client.Response_Error) *client.Response {
+	resp := genRespForReq(req, nil)
+	resp.ErrorCode = code.Enum()
+	return resp
+}
+
 func genRespForReq(req *client.Request, appresp []byte) *client.Response {
 	var resp client.Response
 	id := req.GetId()
@@ -139,15 +287,58 @@ func genRespForReq(req *client.Request, appresp []byte) *client.Response {
 }
 
 func (s *Server) handleAppStateReq(asreq app.StateReq) {
+	s.drainExecution()
 	glog.V(2).Infoln("requesting state from application",
 		"with slot marker:", s.localAru)
-	slotMarker, state := s.ah.GetState(uint(s.localAru.Value()))
-	glog.V(2).Infoln("received state from application,",
-		"size was", len(state), "bytes and slot marker", slotMarker)
-	appState := app.NewState(paxos.SlotID(slotMarker), state)
+	appState, err := s.streamAppState()
+	if err != nil {
+		glog.Errorln("streaming application state failed, getting it whole:", err)
+	}
+	if appState.File == "" {
+		slotMarker, state := s.ah.GetState(uint(s.localAru.Value()))
+		glog.V(2).Infoln("received state from application,",
+			"size was", len(state), "bytes and slot marker", slotMarker)
+		appState = app.NewState(paxos.SlotID(slotMarker), state)
+	}
+	sessions, err := s.sessions.encode()
+	if err != nil {
+		glog.Errorln("encoding session table failed:", err)
+	}
+	appState.Sessions = sessions
+	appState.Clock = s.clock
+	appState.Version = s.version()
+	if s.ld != nil {
+		transfer := s.ld.LatestTransfer()
+		appState.TransferTo, appState.TransferEpoch = transfer.To, transfer.Epoch
+	}
 	asreq.RespChan() <- appState
 }
 
+// streamAppState writes the state of an application implementing
+// app.Streamer to a file in stateSpoolDir, so that it is never held in
+// memory. The receiver of the state removes the file.
+func (s *Server) streamAppState() (app.State, error) {
+	streamer, ok := s.ah.(app.Streamer)
+	if !ok {
+		return app.State{}, nil
+	}
+	f, err := ioutil.TempFile(s.config.GetString("stateSpoolDir", config.DefStateSpoolDir), "goxos-state-")
+	if err != nil {
+		return app.State{}, err
+	}
+	slotMarker, err := streamer.WriteState(uint(s.localAru.Value()), f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return app.State{}, err
+	}
+	glog.V(2).Infoln("application streamed its state to", f.Name(),
+		"with slot marker", slotMarker)
+	return app.State{SlotMarker: paxos.SlotID(slotMarker), File: f.Name()}, nil
+}
+
 func (s *Server) startLogThroughput(stop <-chan bool) {
 	interval := s.config.GetDuration("throughputSamplingInterval", config.DefThroughputSamplingInterval)
 	if interval == 0 {
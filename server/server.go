@@ -15,6 +15,7 @@ import (
 	"github.com/relab/goxos/paxos"
 	"github.com/relab/goxos/reconfig"
 	"github.com/relab/goxos/ringreplacer"
+	"github.com/relab/goxos/trace"
 )
 
 // A Server is the main module that maintains communication with all of the
@@ -44,6 +45,7 @@ type Server struct {
 	pxLeaderChan       <-chan grp.ID
 	propChan           chan *paxos.Value
 	decidedChan        chan *paxos.Value
+	rejectedChan       chan *client.Request // Requests the protocol cannot order
 	propDcdChan        chan bool
 	appStateReqChan    chan app.StateReq
 	clientHandler      client.ClientHandler
@@ -56,8 +58,29 @@ type Server struct {
 	reconfigCmdChan    chan paxos.ReconfigCmd
 	recMsgChan         chan ringreplacer.ReconfMsg
 	localAru           *paxos.Adu
+	clock              int64 // Execution clock, see tick
 	firstSlot          paxos.SlotID
 	ah                 app.Handler
+	versions           *app.Versions // nil if the application is not versioned
+	upgradeChan        chan uint32
+	sessions           *sessionTable
+	exec               *executor
+	hashes             hashCheck
+	sessionTimeout     time.Duration
+	lastActive         map[string]time.Time
+	expiryChan         chan SessionExpiry
+	followerReads      bool
+	readWaitTimeout    time.Duration
+	readStatusChan     chan ReadStatus
+	readStatusInterval time.Duration
+	readReports        map[grp.ID]readReport // Latest ReadStatus of each replica
+	upToDateAt         time.Time
+	pendingReads       []pendingRead
+	local              *localClient
+	localRoute         localRoute
+	forwardChan        chan ForwardedRequest
+	status             statusState
+	fdChan             <-chan liveness.FdMsg
 	stopChan           chan bool
 	subModulesStopSync *sync.WaitGroup
 	batchTimeout       time.Duration
@@ -65,6 +88,10 @@ type Server struct {
 	batchBuffer        []*client.Request
 	batchNextIndex     uint
 	batchTimer         time.Timer
+	replaying          bool
+	replayBatchTimeout chan bool
+	replayStamps       chan trace.ValueStamp
+	replaySinkStop     chan bool
 }
 
 // Create a new Server for an application.
@@ -90,13 +117,45 @@ func NewServer(id grp.ID, appID string, conf config.Config,
 		recMsgChan:         make(chan ringreplacer.ReconfMsg),
 		localAru:           &paxos.Adu{},
 		firstSlot:          1,
-		ah:                 ah,
+		upgradeChan:        make(chan uint32, 1),
+		sessions:           newSessionTable(conf.GetInt("sessionMaxReplies", config.DefSessionMaxReplies), conf.GetInt("sessionMaxExpired", config.DefSessionMaxExpired)),
 		stopChan:           make(chan bool),
 		subModulesStopSync: new(sync.WaitGroup),
+		status:             statusState{status: Status{ID: id, Leader: grp.UndefinedID()}},
 		batchTimeout:       conf.GetDuration("batchTimeout", config.DefBatchTimeout),
 		batchMaxSize:       uint(conf.GetInt("batchMaxSize", config.DefBatchMaxSize)),
 		batchTimer:         *time.NewTimer(0),
 	}
+	s.setHandler(ah)
 	s.initNodeMap()
 	return s
 }
+
+// SetSessions installs the client session table received together with the
+// application state from another replica.
+func (s *Server) SetSessions(sessions []byte) error {
+	return s.sessions.decode(sessions)
+}
+
+// SetLeaderTransfer hands the latest leadership transfer received together
+// with the application state from another replica to the leader detector.
+// Must be called after the modules are initialized.
+func (s *Server) SetLeaderTransfer(to grp.ID, epoch uint64) {
+	if s.ld != nil {
+		s.ld.SetLatestTransfer(liveness.LeaderTransfer{To: to, Epoch: epoch})
+	}
+}
+
+// TransferLeadership moves Paxos leadership to the replica with the given
+// Paxos id. See liveness.LeaderDetector.
+func (s *Server) TransferLeadership(to grp.PaxosID) error {
+	if s.ld == nil {
+		return liveness.ErrTransferUnavailable
+	}
+	for _, id := range s.grpmgr.NodeMap().IDs() {
+		if id.PaxosID == to {
+			return s.ld.TransferLeadership(id)
+		}
+	}
+	return liveness.ErrTransferTarget
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/relab/goxos/client"
+)
+
+// A sessionTable records, for every client, the responses to the commands
+// the client has not yet acknowledged. It is updated only when decided
+// values are executed, so all replicas hold the same table, and it is
+// transferred together with the application state. A command that is
+// decided more than once, for example because the client resent it to a new
+// leader, is therefore executed at most once.
+//
+// A session is registered by the first command of a client, and ends when an
+// EXPIRE request naming the client is executed. Expiries counts the executed
+// EXPIRE requests, which are numbered from zero. The ids of the latest
+// maxExpired ended sessions are kept in expired, oldest first, so that a
+// command resent after its session ended is not executed again even if the
+// client does not acknowledge responses.
+type sessionTable struct {
+	maxReplies int
+	maxExpired int
+	sessions   map[string]*session
+	expiries   uint32
+	expired    []string
+	isExpired  map[string]bool
+}
+
+// A session holds the state of one client. All commands with a sequence
+// number below Acked have been answered and are no longer resent by the
+// client. Replies holds the responses to executed commands from Acked on.
+type session struct {
+	Acked   uint32
+	Replies map[uint32][]byte
+}
+
+// sessionState is the encoded form of a sessionTable.
+type sessionState struct {
+	Sessions map[string]*session
+	Expiries uint32
+	Expired  []string
+}
+
+// The status of a command according to the session table.
+type cmdStatus int
+
+const (
+	cmdNew      cmdStatus = iota // not executed before
+	cmdExecuted                  // executed, the response is cached
+	cmdOld                       // executed and acknowledged by the client
+	cmdExpired                   // from a client whose session has ended
+)
+
+func newSessionTable(maxReplies, maxExpired int) *sessionTable {
+	return &sessionTable{
+		maxReplies: maxReplies,
+		maxExpired: maxExpired,
+		sessions:   make(map[string]*session),
+		isExpired:  make(map[string]bool),
+	}
+}
+
+// lookup returns the status of req, and the cached response if it has been
+// executed. A client that has no session has had its session expired only
+// if its id is among the expired ones; its first commands may not have been
+// decided, so the sequence number and acknowledgement of req do not tell.
+func (st *sessionTable) lookup(req *client.Request) ([]byte, cmdStatus) {
+	sess, ok := st.sessions[req.GetId()]
+	if !ok {
+		if st.isExpired[req.GetId()] {
+			return nil, cmdExpired
+		}
+		return nil, cmdNew
+	}
+	sess.ack(req.GetAck())
+	if req.GetSeq() < sess.Acked {
+		return nil, cmdOld
+	}
+	if resp, found := sess.Replies[req.GetSeq()]; found {
+		return resp, cmdExecuted
+	}
+	return nil, cmdNew
+}
+
+// record stores the response to an executed command.
+func (st *sessionTable) record(req *client.Request, resp []byte) {
+	sess, ok := st.sessions[req.GetId()]
+	if !ok {
+		sess = &session{Replies: make(map[uint32][]byte)}
+		st.sessions[req.GetId()] = sess
+	}
+	if sess.Replies == nil {
+		// gob does not transmit empty maps
+		sess.Replies = make(map[uint32][]byte)
+	}
+	sess.ack(req.GetAck())
+	if req.GetSeq() < sess.Acked {
+		// Acknowledged while it was being executed
+		return
+	}
+	sess.Replies[req.GetSeq()] = resp
+	// Clients that do not acknowledge responses would make the session
+	// grow forever, so we forget the oldest responses beyond maxReplies.
+	for st.maxReplies > 0 && len(sess.Replies) > st.maxReplies {
+		sess.ack(sess.oldest() + 1)
+	}
+}
+
+// expire ends the sessions of ids if seq is the number of the next EXPIRE
+// request. It returns false for EXPIRE requests that have been executed
+// before.
+func (st *sessionTable) expire(seq uint32, ids []string) bool {
+	if seq != st.expiries {
+		return false
+	}
+	st.expiries++
+	for _, id := range ids {
+		delete(st.sessions, id)
+		if !st.isExpired[id] {
+			st.isExpired[id] = true
+			st.expired = append(st.expired, id)
+		}
+	}
+	for st.maxExpired > 0 && len(st.expired) > st.maxExpired {
+		delete(st.isExpired, st.expired[0])
+		st.expired = st.expired[1:]
+	}
+	return true
+}
+
+// ended returns true if the session of id is among the expired ones.
+func (st *sessionTable) ended(id string) bool {
+	return st.isExpired[id]
+}
+
+// ack discards the responses to all commands below seq.
+func (sess *session) ack(seq uint32) {
+	if seq <= sess.Acked {
+		return
+	}
+	sess.Acked = seq
+	for s := range sess.Replies {
+		if s < seq {
+			delete(sess.Replies, s)
+		}
+	}
+}
+
+func (sess *session) oldest() uint32 {
+	first := true
+	var oldest uint32
+	for s := range sess.Replies {
+		if first || s < oldest {
+			oldest, first = s, false
+		}
+	}
+	return oldest
+}
+
+func (st *sessionTable) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	state := sessionState{Sessions: st.sessions, Expiries: st.expiries, Expired: st.expired}
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (st *sessionTable) decode(b []byte) error {
+	var state sessionState
+	if len(b) > 0 {
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&state); err != nil {
+			return err
+		}
+	}
+	if state.Sessions == nil {
+		state.Sessions = make(map[string]*session)
+	}
+	st.sessions, st.expiries, st.expired = state.Sessions, state.Expiries, state.Expired
+	st.isExpired = make(map[string]bool)
+	for _, id := range st.expired {
+		st.isExpired[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/relab/goxos/client"
+)
+
+func sessionReq(id string, seq, ack uint32) *client.Request {
+	return &client.Request{
+		Type: client.Request_EXEC.Enum(),
+		Id:   &id,
+		Seq:  &seq,
+		Ack:  &ack,
+	}
+}
+
+func TestSessionTableAtMostOnce(t *testing.T) {
+	st := newSessionTable(0, 0)
+	if _, status := st.lookup(sessionReq("c", 0, 0)); status != cmdNew {
+		t.Fatalf("got status %v for new command, want %v", status, cmdNew)
+	}
+	st.record(sessionReq("c", 0, 0), []byte("r0"))
+	st.record(sessionReq("c", 1, 0), []byte("r1"))
+
+	resp, status := st.lookup(sessionReq("c", 0, 0))
+	if status != cmdExecuted || !bytes.Equal(resp, []byte("r0")) {
+		t.Errorf("got cached response %q (status %v), want %q", resp, status, "r0")
+	}
+
+	// Acknowledging seq 0 forgets its response
+	if _, status := st.lookup(sessionReq("c", 0, 1)); status != cmdOld {
+		t.Errorf("got status %v for acknowledged command, want %v", status, cmdOld)
+	}
+	if _, status := st.lookup(sessionReq("c", 1, 1)); status != cmdExecuted {
+		t.Error("unacknowledged response was forgotten")
+	}
+}
+
+func TestSessionTableFirstCommandLost(t *testing.T) {
+	// The first command of c failed before it was decided, so its second
+	// command acknowledges it without a session having been registered.
+	st := newSessionTable(0, 0)
+	if _, status := st.lookup(sessionReq("c", 1, 1)); status != cmdNew {
+		t.Fatalf("got status %v for second command of new client, want %v", status, cmdNew)
+	}
+	st.record(sessionReq("c", 1, 1), []byte("r1"))
+	if resp, status := st.lookup(sessionReq("c", 1, 1)); status != cmdExecuted || string(resp) != "r1" {
+		t.Errorf("got cached response %q (status %v), want %q", resp, status, "r1")
+	}
+}
+
+func TestSessionTableMaxReplies(t *testing.T) {
+	st := newSessionTable(2, 0)
+	for seq := uint32(0); seq < 4; seq++ {
+		st.record(sessionReq("c", seq, 0), nil)
+	}
+	if _, status := st.lookup(sessionReq("c", 1, 0)); status != cmdOld {
+		t.Errorf("got status %v for evicted command, want %v", status, cmdOld)
+	}
+	if _, status := st.lookup(sessionReq("c", 3, 0)); status != cmdExecuted {
+		t.Error("latest response was evicted")
+	}
+}
+
+func TestSessionTableExpire(t *testing.T) {
+	st := newSessionTable(0, 0)
+	st.record(sessionReq("a", 0, 0), nil)
+	st.record(sessionReq("b", 0, 0), nil)
+
+	if !st.expire(0, []string{"a"}) {
+		t.Fatal("first expiry was not executed")
+	}
+	if st.expire(0, []string{"b"}) {
+		t.Error("duplicate expiry was executed")
+	}
+	if _, status := st.lookup(sessionReq("a", 1, 1)); status != cmdExpired {
+		t.Errorf("got status %v for expired session, want %v", status, cmdExpired)
+	}
+	if _, status := st.lookup(sessionReq("b", 1, 1)); status != cmdNew {
+		t.Errorf("got status %v for live session, want %v", status, cmdNew)
+	}
+	// A client that does not acknowledge responses cannot register anew,
+	// since its command may have been executed before the expiry
+	if _, status := st.lookup(sessionReq("a", 0, 0)); status != cmdExpired {
+		t.Errorf("got status %v for resent command, want %v", status, cmdExpired)
+	}
+}
+
+func TestSessionTableMaxExpired(t *testing.T) {
+	st := newSessionTable(0, 2)
+	for i, id := range []string{"a", "b", "c"} {
+		st.record(sessionReq(id, 0, 0), nil)
+		st.expire(uint32(i), []string{id})
+	}
+	if _, status := st.lookup(sessionReq("a", 0, 0)); status != cmdNew {
+		t.Errorf("got status %v for forgotten session, want %v", status, cmdNew)
+	}
+	if _, status := st.lookup(sessionReq("c", 0, 0)); status != cmdExpired {
+		t.Errorf("got status %v for expired session, want %v", status, cmdExpired)
+	}
+
+	b, err := st.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed := newSessionTable(0, 2)
+	if err := installed.decode(b); err != nil {
+		t.Fatal(err)
+	}
+	if !installed.ended("b") || installed.ended("a") {
+		t.Errorf("got expired sessions %v after state transfer, want [b c]", installed.expired)
+	}
+}
+
+func TestSessionTableEncode(t *testing.T) {
+	st := newSessionTable(0, 0)
+	st.record(sessionReq("a", 0, 0), []byte("x"))
+	st.record(sessionReq("b", 5, 5), nil)
+	st.expire(0, nil)
+	b, err := st.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	installed := newSessionTable(0, 0)
+	if err := installed.decode(b); err != nil {
+		t.Fatal(err)
+	}
+	resp, status := installed.lookup(sessionReq("a", 0, 0))
+	if status != cmdExecuted || !bytes.Equal(resp, []byte("x")) {
+		t.Errorf("got %q (status %v) after state transfer, want %q", resp, status, "x")
+	}
+	if _, status := installed.lookup(sessionReq("b", 4, 0)); status != cmdOld {
+		t.Errorf("got status %v after state transfer, want %v", status, cmdOld)
+	}
+	if installed.expiries != 1 {
+		t.Errorf("got %d expiries after state transfer, want 1", installed.expiries)
+	}
+	installed.record(sessionReq("b", 6, 5), nil)
+}
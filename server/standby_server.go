@@ -40,10 +40,13 @@ func NewStandbyServer(id grp.ID, appID string, nodemap grp.NodeMap,
 		recMsgChan:         make(chan ringreplacer.ReconfMsg),
 		localAru:           paxos.NewAdu(slotMarker),
 		firstSlot:          slotMarker + 1,
-		ah:                 ah,
+		upgradeChan:        make(chan uint32, 1),
+		sessions:           newSessionTable(conf.GetInt("sessionMaxReplies", config.DefSessionMaxReplies), conf.GetInt("sessionMaxExpired", config.DefSessionMaxExpired)),
 		stopChan:           make(chan bool),
 		subModulesStopSync: new(sync.WaitGroup),
+		status:             statusState{status: Status{ID: id, Leader: grp.UndefinedID()}},
 	}
+	s.setHandler(ah)
 
 	switch strings.ToLower(conf.GetString("failureHandlingType", config.DefFailureHandlingType)) {
 	case "livereplacement", "areconfiguration":
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/liveness"
+	"github.com/relab/goxos/paxos"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+// How often the server checks whether the membership has changed.
+const membershipCheckInterval = time.Second
+
+// A Status describes what a replica knows about itself and its group.
+type Status struct {
+	ID          grp.ID
+	Leader      grp.ID
+	AppliedSlot paxos.SlotID // Every slot up to this one has been executed
+	Epoch       grp.Epoch    // The highest epoch of any member
+	Members     []grp.ID     // Ordered by Paxos id
+	Divergences uint64       // State digests of other replicas that differed from ours
+	Quarantined bool         // Our state diverged from that of a majority
+	Version     uint32       // Version of the application, see app.Versions
+}
+
+// The type of an Event.
+type EventType int
+
+const (
+	LeaderChanged EventType = iota // ID is the new leader
+	Reconfigured                   // The members have changed; ID is this replica
+	Suspected                      // ID is suspected to have failed
+	Restored                       // ID is no longer suspected
+	Diverged                       // The state of ID differs from ours
+	Upgraded                       // The application version has changed; ID is this replica
+)
+
+var eventTypeNames = map[EventType]string{
+	LeaderChanged: "LeaderChanged",
+	Reconfigured:  "Reconfigured",
+	Suspected:     "Suspected",
+	Restored:      "Restored",
+	Diverged:      "Diverged",
+	Upgraded:      "Upgraded",
+}
+
+func (t EventType) String() string {
+	if name, found := eventTypeNames[t]; found {
+		return name
+	}
+	return "Unknown"
+}
+
+// An Event is a change of the Status of a replica, or a suspicion raised by
+// its failure detector.
+type Event struct {
+	Type  EventType
+	ID    grp.ID
+	Epoch grp.Epoch
+}
+
+// statusState is the part of the Status that is updated by the server.
+type statusState struct {
+	sync.Mutex
+	status      Status
+	membID      []grp.ID
+	subscribers map[string]chan Event
+}
+
+// Status returns the current status of the replica. It may be called from
+// any goroutine.
+func (s *Server) Status() Status {
+	s.status.Lock()
+	defer s.status.Unlock()
+	status := s.status.status
+	status.Members = append([]grp.ID(nil), status.Members...)
+	status.AppliedSlot = s.localAru.Value()
+	return status
+}
+
+// SubscribeToEvents returns a channel on which the changes of the status of
+// the replica are delivered. Events are dropped if the channel is full, so
+// a subscriber should call Status if it needs to know the current state.
+func (s *Server) SubscribeToEvents(name string) <-chan Event {
+	s.status.Lock()
+	defer s.status.Unlock()
+	if s.status.subscribers == nil {
+		s.status.subscribers = make(map[string]chan Event)
+	}
+	events := make(chan Event, 32)
+	s.status.subscribers[name] = events
+	return events
+}
+
+// publish must be called with status held.
+func (s *Server) publish(ev Event) {
+	for name, events := range s.status.subscribers {
+		select {
+		case events <- ev:
+		default:
+			glog.Warningf("event subscriber %s is full, dropping %v event", name, ev.Type)
+		}
+	}
+}
+
+func (s *Server) setLeader(leader grp.ID) {
+	s.status.Lock()
+	defer s.status.Unlock()
+	if s.status.status.Leader == leader {
+		return
+	}
+	s.status.status.Leader = leader
+	s.publish(Event{Type: LeaderChanged, ID: leader, Epoch: s.status.status.Epoch})
+}
+
+// diverged counts and publishes a state digest of id that differs from ours.
+func (s *Server) diverged(id grp.ID) {
+	s.status.Lock()
+	defer s.status.Unlock()
+	s.status.status.Divergences++
+	s.publish(Event{Type: Diverged, ID: id, Epoch: s.status.status.Epoch})
+}
+
+// updateMembership publishes a Reconfigured event if the members or our own
+// id have changed since the last check.
+func (s *Server) updateMembership() {
+	id := s.grpmgr.GetID()
+	ids := append([]grp.ID(nil), s.grpmgr.NodeMap().IDs()...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i].PaxosID < ids[j].PaxosID })
+	var epoch grp.Epoch
+	for _, member := range ids {
+		if member.Epoch > epoch {
+			epoch = member.Epoch
+		}
+	}
+
+	s.status.Lock()
+	defer s.status.Unlock()
+	if id == s.status.status.ID && equalIDs(ids, s.status.membID) {
+		return
+	}
+	first := s.status.membID == nil
+	s.status.status.ID, s.status.status.Epoch, s.status.status.Members = id, epoch, ids
+	s.status.membID = ids
+	if !first {
+		s.publish(Event{Type: Reconfigured, ID: id, Epoch: epoch})
+	}
+}
+
+func equalIDs(a, b []grp.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// watchStatus publishes the suspicions of the failure detector, and checks
+// the membership for changes made by the failure handlers, until stop is
+// closed. The failure detector blocks until its messages are read, so they
+// are not read by the main loop.
+func (s *Server) watchStatus(stop <-chan struct{}) {
+	s.updateMembership()
+	ticker := time.NewTicker(membershipCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-s.fdChan:
+			s.status.Lock()
+			switch msg.Event {
+			case liveness.Suspect:
+				s.publish(Event{Type: Suspected, ID: msg.ID, Epoch: s.status.status.Epoch})
+			case liveness.Restore:
+				s.publish(Event{Type: Restored, ID: msg.ID, Epoch: s.status.status.Epoch})
+			}
+			s.status.Unlock()
+		case <-ticker.C:
+			s.updateMembership()
+		case <-stop:
+			return
+		}
+	}
+}
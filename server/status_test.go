@@ -0,0 +1,57 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
+)
+
+func statusNodes(ids ...grp.ID) map[grp.ID]grp.Node {
+	nodes := make(map[grp.ID]grp.Node)
+	for _, id := range ids {
+		nodes[id] = grp.NewNode("127.0.0.1", "0", "0", true, true, true)
+	}
+	return nodes
+}
+
+func TestStatusEvents(t *testing.T) {
+	id0, id1, id2 := grp.NewID(0, 0), grp.NewID(1, 0), grp.NewID(2, 0)
+	gm := grp.NewGrpMgr(id0, grp.NewNodeMap(statusNodes(id0, id1, id2)), false, false, new(sync.WaitGroup))
+	s := &Server{
+		grpmgr:   gm,
+		localAru: &paxos.Adu{},
+		status:   statusState{status: Status{ID: id0, Leader: grp.UndefinedID()}},
+	}
+	events := s.SubscribeToEvents("test")
+	s.updateMembership()
+	if len(events) != 0 {
+		t.Errorf("got event %v for the initial membership", <-events)
+	}
+
+	s.setLeader(id1)
+	if ev := <-events; ev.Type != LeaderChanged || ev.ID != id1 {
+		t.Errorf("got event %v, want %v of %v", ev, LeaderChanged, id1)
+	}
+	s.setLeader(id1)
+	if len(events) != 0 {
+		t.Errorf("got event %v when the leader did not change", <-events)
+	}
+
+	// Replica 2 is replaced in epoch 1
+	replaced := grp.NewID(2, 1)
+	gm.SetNewNodeMap(statusNodes(id0, id1, replaced))
+	s.updateMembership()
+	if ev := <-events; ev.Type != Reconfigured || ev.Epoch != 1 {
+		t.Errorf("got event %v, want %v in epoch 1", ev, Reconfigured)
+	}
+	s.localAru.Increment()
+	status := s.Status()
+	if status.Leader != id1 || status.AppliedSlot != 1 || status.Epoch != 1 {
+		t.Errorf("got status %+v", status)
+	}
+	if len(status.Members) != 3 || status.Members[2] != replaced {
+		t.Errorf("got members %v, want %v last", status.Members, replaced)
+	}
+}
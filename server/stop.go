@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/trace"
 
 	"github.com/relab/goxos/elog"
 	e "github.com/relab/goxos/elog/event"
@@ -44,16 +45,27 @@ func (s *Server) Stop() error {
 
 func (s *Server) stop() {
 	glog.V(1).Info("signaling stop to submodules")
+	s.drainExecution()
 	s.grpmgr.Stop()
 	s.networkStop()
 	s.paxosStop()
 	s.livenessStop()
 	s.clientHandler.Stop()
+	if s.local != nil {
+		s.local.stop()
+	}
 	s.failureHandlingStop()
+	if err := trace.Close(); err != nil {
+		glog.Errorln("closing trace file failed:", err)
+	}
 }
 
 func (s *Server) networkStop() {
 	s.dmx.Stop()
+	if s.replaying {
+		s.replaySinkStop <- true
+		return
+	}
 	s.snd.Stop()
 }
 
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/config"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+var (
+	ErrNotVersioned       = errors.New("application is not versioned")
+	ErrNotLeader          = errors.New("replica is not the leader")
+	ErrUpgradePending     = errors.New("an upgrade is already being proposed")
+	ErrUpgradeUnsupported = errors.New("protocol cannot order upgrades")
+)
+
+// setHandler sets the application of the server. If ah is the Versions of
+// an application, the current version is executed, and the version is
+// changed by upgrades.
+func (s *Server) setHandler(ah app.Handler) {
+	if versions, ok := ah.(*app.Versions); ok {
+		s.versions = versions
+		s.status.status.Version, ah = versions.Current()
+	}
+	s.ah = ah
+}
+
+// Upgrade proposes that the replicas switch to version of the application.
+// It must be called at the leader, and the version must have been added to
+// the Versions of every replica. The replicas switch when the upgrade is
+// decided, which is published as an Upgraded event; the proposal is lost if
+// the leader changes before then. Upgrades are not supported by BatchPaxos
+// and the broadcast protocols.
+func (s *Server) Upgrade(version uint32) error {
+	if s.versions == nil {
+		return ErrNotVersioned
+	}
+	if !s.versions.Has(version) {
+		return app.UnknownVersionError(version)
+	}
+	if !s.ordersUpgrades() {
+		return ErrUpgradeUnsupported
+	}
+	if s.Status().Leader != s.id {
+		return ErrNotLeader
+	}
+	select {
+	case s.upgradeChan <- version:
+		return nil
+	default:
+		return ErrUpgradePending
+	}
+}
+
+// ordersUpgrades returns true if the protocol can decide Upgrade values.
+// BatchPaxos only orders client requests, and the broadcast protocols have no
+// leader to propose them.
+func (s *Server) ordersUpgrades() bool {
+	switch strings.TrimSpace(strings.ToLower(s.config.GetString("protocol", config.DefProtocol))) {
+	case "batchpaxos", "authenticatedbc", "reliablebc":
+		return false
+	}
+	return true
+}
+
+// upgrade switches to version at the slot its upgrade was decided in. A
+// replica that cannot switch stops, since it would diverge from the others.
+// State digests are only taken after the upgrade if they were taken before.
+func (s *Server) upgrade(version uint32) {
+	if s.versions == nil {
+		glog.Fatalf("upgrade to version %d decided at slot %v, but the application is not versioned",
+			version, s.localAru.Value()+1)
+	}
+	if err := s.versions.Upgrade(version); err != nil {
+		glog.Fatalf("upgrade decided at slot %v failed: %v", s.localAru.Value()+1, err)
+	}
+	glog.V(1).Infof("upgraded application to version %d at slot %v", version, s.localAru.Value()+1)
+	_, s.ah = s.versions.Current()
+	s.exec = nil
+	s.initExecutor()
+	if s.hashes.digestChan != nil {
+		s.hashes.hasher, _ = s.ah.(app.Hasher)
+	}
+
+	s.status.Lock()
+	defer s.status.Unlock()
+	s.status.status.Version = version
+	s.publish(Event{Type: Upgraded, ID: s.id, Epoch: s.status.status.Epoch})
+}
+
+// version returns the version of the application, or zero if it is not
+// versioned.
+func (s *Server) version() uint32 {
+	s.status.Lock()
+	defer s.status.Unlock()
+	return s.status.status.Version
+}
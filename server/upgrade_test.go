@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/relab/goxos/app"
+	"github.com/relab/goxos/client"
+	"github.com/relab/goxos/config"
+	"github.com/relab/goxos/grp"
+	"github.com/relab/goxos/paxos"
+)
+
+// tagApp appends its tag to its state for every command, and responds with
+// the state.
+type tagApp struct {
+	tag   string
+	state string
+}
+
+func (a *tagApp) Execute(req []byte) []byte {
+	a.state += a.tag
+	return []byte(a.state)
+}
+
+func (a *tagApp) GetState(sm uint) (uint, []byte) { return sm, []byte(a.state) }
+func (a *tagApp) SetState(state []byte) error     { a.state = string(state); return nil }
+
+func TestUpgrade(t *testing.T) {
+	v1, v2 := &tagApp{tag: "a"}, &tagApp{tag: "b"}
+	s := execServer(nil, &client.ClientHandlerMock{})
+	s.id = grp.NewID(0, 0)
+	s.status = statusState{status: Status{Leader: grp.UndefinedID()}}
+	s.setHandler(app.NewVersions(1, v1).Add(2, v2))
+	events := s.SubscribeToEvents("test")
+	var seq uint32
+	decide := func() {
+		s.handleDecidedVal(&paxos.Value{Vt: paxos.App, Cr: []*client.Request{sessionReq("c", seq, 0)}}, false)
+		seq++
+	}
+
+	decide()
+	decide()
+	s.handleDecidedVal(&paxos.Value{Vt: paxos.Upgrade, Version: 2}, false)
+	decide()
+
+	if v2.state != "aab" {
+		t.Errorf("state of version 2 is %q, want %q", v2.state, "aab")
+	}
+	if s.ah != v2 {
+		t.Error("version 2 is not executing commands")
+	}
+	if status := s.Status(); status.Version != 2 || status.AppliedSlot != 4 {
+		t.Errorf("status has version %d at slot %v, want version 2 at slot 4", status.Version, status.AppliedSlot)
+	}
+	select {
+	case ev := <-events:
+		if ev.Type != Upgraded {
+			t.Errorf("got %v event, want %v", ev.Type, Upgraded)
+		}
+	default:
+		t.Error("no event published for the upgrade")
+	}
+
+	if err := s.Upgrade(3); err != app.UnknownVersionError(3) {
+		t.Errorf("upgrade to a missing version returned %v", err)
+	}
+	if err := s.Upgrade(1); err != ErrNotLeader {
+		t.Errorf("upgrade at a follower returned %v, want %v", err, ErrNotLeader)
+	}
+}
+
+func TestUpgradeUnsupported(t *testing.T) {
+	id := grp.NewID(0, 0)
+	s := execServer(nil, &client.ClientHandlerMock{})
+	s.id, s.config, s.upgradeChan = id, *config.NewConfig(), make(chan uint32, 1)
+	s.status = statusState{status: Status{Leader: id}}
+	s.setHandler(app.NewVersions(1, &tagApp{tag: "a"}).Add(2, &tagApp{tag: "b"}))
+	for _, protocol := range []string{"batchpaxos", "authenticatedbc", "reliablebc"} {
+		s.config.Set("protocol", protocol)
+		if err := s.Upgrade(2); err != ErrUpgradeUnsupported {
+			t.Errorf("upgrade with %s returned %v, want %v", protocol, err, ErrUpgradeUnsupported)
+		}
+	}
+	s.config.Set("protocol", "multipaxos")
+	if err := s.Upgrade(2); err != nil {
+		t.Errorf("upgrade with multipaxos returned %v", err)
+	}
+}
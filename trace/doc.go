@@ -0,0 +1,16 @@
+/*
+Package trace records the inputs of a replica to a trace file so that an
+execution can later be replayed in isolation.
+
+Every message delivered by the replica demuxer, every client request handed
+to the server, every heartbeat alive mark, every timer expiry, every trust
+event published by the leader detector and the time and seed of every value
+proposed by the replica is appended to the trace as an Entry.
+Entries are numbered in the order they are recorded, so the order in which
+the inputs were observed is preserved in the file.
+
+Tracing is enabled for a replica by setting the `traceDir` config value. A
+recorded trace can be fed back to a replica using goxos.Replica.Replay, or
+printed using the tracefmt utility.
+*/
+package trace
@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/relab/goxos/grp"
+)
+
+// Kind identifies the type of input recorded in an Entry.
+type Kind uint8
+
+const (
+	Unknown       Kind = 0
+	Message       Kind = 1 // Message delivered by the demuxer
+	Alive         Kind = 2 // Heartbeat alive mark for a replica
+	ClientRequest Kind = 3 // Client request received by the server
+	Timer         Kind = 4 // Expiry of a named timer
+	Trust         Kind = 5 // Trust event published by the leader detector
+	Stamp         Kind = 6 // Time and seed stamped on a proposed value
+)
+
+var kinds = [...]string{
+	"Unknown",
+	"Message",
+	"Alive",
+	"ClientRequest",
+	"Timer",
+	"Trust",
+	"Stamp",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kinds) {
+		return kinds[k]
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Names used for Timer and Trust entries.
+const (
+	FdTimer          = "fd"
+	BatchTimer       = "batch"
+	PaxosTrust       = "paxos"
+	ReplacementTrust = "replacement"
+)
+
+// A ValueStamp is the time and random seed a proposed value was stamped
+// with. They are inputs, since they differ between runs.
+type ValueStamp struct {
+	Time int64
+	Seed int64
+}
+
+func init() {
+	gob.Register(ValueStamp{})
+}
+
+// An Entry is a single recorded input. Seq is assigned by the recorder and
+// is strictly increasing within a trace. ID is set for Alive and Trust
+// entries, Name for Timer and Trust entries, and Msg for Message,
+// ClientRequest and Stamp entries.
+type Entry struct {
+	Seq  uint64
+	Time time.Time
+	Kind Kind
+	ID   grp.ID
+	Name string
+	Msg  interface{}
+}
+
+const layout = "2006-01-02 15:04:05.999999999"
+
+func (e Entry) String() string {
+	switch e.Kind {
+	case Message, ClientRequest, Stamp:
+		return fmt.Sprintf("%6d %v:\t%14v %T", e.Seq, e.Time.Format(layout), e.Kind, e.Msg)
+	case Alive:
+		return fmt.Sprintf("%6d %v:\t%14v %v", e.Seq, e.Time.Format(layout), e.Kind, e.ID)
+	case Timer:
+		return fmt.Sprintf("%6d %v:\t%14v %v", e.Seq, e.Time.Format(layout), e.Kind, e.Name)
+	case Trust:
+		return fmt.Sprintf("%6d %v:\t%14v %v leader %v", e.Seq, e.Time.Format(layout), e.Kind, e.Name, e.ID)
+	default:
+		return fmt.Sprintf("%6d %v:\t%14v", e.Seq, e.Time.Format(layout), e.Kind)
+	}
+}
@@ -0,0 +1,9 @@
+package trace
+
+import (
+	"errors"
+)
+
+var (
+	ErrOutOfOrder = errors.New("trace: entry sequence numbers out of order")
+)
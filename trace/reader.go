@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+)
+
+// A Reader reads the entries of a trace file in recorded order.
+type Reader struct {
+	file *os.File
+	dec  *gob.Decoder
+	last uint64
+}
+
+// NewReader opens the trace file with the given name for reading.
+func NewReader(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		file: f,
+		dec:  gob.NewDecoder(bufio.NewReaderSize(f, bufferSize)),
+	}, nil
+}
+
+// Next returns the next entry of the trace. It returns io.EOF when every
+// entry has been read, and ErrOutOfOrder if the trace file is corrupt.
+func (r *Reader) Next() (Entry, error) {
+	var e Entry
+	if err := r.dec.Decode(&e); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// The replica crashed while writing the last entry.
+			return e, io.EOF
+		}
+		return e, err
+	}
+	if e.Seq <= r.last {
+		return e, ErrOutOfOrder
+	}
+	r.last = e.Seq
+	return e, nil
+}
+
+// Close closes the underlying trace file.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// Parse reads every entry of the trace file with the given name.
+func Parse(name string) ([]Entry, error) {
+	r, err := NewReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []Entry
+	for {
+		e, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
@@ -0,0 +1,144 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/relab/goxos/grp"
+
+	"github.com/relab/goxos/Godeps/_workspace/src/github.com/golang/glog"
+)
+
+const bufferSize = 1024 * 256
+
+var recorder traceRecorder
+
+type traceRecorder struct {
+	mu      sync.Mutex
+	enabled bool
+	seq     uint64
+	failed  bool
+	*bufio.Writer
+	*gob.Encoder
+	*os.File
+}
+
+// FileName returns the name of the trace file for the replica with the
+// given application id and replica id.
+func FileName(appID string, id grp.ID) string {
+	now := time.Now()
+	return fmt.Sprintf("%s.replica%v.%04d%02d%02d-%02d%02d%02d.trace",
+		appID,
+		id,
+		now.Year(),
+		now.Month(),
+		now.Day(),
+		now.Hour(),
+		now.Minute(),
+		now.Second())
+}
+
+// Open creates the trace file in dir and enables recording.
+func Open(dir, name string) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.enabled {
+		return fmt.Errorf("trace: already recording to %v", recorder.File.Name())
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	recorder.File = f
+	recorder.Writer = bufio.NewWriterSize(f, bufferSize)
+	recorder.Encoder = gob.NewEncoder(recorder.Writer)
+	recorder.seq = 0
+	recorder.failed = false
+	recorder.enabled = true
+	return nil
+}
+
+// IsEnabled reports whether inputs are currently being recorded.
+func IsEnabled() bool {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.enabled
+}
+
+// Close flushes all pending entries and closes the trace file.
+func Close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if !recorder.enabled {
+		return nil
+	}
+	recorder.enabled = false
+	if err := recorder.Writer.Flush(); err != nil {
+		recorder.File.Close()
+		return err
+	}
+	return recorder.File.Close()
+}
+
+// Flush flushes all pending entries to file.
+func Flush() {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.enabled {
+		recorder.Writer.Flush()
+		recorder.File.Sync()
+	}
+}
+
+func (tr *traceRecorder) record(e Entry) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if !tr.enabled {
+		return
+	}
+	tr.seq++
+	e.Seq = tr.seq
+	e.Time = time.Now()
+	if err := tr.Encoder.Encode(&e); err != nil && !tr.failed {
+		// Only report the first failure, the trace is incomplete
+		// from this point on anyway.
+		tr.failed = true
+		glog.Errorf("trace: recording %v entry failed: %v", e.Kind, err)
+	}
+}
+
+// RecordMessage records a message delivered by the demuxer.
+func RecordMessage(msg interface{}) {
+	recorder.record(Entry{Kind: Message, Msg: msg})
+}
+
+// RecordAlive records a heartbeat alive mark for replica id.
+func RecordAlive(id grp.ID) {
+	recorder.record(Entry{Kind: Alive, ID: id})
+}
+
+// RecordClientRequest records a client request received by the server.
+func RecordClientRequest(req interface{}) {
+	recorder.record(Entry{Kind: ClientRequest, Msg: req})
+}
+
+// RecordStamp records the time and seed a proposed value was stamped with.
+func RecordStamp(time, seed int64) {
+	recorder.record(Entry{Kind: Stamp, Msg: ValueStamp{Time: time, Seed: seed}})
+}
+
+// RecordTimer records the expiry of the timer with the given name.
+func RecordTimer(name string) {
+	recorder.record(Entry{Kind: Timer, Name: name})
+}
+
+// RecordTrust records that the leader detector now trusts id as the
+// leader of the given kind.
+func RecordTrust(name string, id grp.ID) {
+	recorder.record(Entry{Kind: Trust, Name: name, ID: id})
+}
@@ -0,0 +1,80 @@
+package trace
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/relab/goxos/grp"
+)
+
+type testMsg struct {
+	ID  grp.ID
+	Rnd uint
+}
+
+func init() {
+	gob.Register(testMsg{})
+}
+
+func TestRecordAndParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	id := grp.NewID(1, 0)
+	name := FileName("test", id)
+	if err = Open(dir, name); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEnabled() {
+		t.Fatal("recording not enabled after open")
+	}
+
+	RecordMessage(testMsg{ID: id, Rnd: 3})
+	RecordAlive(id)
+	RecordTimer(FdTimer)
+	RecordTrust(PaxosTrust, id)
+	if err = Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not recorded, the trace is closed.
+	RecordTimer(BatchTimer)
+
+	entries, err := Parse(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantKinds := []Kind{Message, Alive, Timer, Trust}
+	if len(entries) != len(wantKinds) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(wantKinds))
+	}
+	for i, e := range entries {
+		if e.Kind != wantKinds[i] {
+			t.Errorf("entry %d: got kind %v, want %v", i, e.Kind, wantKinds[i])
+		}
+		if e.Seq != uint64(i+1) {
+			t.Errorf("entry %d: got seq %d, want %d", i, e.Seq, i+1)
+		}
+	}
+
+	msg, ok := entries[0].Msg.(testMsg)
+	if !ok || msg.ID != id || msg.Rnd != 3 {
+		t.Errorf("got message %#v, want %#v", entries[0].Msg, testMsg{ID: id, Rnd: 3})
+	}
+	if entries[1].ID != id {
+		t.Errorf("got alive id %v, want %v", entries[1].ID, id)
+	}
+	if entries[2].Name != FdTimer {
+		t.Errorf("got timer %q, want %q", entries[2].Name, FdTimer)
+	}
+	if entries[3].Name != PaxosTrust || entries[3].ID != id {
+		t.Errorf("got trust %v/%v, want %v/%v", entries[3].Name, entries[3].ID, PaxosTrust, id)
+	}
+}
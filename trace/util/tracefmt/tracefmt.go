@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/relab/goxos/trace"
+
+	// Registers every message type that may be recorded in a trace.
+	_ "github.com/relab/goxos/server"
+)
+
+func main() {
+	var file = flag.String("file", "", "trace file to parse")
+	var verbose = flag.Bool("v", false, "print recorded messages in full")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	entries, err := trace.Parse(*file)
+	if err != nil {
+		fmt.Println("Error parsing trace:", err)
+		if len(entries) == 0 {
+			return
+		}
+	}
+
+	for _, entry := range entries {
+		fmt.Println(entry)
+		if *verbose && entry.Msg != nil {
+			fmt.Printf("\t%+v\n", entry.Msg)
+		}
+	}
+}